@@ -4,11 +4,17 @@ import (
 	"flag"
 	"log"
 	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
 
 	"github.com/VictorNine/bitwarden-go/internal/api"
 	"github.com/VictorNine/bitwarden-go/internal/auth"
 	"github.com/VictorNine/bitwarden-go/internal/common"
 	"github.com/VictorNine/bitwarden-go/internal/database/sqlite"
+	"github.com/VictorNine/bitwarden-go/internal/mail"
+	"github.com/VictorNine/bitwarden-go/internal/storage/local"
 )
 
 var cfg struct {
@@ -19,7 +25,21 @@ var cfg struct {
 	hostAddr            string
 	hostPort            string
 	disableRegistration bool
+	requireVerified     bool
 	vaultURL            string
+	publicURL           string
+	attachmentQuota     int64
+	sendQuota           int64
+	trashDays           int
+	mailFile            string
+	smtpHost            string
+	smtpPort            int
+	smtpUsername        string
+	smtpPassword        string
+	smtpFrom            string
+	kdfMinIterations    int
+	kdfMaxIterations    int
+	clientIPHeader      string
 }
 
 func init() {
@@ -30,7 +50,21 @@ func init() {
 	flag.StringVar(&cfg.hostAddr, "host", "", "Sets the interface that the application will listen on.")
 	flag.StringVar(&cfg.hostPort, "port", "8000", "Sets the port")
 	flag.StringVar(&cfg.vaultURL, "vaultURL", "", "Sets the vault proxy url")
+	flag.StringVar(&cfg.publicURL, "publicURL", "", "Sets the address of the server used in mailed links, like https://vault.example.com. Without it the address the client used is trusted.")
 	flag.BoolVar(&cfg.disableRegistration, "disableRegistration", false, "Disables user registration.")
+	flag.BoolVar(&cfg.requireVerified, "requireVerifiedEmail", false, "Stops accounts from logging in before they have verified their email. Needs a mail server.")
+	flag.Int64Var(&cfg.attachmentQuota, "attachmentQuota", 1024, "Sets the ammount of attachment storage (in MB) each user gets. 0 is unlimited.")
+	flag.Int64Var(&cfg.sendQuota, "sendQuota", 100, "Sets the ammount of storage (in MB) each user gets for files in Sends. 0 is unlimited.")
+	flag.IntVar(&cfg.trashDays, "trashDays", 30, "Sets the number of days deleted items are kept in the trash. 0 keeps them forever.")
+	flag.IntVar(&cfg.kdfMinIterations, "kdfMinIterations", 5000, "Sets the minimum number of KDF iterations accounts can use.")
+	flag.IntVar(&cfg.kdfMaxIterations, "kdfMaxIterations", 2000000, "Sets the maximum number of KDF iterations accounts can use.")
+	flag.StringVar(&cfg.clientIPHeader, "clientIPHeader", "", "Sets the header with the client address, like X-Forwarded-For, when running behind a reverse proxy. Without it all clients behind the proxy share the limit on password hints.")
+	flag.StringVar(&cfg.mailFile, "mailFile", "", "Writes mails like organization invitations to this file instead of sending them. Mails are written to stdout if neither this or smtpHost is set.")
+	flag.StringVar(&cfg.smtpHost, "smtpHost", "", "Sets the mail server used to send mails.")
+	flag.IntVar(&cfg.smtpPort, "smtpPort", 587, "Sets the port of the mail server.")
+	flag.StringVar(&cfg.smtpUsername, "smtpUsername", "", "Sets the username for the mail server.")
+	flag.StringVar(&cfg.smtpPassword, "smtpPassword", "", "Sets the password for the mail server.")
+	flag.StringVar(&cfg.smtpFrom, "smtpFrom", "", "Sets the address mails are sent from.")
 }
 
 func main() {
@@ -53,8 +87,43 @@ func main() {
 		}
 	}
 
-	authHandler := auth.New(db, cfg.signingKey, cfg.jwtExpire)
-	apiHandler := api.New(db)
+	// Attachments are stored next to the database
+	blobs := &local.Store{}
+	blobs.SetDir(path.Join(cfg.location, "attachments"))
+	err = blobs.Open()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Without a mail server the mails have to be forwarded by hand
+	var mailer interface {
+		Send(to string, subject string, body string) error
+	}
+	if cfg.smtpHost != "" && cfg.mailFile == "" {
+		if cfg.smtpFrom == "" {
+			log.Fatal("smtpFrom has to be set to send mails")
+		}
+		mailer = mail.NewSMTP(cfg.smtpHost, cfg.smtpPort, cfg.smtpUsername, cfg.smtpPassword, cfg.smtpFrom)
+	} else {
+		mailOut := os.Stdout
+		if cfg.mailFile != "" {
+			mailOut, err = os.OpenFile(cfg.mailFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer mailOut.Close()
+		}
+		mailer = mail.NewWriter(mailOut)
+	}
+
+	authHandler := auth.New(db, mailer, cfg.signingKey, cfg.jwtExpire)
+	authHandler.SetKdfIterations(cfg.kdfMinIterations, cfg.kdfMaxIterations)
+	authHandler.SetRequireVerifiedEmail(cfg.requireVerified)
+	authHandler.SetClientIPHeader(cfg.clientIPHeader)
+	authHandler.SetPublicURL(cfg.publicURL)
+	apiHandler := api.New(db, blobs, mailer, cfg.attachmentQuota*1024*1024)
+	apiHandler.SetSendQuota(cfg.sendQuota * 1024 * 1024)
+	apiHandler.SetPublicURL(cfg.publicURL)
 
 	mux := http.NewServeMux()
 
@@ -63,18 +132,54 @@ func main() {
 	}
 	mux.HandleFunc("/identity/connect/token", authHandler.HandleLogin)
 	mux.HandleFunc("/api/accounts/prelogin", authHandler.HandlePrelogin)
+	mux.HandleFunc("/api/accounts/password-hint", authHandler.HandlePasswordHint)
+	mux.HandleFunc("/api/accounts/verify-email-token", authHandler.HandleVerifyEmailToken)
 
+	mux.Handle("/api/accounts", authHandler.JwtMiddleware(http.HandlerFunc(apiHandler.HandleAccountDelete)))
+	mux.Handle("/api/accounts/delete", authHandler.JwtMiddleware(http.HandlerFunc(apiHandler.HandleAccountDelete)))
+	mux.Handle("/api/accounts/key", authHandler.JwtMiddleware(http.HandlerFunc(apiHandler.HandleKeyRotation)))
 	mux.Handle("/api/accounts/keys", authHandler.JwtMiddleware(http.HandlerFunc(apiHandler.HandleKeysUpdate)))
+	mux.Handle("/api/accounts/password", authHandler.JwtMiddleware(http.HandlerFunc(authHandler.HandlePasswordChange)))
+	mux.Handle("/api/accounts/kdf", authHandler.JwtMiddleware(http.HandlerFunc(authHandler.HandleKdfChange)))
+	mux.Handle("/api/accounts/security-stamp", authHandler.JwtMiddleware(http.HandlerFunc(authHandler.HandleSecurityStamp)))
+	mux.Handle("/api/accounts/email-token", authHandler.JwtMiddleware(http.HandlerFunc(authHandler.HandleEmailToken)))
+	mux.Handle("/api/accounts/email", authHandler.JwtMiddleware(http.HandlerFunc(authHandler.HandleEmailChange)))
+	mux.Handle("/api/accounts/verify-email", authHandler.JwtMiddleware(http.HandlerFunc(authHandler.HandleVerifyEmail)))
 	mux.Handle("/api/accounts/profile", authHandler.JwtMiddleware(http.HandlerFunc(apiHandler.HandleProfile)))
 	mux.Handle("/api/collections", authHandler.JwtMiddleware(http.HandlerFunc(apiHandler.HandleCollections)))
 	mux.Handle("/api/folders", authHandler.JwtMiddleware(http.HandlerFunc(apiHandler.HandleFolder)))
 	mux.Handle("/api/folders/", authHandler.JwtMiddleware(http.HandlerFunc(apiHandler.HandleFolderUpdate)))
 	mux.Handle("/apifolders", authHandler.JwtMiddleware(http.HandlerFunc(apiHandler.HandleFolder))) // The android app want's the address like this, will be fixed in the next version. Issue #174
+	mux.Handle("/api/organizations", authHandler.JwtMiddleware(http.HandlerFunc(apiHandler.HandleOrganization)))
+	mux.Handle("/api/organizations/", authHandler.JwtMiddleware(http.HandlerFunc(apiHandler.HandleOrganizationUpdate)))
+	mux.Handle("/api/emergency-access/", authHandler.JwtMiddleware(http.HandlerFunc(apiHandler.HandleEmergencyAccess)))
+	mux.Handle("/api/users/", authHandler.JwtMiddleware(http.HandlerFunc(apiHandler.HandleUserPublicKey)))
 	mux.Handle("/api/sync", authHandler.JwtMiddleware(http.HandlerFunc(apiHandler.HandleSync)))
 
 	mux.Handle("/api/ciphers/import", authHandler.JwtMiddleware(http.HandlerFunc(apiHandler.HandleImport)))
+	mux.Handle("/api/ciphers/delete", authHandler.JwtMiddleware(http.HandlerFunc(apiHandler.HandleCiphersDelete)))
+	mux.Handle("/api/ciphers/restore", authHandler.JwtMiddleware(http.HandlerFunc(apiHandler.HandleCiphersRestore)))
+	mux.Handle("/api/ciphers/move", authHandler.JwtMiddleware(http.HandlerFunc(apiHandler.HandleCiphersMove)))
+	mux.Handle("/api/ciphers/favorite", authHandler.JwtMiddleware(http.HandlerFunc(apiHandler.HandleCiphersFavorite)))
+	mux.Handle("/api/ciphers/purge", authHandler.JwtMiddleware(http.HandlerFunc(apiHandler.HandleCiphersPurge)))
+	mux.Handle("/api/ciphers/create", authHandler.JwtMiddleware(http.HandlerFunc(apiHandler.HandleCipherCreate)))
+	mux.Handle("/api/ciphers/share", authHandler.JwtMiddleware(http.HandlerFunc(apiHandler.HandleCiphersShare)))
 	mux.Handle("/api/ciphers", authHandler.JwtMiddleware(http.HandlerFunc(apiHandler.HandleCipher)))
 	mux.Handle("/api/ciphers/", authHandler.JwtMiddleware(http.HandlerFunc(apiHandler.HandleCipherUpdate)))
+	mux.HandleFunc("/attachments/", apiHandler.HandleAttachmentDownload)
+
+	mux.Handle("/api/sends", authHandler.JwtMiddleware(http.HandlerFunc(apiHandler.HandleSends)))
+	sendUpdate := authHandler.JwtMiddleware(http.HandlerFunc(apiHandler.HandleSendUpdate))
+	mux.HandleFunc("/api/sends/", func(w http.ResponseWriter, req *http.Request) {
+		// Anyone with the link can get the file, so /api/sends/{accessId}/access/file/{fileId} is not authenticated
+		if strings.Contains(req.URL.Path, "/access/file/") {
+			apiHandler.HandleSendFileAccess(w, req)
+			return
+		}
+		sendUpdate.ServeHTTP(w, req)
+	})
+	mux.HandleFunc("/api/sends/access/", apiHandler.HandleSendAccess)
+	mux.HandleFunc("/sendfiles/", apiHandler.HandleSendFileDownload)
 
 	if len(cfg.vaultURL) > 4 {
 		proxy := common.Proxy{VaultURL: cfg.vaultURL}
@@ -86,6 +191,21 @@ func main() {
 	mux.Handle("/api/two-factor/disable", authHandler.JwtMiddleware(http.HandlerFunc(authHandler.HandleDisableTwoFactor)))
 	mux.Handle("/api/two-factor", authHandler.JwtMiddleware(http.HandlerFunc(authHandler.HandleTwoFactor)))
 
+	if cfg.trashDays > 0 {
+		go runEvery(time.Hour, func() { apiHandler.PurgeTrash(cfg.trashDays) })
+	}
+	go runEvery(time.Hour, apiHandler.PurgePendingAttachments)
+	go runEvery(time.Hour, apiHandler.PurgeSends)
+	go runEvery(time.Hour, apiHandler.ApproveEmergencyAccess)
+
 	log.Println("Starting server on " + cfg.hostAddr + ":" + cfg.hostPort)
 	log.Fatal(http.ListenAndServe(cfg.hostAddr+":"+cfg.hostPort, mux))
 }
+
+// Runs f now and then every interval
+func runEvery(interval time.Duration, f func()) {
+	for {
+		f()
+		time.Sleep(interval)
+	}
+}
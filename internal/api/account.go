@@ -0,0 +1,100 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/VictorNine/bitwarden-go/internal/auth"
+	bw "github.com/VictorNine/bitwarden-go/internal/common"
+)
+
+// Looks up the account and checks the master password sent in the body
+func (h *APIHandler) passwordRequest(w http.ResponseWriter, req *http.Request) (bw.Account, bool) {
+	email := auth.GetEmail(req)
+
+	acc, err := h.db.GetAccount(email, "")
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(http.StatusText(http.StatusUnauthorized)))
+		log.Println(err)
+		return acc, false
+	}
+
+	decoder := json.NewDecoder(req.Body)
+	var reqData struct {
+		MasterPasswordHash string `json:"masterPasswordHash"`
+	}
+	err = decoder.Decode(&reqData)
+	if err != nil || !auth.VerifyPassword(acc, reqData.MasterPasswordHash) {
+		writeError(w, http.StatusBadRequest, "Invalid password")
+		log.Println("Wrong password from " + email)
+		return acc, false
+	}
+	defer req.Body.Close()
+
+	return acc, true
+}
+
+// Handles /api/accounts and /api/accounts/delete. Deletes the account and everything in the vault
+func (h *APIHandler) HandleAccountDelete(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "DELETE" && !(req.Method == "POST" && req.URL.Path == "/api/accounts/delete") {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte(http.StatusText(http.StatusMethodNotAllowed)))
+		return
+	}
+
+	acc, ok := h.passwordRequest(w, req)
+	if !ok {
+		return
+	}
+
+	// The sends are deleted with the account, but the files have to be removed after
+	sends, err := h.db.GetSends(acc.Id)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+		log.Println(err)
+		return
+	}
+
+	atts, err := h.db.DeleteAccount(acc.Id)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		log.Println(err)
+		return
+	}
+
+	h.deleteAttachmentData(atts)
+	h.deleteSendFiles(sends)
+
+	w.Write([]byte(""))
+	log.Println(acc.Email + " deleted the account")
+}
+
+// Handles /api/ciphers/purge. Deletes all personal ciphers and folders but keeps the account
+func (h *APIHandler) HandleCiphersPurge(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte(http.StatusText(http.StatusMethodNotAllowed)))
+		return
+	}
+
+	acc, ok := h.passwordRequest(w, req)
+	if !ok {
+		return
+	}
+
+	atts, err := h.db.PurgeVault(acc.Id)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+		log.Println(err)
+		return
+	}
+
+	h.deleteAttachmentData(atts)
+
+	w.Write([]byte(""))
+	log.Println(acc.Email + " purged the vault")
+}
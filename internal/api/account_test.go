@@ -0,0 +1,144 @@
+package api
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	bw "github.com/VictorNine/bitwarden-go/internal/common"
+)
+
+// Like the database, an organization is never left without an owner
+func (db *orgDB) DeleteAccount(userID string) ([]bw.Attachment, error) {
+	for _, ou := range db.orgUsers {
+		if ou.UserId == nil || *ou.UserId != userID || ou.Type != bw.OrgUserOwner || ou.Status != bw.OrgUserConfirmed {
+			continue
+		}
+
+		soleOwner := true
+		for _, o := range db.orgUsers {
+			if o.OrganizationId == ou.OrganizationId && o.Id != ou.Id && o.Type == bw.OrgUserOwner && o.Status == bw.OrgUserConfirmed {
+				soleOwner = false
+			}
+		}
+		if soleOwner {
+			return nil, errors.New("The account is the only owner of an organization")
+		}
+	}
+
+	var personal []string
+	for key, ciph := range db.ciphers {
+		if strings.HasPrefix(key, userID+"/") {
+			if ciph.OrganizationId == nil {
+				personal = append(personal, ciph.Id)
+			}
+			delete(db.ciphers, key)
+		}
+	}
+	var atts []bw.Attachment
+	for _, att := range db.attachments {
+		for _, id := range personal {
+			if att.CipherId == id {
+				atts = append(atts, att)
+				delete(db.attachments, att.Id)
+			}
+		}
+	}
+
+	var accounts []bw.Account
+	for _, acc := range db.accounts {
+		if acc.Id != userID {
+			accounts = append(accounts, acc)
+		}
+	}
+	db.accounts = accounts
+
+	return atts, nil
+}
+
+// Keeps the sends of the users, whose files are deleted with the account
+type accountDB struct {
+	*orgDB
+	sends []bw.Send
+}
+
+func (db *accountDB) GetSends(owner string) ([]bw.Send, error) {
+	var sends []bw.Send
+	for _, send := range db.sends {
+		if send.UserId == owner {
+			sends = append(sends, send)
+		}
+	}
+
+	return sends, nil
+}
+
+func (db *accountDB) DeleteAccount(userID string) ([]bw.Attachment, error) {
+	atts, err := db.orgDB.DeleteAccount(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var sends []bw.Send
+	for _, send := range db.sends {
+		if send.UserId != userID {
+			sends = append(sends, send)
+		}
+	}
+	db.sends = sends
+
+	return atts, nil
+}
+
+func TestAccountDelete(t *testing.T) {
+	db := &accountDB{orgDB: newOrgDB(), sends: []bw.Send{{Id: "s1", UserId: "2", File: &bw.SendFile{Id: "file1"}}}}
+	db.setPasswords()
+	db.orgUsers[1].Type = bw.OrgUserOwner
+	db.attachments["att3"] = bw.Attachment{Id: "att3", CipherId: "30"}
+	blobs := mockBlobs{"20/att2": []byte("data"), "30/att3": []byte("data"), "sends/s1/file1": []byte("data")}
+	h := New(db, blobs, nil, 0)
+
+	remove := func(method string, path string, body string) *httptest.ResponseRecorder {
+		res := httptest.NewRecorder()
+		h.HandleAccountDelete(res, userRequest(method, path, "other@example.com", body))
+		return res
+	}
+
+	for _, tc := range []struct {
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{"POST", "/api/accounts", `{"masterPasswordHash":"` + testPassword + `"}`, 405},
+		{"DELETE", "/api/accounts", `{"masterPasswordHash":"d3Jvbmc="}`, 400},
+		{"DELETE", "/api/accounts", `{}`, 400},
+		{"DELETE", "/api/accounts", `{"masterPasswordHash":"` + testPassword + `"}`, 400}, // Sole owner
+	} {
+		if res := remove(tc.method, tc.path, tc.body); res.Code != tc.status {
+			t.Errorf("%s %s %s: expected %v got %v", tc.method, tc.path, tc.body, tc.status, res.Code)
+		}
+	}
+	if len(db.accounts) != 2 || len(blobs) != 3 {
+		t.Fatal("Data deleted by a failed request")
+	}
+
+	// The organization keeps an owner
+	db.orgUsers[0].Type = bw.OrgUserOwner
+	if res := remove("POST", "/api/accounts/delete", `{"masterPasswordHash":"`+testPassword+`"}`); res.Code != 200 {
+		t.Fatalf("Expected 200 got %v %s", res.Code, res.Body)
+	}
+
+	if _, err := db.GetAccount("other@example.com", ""); err == nil {
+		t.Error("Account not deleted")
+	}
+	if _, ok := db.ciphers[cipherKey("2", "30")]; ok || blobs["30/att3"] != nil || blobs["sends/s1/file1"] != nil {
+		t.Error("Personal vault not deleted")
+	}
+
+	// Organization ciphers stay with the organization
+	if _, ok := db.ciphers[cipherKey("1", "20")]; !ok || blobs["20/att2"] == nil {
+		t.Error("Organization cipher deleted with the account")
+	}
+}
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"crypto/rand"
 	"encoding/json"
 	"io"
 	"log"
@@ -13,17 +14,45 @@ import (
 )
 
 type APIHandler struct {
-	db database
+	db              database
+	blobs           blobStore
+	mail            mailer
+	attachmentQuota int64
+	sendQuota       int64
+	publicURL       string // Address of the server used in mailed links
+
+	// Signs the download URLs of attachments and file sends. The URLs are short lived so a new key on every start is fine
+	urlKey []byte
 }
 
-func New(db database) APIHandler {
+// attachmentQuota is the max number of bytes each user can store in attachments. 0 is unlimited
+func New(db database, blobs blobStore, mail mailer, attachmentQuota int64) APIHandler {
 	h := APIHandler{
-		db: db,
+		db:              db,
+		blobs:           blobs,
+		mail:            mail,
+		attachmentQuota: attachmentQuota,
+		urlKey:          make([]byte, 32),
+	}
+
+	_, err := rand.Read(h.urlKey)
+	if err != nil {
+		log.Fatal(err)
 	}
 
 	return h
 }
 
+// SetSendQuota sets the max number of bytes each user can store in file sends. 0 is unlimited
+func (h *APIHandler) SetSendQuota(quota int64) {
+	h.sendQuota = quota
+}
+
+// SetPublicURL sets the address of the server used in mailed links instead of the one from the request
+func (h *APIHandler) SetPublicURL(publicURL string) {
+	h.publicURL = publicURL
+}
+
 // Interface to make testing easier
 type database interface {
 	GetAccount(username string, refreshtoken string) (bw.Account, error)
@@ -31,11 +60,89 @@ type database interface {
 	GetCipher(owner string, ciphID string) (bw.Cipher, error)
 	GetCiphers(owner string) ([]bw.Cipher, error)
 	NewCipher(ciph bw.Cipher, owner string) (bw.Cipher, error)
+	NewOrganizationCipher(ciph bw.Cipher, owner string, collectionIDs []string) (bw.Cipher, error)
 	UpdateCipher(newData bw.Cipher, owner string, ciphID string) error
 	DeleteCipher(owner string, ciphID string) error
 	AddFolder(name string, owner string) (bw.Folder, error)
 	UpdateFolder(newFolder bw.Folder, owner string) error
 	GetFolders(owner string) ([]bw.Folder, error)
+	GetFolder(owner string, folderID string) (bw.Folder, error)
+	Import(owner string, folders []string, ciphers []bw.Cipher, cipherFolders map[int]int) error
+	DeleteFolder(owner string, folderID string) error
+	NewAttachment(att bw.Attachment, owner string, size int64) (bw.Attachment, error)
+	UpdateAttachment(owner string, attID string, key *string, size int64) error
+	GetAttachment(ciphID string, attID string) (bw.Attachment, error)
+	DeleteAttachment(owner string, attID string) error
+	GetAttachmentsSize(owner string) (int64, error)
+	PurgePendingAttachments(before time.Time) ([]bw.Attachment, error)
+	SoftDeleteCiphers(owner string, ciphIDs []string) error
+	RestoreCiphers(owner string, ciphIDs []string) error
+	PurgeDeletedCiphers(before time.Time) ([]bw.Attachment, error)
+	DeleteCiphers(owner string, ciphIDs []string) ([]bw.Attachment, error)
+	MoveCiphers(owner string, ciphIDs []string, folderID *string) error
+	FavoriteCiphers(owner string, ciphIDs []string, favorite bool) error
+	NewOrganization(org bw.Organization, owner bw.OrganizationUser) (bw.Organization, error)
+	GetOrganization(orgID string) (bw.Organization, error)
+	UpdateOrganization(org bw.Organization) error
+	UpdateOrganizationKeys(orgID string, keys bw.KeyPair) error
+	DeleteOrganization(orgID string) ([]bw.Attachment, error)
+	GetOrganizationUser(orgID string, userID string) (bw.OrganizationUser, error)
+	DeleteOrganizationUser(orgID string, orgUserID string) error
+	GetOrganizationUsers(orgID string) ([]bw.OrganizationUser, error)
+	GetOrganizationUserByID(orgID string, orgUserID string) (bw.OrganizationUser, error)
+	InviteOrganizationUser(ou bw.OrganizationUser, token string, collections []bw.SelectionReadOnly) (bw.OrganizationUser, error)
+	AcceptOrganizationUser(orgID string, orgUserID string, userID string, token string) error
+	ConfirmOrganizationUser(orgID string, orgUserID string, key string) error
+	GetPublicKey(userID string) (string, error)
+	RotateKeys(acc bw.Account, ciphers []bw.Cipher, folders []bw.Folder, sends []bw.Send) error
+	DeleteAccount(userID string) ([]bw.Attachment, error)
+	PurgeVault(userID string) ([]bw.Attachment, error)
+	GetProfileOrganizations(userID string) ([]bw.ProfileOrganization, error)
+	NewCollection(col bw.Collection) (bw.Collection, error)
+	GetCollection(orgID string, colID string) (bw.Collection, error)
+	GetCollections(orgID string) ([]bw.Collection, error)
+	GetUserCollections(userID string) ([]bw.Collection, error)
+	UpdateCollection(col bw.Collection) error
+	DeleteCollection(orgID string, colID string) error
+	GetCollectionUsers(colID string) ([]bw.SelectionReadOnly, error)
+	UpdateCollectionUsers(orgID string, colID string, users []bw.SelectionReadOnly) error
+	UpdateCipherCollections(userID string, orgID string, ciphID string, collectionIDs []string) error
+	ShareCiphers(owner string, orgID string, ciphers []bw.Cipher, collectionIDs []string) error
+	NewSend(send bw.Send) (bw.Send, error)
+	GetSend(owner string, sendID string) (bw.Send, error)
+	GetSends(owner string) ([]bw.Send, error)
+	GetPendingSends(owner string) ([]bw.Send, error)
+	SendFileUploaded(owner string, sendID string) error
+	GetSendByAccessID(accessID string) (bw.Send, string, error)
+	UpdateSend(send bw.Send) error
+	AccessSend(sendID string) (int, error)
+	DeleteSend(owner string, sendID string) error
+	PurgeSends(before time.Time) ([]bw.Send, error)
+	PurgePendingSends(before time.Time) ([]bw.Send, error)
+	NewEmergencyAccess(ea bw.EmergencyAccess, token string) (bw.EmergencyAccess, error)
+	GetEmergencyAccess(id string) (bw.EmergencyAccess, error)
+	GetEmergencyAccessGrantees(grantorID string) ([]bw.EmergencyAccess, error)
+	GetEmergencyAccessGrantors(granteeID string) ([]bw.EmergencyAccess, error)
+	ReinviteEmergencyAccess(id string, token string) error
+	AcceptEmergencyAccess(id string, granteeID string, token string) error
+	ConfirmEmergencyAccess(id string, key string) error
+	UpdateEmergencyAccess(id string, accessType int, waitTimeDays int) error
+	UpdateEmergencyAccessStatus(id string, to int, from ...int) error
+	DeleteEmergencyAccess(id string) error
+	ApproveEmergencyAccesses(now time.Time) ([]bw.EmergencyAccess, error)
+	TakeoverAccount(acc bw.Account) error
+}
+
+// Interface for storing file data like attachments
+type blobStore interface {
+	Put(name string, r io.Reader) (int64, error)
+	Get(name string) (io.ReadCloser, error)
+	Delete(name string) error
+}
+
+// Interface for sending mails like organization invitations
+type mailer interface {
+	Send(to string, subject string, body string) error
 }
 
 func (h *APIHandler) HandleKeysUpdate(w http.ResponseWriter, req *http.Request) {
@@ -70,7 +177,10 @@ func (h *APIHandler) HandleProfile(w http.ResponseWriter, req *http.Request) {
 		log.Fatal(err)
 	}
 
-	prof := acc.GetProfile()
+	prof, err := h.getProfile(acc)
+	if err != nil {
+		log.Println(err)
+	}
 
 	data, err := json.Marshal(&prof)
 	if err != nil {
@@ -81,18 +191,12 @@ func (h *APIHandler) HandleProfile(w http.ResponseWriter, req *http.Request) {
 	w.Write(data)
 }
 
-func (h *APIHandler) HandleCollections(w http.ResponseWriter, req *http.Request) {
-
-	collections := bw.Data{Object: "list", Data: []string{}}
-	data, err := json.Marshal(collections)
-	if err != nil {
-		log.Fatal(err)
+func (h *APIHandler) HandleCipher(w http.ResponseWriter, req *http.Request) {
+	if req.Method == "DELETE" {
+		h.HandleCiphersDelete(w, req)
+		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(data)
-}
 
-func (h *APIHandler) HandleCipher(w http.ResponseWriter, req *http.Request) {
 	email := auth.GetEmail(req)
 
 	log.Println(email + " is trying to add data")
@@ -102,41 +206,109 @@ func (h *APIHandler) HandleCipher(w http.ResponseWriter, req *http.Request) {
 		log.Fatal("Account lookup " + err.Error())
 	}
 
-	var data []byte
-
 	if req.Method == "POST" {
 		rCiph, err := unmarshalCipher(req.Body)
 		if err != nil {
 			log.Fatal("Cipher decode error" + err.Error())
 		}
 
-		// Store the new cipher object in db
-		newCiph, err := h.db.NewCipher(rCiph, acc.Id)
-		if err != nil {
-			log.Fatal("newCipher error" + err.Error())
-		}
-		data, err = json.Marshal(&newCiph)
+		// Organization ciphers need collections, so they can only be made with /api/ciphers/create
+		h.createCipher(w, req, acc, rCiph, nil)
+		return
+	}
+
+	ciphs, err := h.db.GetCiphers(acc.Id)
+	if err != nil {
+		log.Println(err)
+	}
+	for i, _ := range ciphs {
+		ciphs[i].Object = "cipherDetails"
+	}
+	h.setAttachmentURLs(req, ciphs)
+	list := bw.Data{Object: "list", Data: ciphs}
+	data, err := json.Marshal(&list)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// Handles /api/ciphers/create. Used by the clients to make a cipher in the collections of an organization
+func (h *APIHandler) HandleCipherCreate(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte(http.StatusText(http.StatusMethodNotAllowed)))
+		return
+	}
+
+	email := auth.GetEmail(req)
+	acc, err := h.db.GetAccount(email, "")
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(http.StatusText(http.StatusUnauthorized)))
+		log.Println(err)
+		return
+	}
+
+	decoder := json.NewDecoder(req.Body)
+	var reqData struct {
+		Cipher        newCipher `json:"cipher"`
+		CollectionIds []string  `json:"collectionIds"`
+	}
+	err = decoder.Decode(&reqData)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid cipher")
+		log.Println(err)
+		return
+	}
+	defer req.Body.Close()
+
+	ciph, err := reqData.Cipher.toCipher()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid cipher")
+		log.Println(err)
+		return
+	}
+
+	h.createCipher(w, req, acc, ciph, reqData.CollectionIds)
+}
+
+// Stores the new cipher. An organization cipher is added to the collections at the same time
+func (h *APIHandler) createCipher(w http.ResponseWriter, req *http.Request, acc bw.Account, ciph bw.Cipher, collectionIDs []string) {
+	var err error
+	if ciph.OrganizationId != nil {
+		err = h.canAddToCollections(acc, *ciph.OrganizationId, collectionIDs)
 		if err != nil {
-			log.Fatal(err)
+			writeError(w, http.StatusBadRequest, err.Error())
+			log.Println(err)
+			return
 		}
-	} else {
-		ciphs, err := h.db.GetCiphers(acc.Id)
+
+		ciph, err = h.db.NewOrganizationCipher(ciph, acc.Id, collectionIDs)
 		if err != nil {
+			writeError(w, http.StatusBadRequest, "The cipher could not be created")
 			log.Println(err)
+			return
 		}
-		for i, _ := range ciphs {
-			ciphs[i].CollectionIds = make([]string, 0)
-			ciphs[i].Object = "cipherDetails"
+	} else {
+		if len(collectionIDs) > 0 {
+			writeError(w, http.StatusBadRequest, "Only organization ciphers can be in collections")
+			return
 		}
-		list := bw.Data{Object: "list", Data: ciphs}
-		data, err = json.Marshal(&list)
+
+		ciph, err = h.db.NewCipher(ciph, acc.Id)
 		if err != nil {
-			log.Fatal(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+			log.Println(err)
+			return
 		}
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(data)
+	writeJSON(w, ciph)
+	log.Println(acc.Email + " created cipher " + ciph.Id)
 }
 
 // This function handles updates and deleteing
@@ -144,14 +316,45 @@ func (h *APIHandler) HandleCipherUpdate(w http.ResponseWriter, req *http.Request
 	email := auth.GetEmail(req)
 	log.Println(email + " is trying to edit his data")
 
-	// Get the cipher id
+	// Get the cipher id and what to do with it
 	id := strings.TrimPrefix(req.URL.Path, "/api/ciphers/")
+	var action string
+	if i := strings.Index(id, "/"); i >= 0 {
+		id, action = id[:i], id[i+1:]
+	}
 
 	acc, err := h.db.GetAccount(email, "")
 	if err != nil {
 		log.Fatal("Account lookup " + err.Error())
 	}
 
+	if action == "attachment" || strings.HasPrefix(action, "attachment/") {
+		h.handleAttachment(w, req, acc, id, strings.TrimPrefix(strings.TrimPrefix(action, "attachment"), "/"))
+		return
+	}
+
+	switch {
+	case action == "delete" && req.Method == "PUT":
+		h.trashCiphers(w, acc, []string{id})
+		return
+	case action == "delete" && req.Method == "POST":
+		h.deleteCipher(w, acc, id)
+		return
+	case action == "restore" && req.Method == "PUT":
+		h.restoreCiphers(w, req, acc, []string{id}, false)
+		return
+	case action == "collections" && (req.Method == "PUT" || req.Method == "POST"):
+		h.updateCipherCollections(w, req, acc, id)
+		return
+	case action == "share" && (req.Method == "PUT" || req.Method == "POST"):
+		h.shareCipher(w, req, acc, id)
+		return
+	case action != "":
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(http.StatusText(http.StatusNotFound)))
+		return
+	}
+
 	switch req.Method {
 	case "GET":
 		log.Println("GET Ciphers for " + acc.Id)
@@ -160,6 +363,7 @@ func (h *APIHandler) HandleCipherUpdate(w http.ResponseWriter, req *http.Request
 		if err != nil {
 			log.Fatal(err)
 		}
+		h.setAttachmentURLs(req, []bw.Cipher{ciph})
 		data, err = json.Marshal(&ciph)
 		if err != nil {
 			log.Fatal(err)
@@ -177,6 +381,25 @@ func (h *APIHandler) HandleCipherUpdate(w http.ResponseWriter, req *http.Request
 		// Set correct ID
 		rCiph.Id = id
 
+		oldCiph, err := h.db.GetCipher(acc.Id, id)
+		if err != nil {
+			w.Write([]byte("0"))
+			log.Println(err)
+			return
+		}
+
+		// Keep the stored history if the client didn't send one
+		if rCiph.Data.PasswordHistory == nil {
+			rCiph.Data.PasswordHistory = oldCiph.Data.PasswordHistory
+			rCiph.PasswordHistory = oldCiph.Data.PasswordHistory
+		}
+
+		// Attachments are stored separately and never sent with the cipher
+		rCiph.Attachments = oldCiph.Attachments
+		rCiph.DeletedDate = oldCiph.DeletedDate
+		rCiph.OrganizationId = oldCiph.OrganizationId
+		h.setAttachmentURLs(req, []bw.Cipher{rCiph})
+
 		err = h.db.UpdateCipher(rCiph, acc.Id, id)
 		if err != nil {
 			w.Write([]byte("0"))
@@ -196,16 +419,7 @@ func (h *APIHandler) HandleCipherUpdate(w http.ResponseWriter, req *http.Request
 		return
 
 	case "DELETE":
-		err := h.db.DeleteCipher(acc.Id, id)
-		if err != nil {
-			w.Write([]byte("0"))
-			log.Println(err)
-			return
-		}
-
-		w.Header().Set("Content-Type", "application/json")
-		w.Write([]byte(""))
-		log.Println("Cipher " + id + " deleted")
+		h.deleteCipher(w, acc, id)
 		return
 	default:
 		w.Write([]byte("0"))
@@ -214,6 +428,29 @@ func (h *APIHandler) HandleCipherUpdate(w http.ResponseWriter, req *http.Request
 
 }
 
+// Permanently deletes the cipher
+func (h *APIHandler) deleteCipher(w http.ResponseWriter, acc bw.Account, id string) {
+	ciph, err := h.db.GetCipher(acc.Id, id)
+	if err != nil {
+		w.Write([]byte("0"))
+		log.Println(err)
+		return
+	}
+
+	err = h.db.DeleteCipher(acc.Id, id)
+	if err != nil {
+		w.Write([]byte("0"))
+		log.Println(err)
+		return
+	}
+
+	h.deleteAttachmentData(ciph.Attachments)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(""))
+	log.Println("Cipher " + id + " deleted")
+}
+
 func (h *APIHandler) HandleSync(w http.ResponseWriter, req *http.Request) {
 	email := auth.GetEmail(req)
 
@@ -221,17 +458,10 @@ func (h *APIHandler) HandleSync(w http.ResponseWriter, req *http.Request) {
 
 	acc, err := h.db.GetAccount(email, "")
 
-	prof := bw.Profile{
-		Id:               acc.Id,
-		Email:            acc.Email,
-		EmailVerified:    false,
-		Premium:          false,
-		Culture:          "en-US",
-		TwoFactorEnabled: false,
-		Key:              acc.Key,
-		SecurityStamp:    nil,
-		Organizations:    nil,
-		Object:           "profile",
+	// The private key is needed to decrypt the organization keys
+	prof, err := h.getProfile(acc)
+	if err != nil {
+		log.Println(err)
 	}
 
 	ciphs, err := h.db.GetCiphers(acc.Id)
@@ -239,11 +469,23 @@ func (h *APIHandler) HandleSync(w http.ResponseWriter, req *http.Request) {
 		log.Println(err)
 	}
 
+	h.setAttachmentURLs(req, ciphs)
+
 	folders, err := h.db.GetFolders(acc.Id)
 	if err != nil {
 		log.Println(err)
 	}
 
+	collections, err := h.db.GetUserCollections(acc.Id)
+	if err != nil {
+		log.Println(err)
+	}
+
+	sends, err := h.db.GetSends(acc.Id)
+	if err != nil {
+		log.Println(err)
+	}
+
 	Domains := bw.Domains{
 		Object:            "domains",
 		EquivalentDomains: nil,
@@ -253,11 +495,13 @@ func (h *APIHandler) HandleSync(w http.ResponseWriter, req *http.Request) {
 	}
 
 	data := bw.SyncData{
-		Profile: prof,
-		Folders: folders,
-		Domains: Domains,
-		Object:  "sync",
-		Ciphers: ciphs,
+		Profile:     prof,
+		Folders:     folders,
+		Collections: collections,
+		Domains:     Domains,
+		Object:      "sync",
+		Ciphers:     ciphs,
+		Sends:       sends,
 	}
 
 	jdata, err := json.Marshal(&data)
@@ -269,8 +513,37 @@ func (h *APIHandler) HandleSync(w http.ResponseWriter, req *http.Request) {
 	w.Write(jdata)
 }
 
-// Only handles ciphers
-// TODO: handle folders and folderRelationships
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+		log.Println(err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// Writes an error the clients can show to the user
+func writeError(w http.ResponseWriter, status int, message string) {
+	resp := struct {
+		Message string
+		Object  string
+	}{
+		Message: message,
+		Object:  "error",
+	}
+
+	data, _ := json.Marshal(&resp)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+// Imports ciphers and folders. Nothing is stored if any of it fails
 func (h *APIHandler) HandleImport(w http.ResponseWriter, req *http.Request) {
 	email := auth.GetEmail(req)
 
@@ -278,34 +551,64 @@ func (h *APIHandler) HandleImport(w http.ResponseWriter, req *http.Request) {
 
 	acc, err := h.db.GetAccount(email, "")
 	if err != nil {
-		log.Fatal("Account lookup " + err.Error())
+		writeError(w, http.StatusUnauthorized, http.StatusText(http.StatusUnauthorized))
+		log.Println("Account lookup " + err.Error())
+		return
 	}
 
 	decoder := json.NewDecoder(req.Body)
 	data := struct {
-		Ciphers             []newCipher `json:"ciphers"`
-		Foders              []string    `json:"folders"`
-		FolderRelationships []string    `json:"folderRelationships"`
+		Ciphers []newCipher `json:"ciphers"`
+		Folders []struct {
+			Name string `json:"name"`
+		} `json:"folders"`
+		FolderRelationships []struct {
+			Key   int `json:"key"`   // Index of the cipher
+			Value int `json:"value"` // Index of the folder
+		} `json:"folderRelationships"`
 	}{}
 
 	err = decoder.Decode(&data)
 	if err != nil {
-		log.Fatal(err)
+		writeError(w, http.StatusBadRequest, "Invalid import data")
+		log.Println(err)
+		return
 	}
 	defer req.Body.Close()
 
-	for _, nc := range data.Ciphers {
-		c, err := nc.toCipher()
+	folders := make([]string, len(data.Folders))
+	for i, f := range data.Folders {
+		folders[i] = f.Name
+	}
+
+	ciphers := make([]bw.Cipher, len(data.Ciphers))
+	for i, nc := range data.Ciphers {
+		ciphers[i], err = nc.toCipher()
 		if err != nil {
-			log.Fatal(err.Error())
+			writeError(w, http.StatusBadRequest, "Invalid cipher")
+			log.Println(err)
+			return
 		}
+	}
 
-		_, err = h.db.NewCipher(c, acc.Id)
-		if err != nil {
-			log.Fatal(err.Error())
+	cipherFolders := make(map[int]int)
+	for _, r := range data.FolderRelationships {
+		if r.Key < 0 || r.Key >= len(ciphers) || r.Value < 0 || r.Value >= len(folders) {
+			writeError(w, http.StatusBadRequest, "Invalid folder relationship")
+			log.Printf("Folder relationship out of range %v -> %v\n", r.Key, r.Value)
+			return
 		}
+		cipherFolders[r.Key] = r.Value
+	}
+
+	err = h.db.Import(acc.Id, folders, ciphers, cipherFolders)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "The import failed")
+		log.Println(err)
+		return
 	}
 
+	log.Printf("Imported %v ciphers and %v folders\n", len(ciphers), len(folders))
 	w.Write([]byte{0x00})
 }
 
@@ -368,13 +671,49 @@ func (h *APIHandler) HandleFolderUpdate(w http.ResponseWriter, req *http.Request
 		log.Fatal("Account lookup " + err.Error())
 	}
 
-	switch req.Method {
+	// Get the folder id
+	folderID := strings.TrimPrefix(req.URL.Path, "/api/folders/")
+
+	method := req.Method
+	if method == "POST" && strings.HasSuffix(folderID, "/delete") {
+		folderID = strings.TrimSuffix(folderID, "/delete")
+		method = "DELETE" // Web Vault posts to /delete
+	}
+
+	switch method {
+	case "GET":
+		folder, err := h.db.GetFolder(acc.Id, folderID)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(http.StatusText(http.StatusNotFound)))
+			log.Println(err)
+			return
+		}
+
+		data, err := json.Marshal(&folder)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+		return
+	case "DELETE":
+		err := h.db.DeleteFolder(acc.Id, folderID)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(http.StatusText(http.StatusNotFound)))
+			log.Println(err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(""))
+		log.Println("Folder " + folderID + " deleted")
+		return
 	case "POST":
 		fallthrough // Do same as PUT. Web Vault wants to post
 	case "PUT":
-		// Get the folder id
-		folderID := strings.TrimPrefix(req.URL.Path, "/api/folders/")
-
 		decoder := json.NewDecoder(req.Body)
 
 		var folderData struct {
@@ -417,14 +756,18 @@ func (h *APIHandler) HandleFolderUpdate(w http.ResponseWriter, req *http.Request
 
 // The data we get from the client. Only used to parse data
 type newCipher struct {
-	Type           int       `json:"type"`
-	FolderId       string    `json:"folderId"`
-	OrganizationId string    `json:"organizationId"`
-	Name           string    `json:"name"`
-	Notes          string    `json:"notes"`
-	Favorite       bool      `json:"favorite"`
-	Login          loginData `json:"login"`
-	Fields         string    `json:"fields"`
+	Type           int          `json:"type"`
+	FolderId       string       `json:"folderId"`
+	OrganizationId string       `json:"organizationId"`
+	Name           string       `json:"name"`
+	Notes          string       `json:"notes"`
+	Favorite       bool         `json:"favorite"`
+	Login          loginData    `json:"login"`
+	Card           *bw.Card     `json:"card"`
+	Identity       *bw.Identity `json:"identity"`
+	Fields         []bw.Field   `json:"fields"`
+
+	PasswordHistory []bw.PasswordHistory `json:"passwordHistory"`
 }
 
 type loginData struct {
@@ -444,8 +787,12 @@ func (nciph *newCipher) toCipher() (bw.Cipher, error) {
 		Totp:     nil,
 		Name:     &nciph.Name,
 		Notes:    new(string),
-		Fields:   nil,
+		Fields:   nciph.Fields,
 		Uris:     nciph.Login.Uris,
+		Card:     nciph.Card,
+		Identity: nciph.Identity,
+
+		PasswordHistory: nciph.PasswordHistory,
 	}
 
 	(*cdata.Notes) = nciph.Notes
@@ -486,6 +833,10 @@ func (nciph *newCipher) toCipher() (bw.Cipher, error) {
 		ciph.FolderId = &nciph.FolderId
 	}
 
+	if nciph.OrganizationId != "" {
+		ciph.OrganizationId = &nciph.OrganizationId
+	}
+
 	bw.FakeNewAPI(&ciph)
 
 	return ciph, nil
@@ -2,12 +2,48 @@ package api
 
 import (
 	"bytes"
+	"crypto/hmac"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"io/ioutil"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	bw "github.com/VictorNine/bitwarden-go/internal/common"
+	"github.com/VictorNine/bitwarden-go/internal/database/sqlite"
 )
 
+// Opens an empty database in a temporary directory
+func newTestDB(t *testing.T) (*sqlite.DB, func()) {
+	dir, err := ioutil.TempDir("", "bitwarden")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := &sqlite.DB{}
+	db.SetDir(dir)
+	err = db.Open()
+	if err == nil {
+		err = db.Init()
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	return db, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
 func TestToCipher(t *testing.T) {
 	url := "www.test.com"
 	nciph := newCipher{
@@ -50,3 +86,424 @@ func TestUnmarshalCipher(t *testing.T) {
 		t.Fatal("Wrong type")
 	}
 }
+
+func TestUnmarshalCard(t *testing.T) {
+	testData := "{\"type\": 3,\"folderId\": null,\"organizationId\": null,\"name\": \"2.name\",\"notes\": null,\"favorite\": false,\"card\": {\"cardholderName\": \"2.holder\",\"brand\": \"2.brand\",\"number\": \"2.number\",\"expMonth\": \"2.month\",\"expYear\": \"2.year\",\"code\": \"2.code\"}}"
+
+	r := ioutil.NopCloser(bytes.NewBuffer([]byte(testData)))
+	ciph, err := unmarshalCipher(r)
+	if err != nil {
+		t.Fatalf("Got error %s", err.Error())
+	}
+
+	if ciph.Card == nil {
+		t.Fatal("Card is nil")
+	}
+
+	// Store and load the data the same way the database does
+	b, err := ciph.Data.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stored bw.Cipher
+	err = json.Unmarshal(b, &stored.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bw.FakeNewAPI(&stored)
+
+	if stored.Card == nil {
+		t.Fatal("Card lost when stored")
+	}
+
+	card := stored.Card
+	for _, f := range []struct {
+		name     string
+		value    *string
+		expected string
+	}{
+		{"CardholderName", card.CardholderName, "2.holder"},
+		{"Brand", card.Brand, "2.brand"},
+		{"Number", card.Number, "2.number"},
+		{"ExpMonth", card.ExpMonth, "2.month"},
+		{"ExpYear", card.ExpYear, "2.year"},
+		{"Code", card.Code, "2.code"},
+	} {
+		if f.value == nil {
+			t.Errorf("%s is nil", f.name)
+			continue
+		}
+		if *f.value != f.expected {
+			t.Errorf("%s: expected %s got %s", f.name, f.expected, *f.value)
+		}
+	}
+}
+
+func TestIdentityRoundTrip(t *testing.T) {
+	// Give every identity field a unique value
+	identity := make(map[string]string)
+	fields := reflect.TypeOf(bw.Identity{})
+	for i := 0; i < fields.NumField(); i++ {
+		name := fields.Field(i).Name
+		identity[strings.ToLower(name[:1])+name[1:]] = "2." + name
+	}
+
+	testData, err := json.Marshal(map[string]interface{}{
+		"type":     4,
+		"name":     "2.name",
+		"identity": identity,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := ioutil.NopCloser(bytes.NewBuffer(testData))
+	ciph, err := unmarshalCipher(r)
+	if err != nil {
+		t.Fatalf("Got error %s", err.Error())
+	}
+
+	// Store it in the database and read it back
+	db, done := newTestDB(t)
+	defer done()
+
+	ciph, err = db.NewCipher(ciph, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := db.GetCipher("1", ciph.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// And send it to the client
+	b, err := json.Marshal(&stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var res bw.Cipher
+	err = json.Unmarshal(b, &res)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Identity == nil {
+		t.Fatal("Identity is nil")
+	}
+
+	v := reflect.ValueOf(*res.Identity)
+	for i := 0; i < fields.NumField(); i++ {
+		name := fields.Field(i).Name
+		value := v.Field(i).Interface().(*string)
+		if value == nil {
+			t.Errorf("%s is nil", name)
+			continue
+		}
+		if *value != "2."+name {
+			t.Errorf("%s: expected %s got %s", name, "2."+name, *value)
+		}
+	}
+}
+
+func TestUnmarshalFields(t *testing.T) {
+	testData := "{\"type\": 2,\"name\": \"2.name\",\"secureNote\": {\"type\": 0},\"fields\": [{\"type\": 0,\"name\": \"2.text\",\"value\": \"2.value\"},{\"type\": 2,\"name\": \"2.bool\",\"value\": \"2.true\"},{\"type\": 3,\"name\": \"2.linked\",\"value\": null,\"linkedId\": 101}]}"
+
+	r := ioutil.NopCloser(bytes.NewBuffer([]byte(testData)))
+	ciph, err := unmarshalCipher(r)
+	if err != nil {
+		t.Fatalf("Got error %s", err.Error())
+	}
+
+	if len(ciph.Fields) != 3 {
+		t.Fatalf("Expected 3 fields got %v", len(ciph.Fields))
+	}
+
+	if ciph.Fields[1].Type != 2 || *ciph.Fields[1].Name != "2.bool" || *ciph.Fields[1].Value != "2.true" {
+		t.Fatal("Got wrong field data")
+	}
+
+	if ciph.Fields[2].Value != nil || ciph.Fields[2].LinkedId == nil || *ciph.Fields[2].LinkedId != 101 {
+		t.Fatal("Got wrong linked field")
+	}
+}
+
+func TestUnmarshalPasswordHistory(t *testing.T) {
+	testData := "{\"type\": 1,\"name\": \"2.name\",\"login\": {\"username\": \"2.user\",\"password\": \"2.new\"},\"passwordHistory\": [{\"password\": \"2.old\",\"lastUsedDate\": \"2018-06-01T12:00:00.000Z\"}]}"
+
+	r := ioutil.NopCloser(bytes.NewBuffer([]byte(testData)))
+	ciph, err := unmarshalCipher(r)
+	if err != nil {
+		t.Fatalf("Got error %s", err.Error())
+	}
+
+	if len(ciph.PasswordHistory) != 1 || len(ciph.Data.PasswordHistory) != 1 {
+		t.Fatal("Password history not parsed")
+	}
+
+	if *ciph.PasswordHistory[0].Password != "2.old" || ciph.PasswordHistory[0].LastUsedDate.Year() != 2018 {
+		t.Fatal("Got wrong password history")
+	}
+}
+
+func (db *folderDB) GetFolder(owner string, folderID string) (bw.Folder, error) {
+	folder, ok := db.folders[cipherKey(owner, folderID)]
+	if !ok {
+		return bw.Folder{}, errors.New("Folder not found")
+	}
+
+	return folder, nil
+}
+
+// Removes the folder and takes the user's ciphers out of it
+func (db *folderDB) DeleteFolder(owner string, folderID string) error {
+	if _, ok := db.folders[cipherKey(owner, folderID)]; !ok {
+		return errors.New("Folder not found")
+	}
+	delete(db.folders, cipherKey(owner, folderID))
+
+	for key, ciph := range db.ciphers {
+		if strings.HasPrefix(key, owner+"/") && ciph.FolderId != nil && *ciph.FolderId == folderID {
+			ciph.FolderId = nil
+			db.ciphers[key] = ciph
+		}
+	}
+
+	return nil
+}
+
+func TestDeleteFolder(t *testing.T) {
+	db := newFolderDB()
+	f1 := "f1"
+	ciph := db.ciphers[cipherKey("1", "10")]
+	ciph.FolderId = &f1
+	db.ciphers[cipherKey("1", "10")] = ciph
+	h := New(db, mockBlobs{}, nil, 0)
+
+	folder := func(method string, path string) int {
+		res := httptest.NewRecorder()
+		h.HandleFolderUpdate(res, userRequest(method, path, "nobody@example.com", ""))
+		return res.Code
+	}
+
+	// Another user's folder can't be seen or deleted
+	for _, method := range []string{"GET", "DELETE"} {
+		if code := folder(method, "/api/folders/f2"); code != 404 {
+			t.Errorf("%s another user's folder: expected 404 got %v", method, code)
+		}
+	}
+	if _, ok := db.folders[cipherKey("2", "f2")]; !ok {
+		t.Fatal("Another user's folder deleted")
+	}
+
+	if code := folder("GET", "/api/folders/f1"); code != 200 {
+		t.Fatalf("Expected 200 got %v", code)
+	}
+
+	// The web vault posts to /delete
+	if code := folder("POST", "/api/folders/f1/delete"); code != 200 {
+		t.Fatalf("Expected 200 got %v", code)
+	}
+	if _, ok := db.folders[cipherKey("1", "f1")]; ok {
+		t.Fatal("Folder not deleted")
+	}
+	if db.ciphers[cipherKey("1", "10")].FolderId != nil {
+		t.Fatal("Cipher still in the deleted folder")
+	}
+
+	if code := folder("DELETE", "/api/folders/f1"); code != 404 {
+		t.Errorf("Deleting again: expected 404 got %v", code)
+	}
+}
+
+// Stores the imported folders and ciphers with ids made from their index
+func (db *folderDB) Import(owner string, folders []string, ciphers []bw.Cipher, cipherFolders map[int]int) error {
+	folderIDs := make([]string, len(folders))
+	for i, name := range folders {
+		folderIDs[i] = "if" + strconv.Itoa(i)
+		db.folders[cipherKey(owner, folderIDs[i])] = bw.Folder{Id: folderIDs[i], Name: name}
+	}
+
+	for i, ciph := range ciphers {
+		ciph.Id = "ic" + strconv.Itoa(i)
+		ciph.Edit = true
+		if f, ok := cipherFolders[i]; ok {
+			ciph.FolderId = &folderIDs[f]
+		}
+		db.ciphers[cipherKey(owner, ciph.Id)] = ciph
+	}
+
+	return nil
+}
+
+func TestImport(t *testing.T) {
+	db := newFolderDB()
+	h := New(db, mockBlobs{}, nil, 0)
+
+	importData := func(body string) int {
+		res := httptest.NewRecorder()
+		h.HandleImport(res, userRequest("POST", "/api/ciphers/import", "nobody@example.com", body))
+		return res.Code
+	}
+
+	// Nothing is imported if the data is wrong
+	for _, body := range []string{
+		`{"ciphers":[{"type":1,"name":"a"}],"folders":[{"name":"f"}],"folderRelationships":[{"key":1,"value":0}]}`,
+		`{"ciphers":[{"type":1,"name":"a"}],"folders":[{"name":"f"}],"folderRelationships":[{"key":0,"value":-1}]}`,
+		`{"ciphers":{}}`,
+	} {
+		if code := importData(body); code != 400 {
+			t.Errorf("%s: expected 400 got %v", body, code)
+		}
+	}
+	if len(db.ciphers) != 4 || len(db.folders) != 2 {
+		t.Fatal("Data stored by a failed import")
+	}
+
+	body := `{
+		"ciphers":[{"type":1,"name":"a"},{"type":1,"name":"b"},{"type":2,"name":"c"}],
+		"folders":[{"name":"first"},{"name":"second"}],
+		"folderRelationships":[{"key":0,"value":1},{"key":2,"value":0}]
+	}`
+	if code := importData(body); code != 200 {
+		t.Fatalf("Expected 200 got %v", code)
+	}
+
+	for id, folder := range map[string]string{"ic0": "second", "ic1": "", "ic2": "first"} {
+		ciph, ok := db.ciphers[cipherKey("1", id)]
+		if !ok {
+			t.Fatalf("Cipher %s not imported", id)
+		}
+
+		var name string
+		if ciph.FolderId != nil {
+			name = db.folders[cipherKey("1", *ciph.FolderId)].Name
+		}
+		if name != folder {
+			t.Errorf("Cipher %s imported to folder %q expected %q", id, name, folder)
+		}
+	}
+}
+
+func TestInviteMail(t *testing.T) {
+	org := bw.Organization{Id: "org1", Name: "Test & Co"}
+	invited := bw.OrganizationUser{Id: "ou1", Email: "new@example.com"}
+
+	body := inviteMail("http://vault.example.com", org, invited, "abc-123")
+
+	link := "http://vault.example.com/#/accept-organization?email=new%40example.com&organizationId=org1&organizationName=Test+%26+Co&organizationUserId=ou1&token=abc-123"
+	if !strings.Contains(body, link) {
+		t.Fatalf("Expected the link %s in %s", link, body)
+	}
+}
+
+func TestSendPassword(t *testing.T) {
+	hash, err := hashSendPassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !checkSendPassword(hash, "secret") {
+		t.Error("Correct password rejected")
+	}
+
+	for _, password := range []string{"", "Secret", "secret "} {
+		if checkSendPassword(hash, password) {
+			t.Errorf("Wrong password %q accepted", password)
+		}
+	}
+}
+
+func TestSendAvailable(t *testing.T) {
+	past, future := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	one := 1
+
+	cases := []struct {
+		send      bw.Send
+		available bool
+	}{{bw.Send{DeletionDate: future}, true},
+		{bw.Send{DeletionDate: past}, false},
+		{bw.Send{DeletionDate: future, Disabled: true}, false},
+		{bw.Send{DeletionDate: future, ExpirationDate: &past}, false},
+		{bw.Send{DeletionDate: future, ExpirationDate: &future}, true},
+		{bw.Send{DeletionDate: future, MaxAccessCount: &one}, true},
+		{bw.Send{DeletionDate: future, MaxAccessCount: &one, AccessCount: 1}, false},
+	}
+
+	for i, c := range cases {
+		if sendAvailable(c.send) != c.available {
+			t.Errorf("Case %d: expected available %v", i, c.available)
+		}
+	}
+}
+
+func TestSendFileURL(t *testing.T) {
+	h := New(nil, nil, nil, 0)
+	req := httptest.NewRequest("POST", "http://vault.example.com/api/sends/abc/access/file/file1", nil)
+	send := bw.Send{AccessId: "abc", File: &bw.SendFile{Id: "file1"}}
+
+	u, err := url.Parse(h.sendFileURL(req, send))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Path != "/sendfiles/abc/file1" {
+		t.Fatalf("Got wrong path %s", u.Path)
+	}
+
+	expires, _ := strconv.ParseInt(u.Query().Get("expires"), 10, 64)
+	signature, _ := base64.RawURLEncoding.DecodeString(u.Query().Get("signature"))
+	if !hmac.Equal(signature, h.downloadSignature("/sendfiles/abc/file1", expires)) {
+		t.Error("Valid signature rejected")
+	}
+	if hmac.Equal(signature, h.downloadSignature("/sendfiles/abc/file2", expires)) {
+		t.Error("Signature valid for another file")
+	}
+
+	// Links that are expired or signed with another key are rejected before the send is looked up
+	other := New(nil, nil, nil, 0)
+	past := time.Now().Add(-time.Minute).Unix()
+	for _, query := range []string{
+		"expires=" + strconv.FormatInt(past, 10) + "&signature=" + base64.RawURLEncoding.EncodeToString(h.downloadSignature("/sendfiles/abc/file1", past)),
+		"expires=" + strconv.FormatInt(expires, 10) + "&signature=" + base64.RawURLEncoding.EncodeToString(other.downloadSignature("/sendfiles/abc/file1", expires)),
+	} {
+		res := httptest.NewRecorder()
+		h.HandleSendFileDownload(res, httptest.NewRequest("GET", "/sendfiles/abc/file1?"+query, nil))
+		if res.Code != 403 {
+			t.Errorf("Expected 403 got %v", res.Code)
+		}
+	}
+}
+
+func TestEmergencyInviteMail(t *testing.T) {
+	grantor := bw.Account{Name: "Grant Or", Email: "grantor@example.com"}
+	ea := bw.EmergencyAccess{Id: "ea1", Email: "contact@example.com"}
+
+	body := emergencyInviteMail("http://vault.example.com", grantor, ea, "abc-123")
+
+	link := "http://vault.example.com/#/accept-emergency?email=contact%40example.com&id=ea1&name=Grant+Or&token=abc-123"
+	if !strings.Contains(body, link) {
+		t.Fatalf("Expected the link %s in %s", link, body)
+	}
+}
+
+func TestValidEmergencyAccess(t *testing.T) {
+	cases := []struct {
+		accessType   int
+		waitTimeDays int
+		valid        bool
+	}{{bw.EmergencyAccessView, 7, true},
+		{bw.EmergencyAccessTakeover, 1, true},
+		{bw.EmergencyAccessTakeover, maxEmergencyWaitDays, true},
+		{bw.EmergencyAccessView, 0, false},
+		{bw.EmergencyAccessView, maxEmergencyWaitDays + 1, false},
+		{2, 7, false},
+	}
+
+	for _, c := range cases {
+		if validEmergencyAccess(c.accessType, c.waitTimeDays) != c.valid {
+			t.Errorf("Type %d with %d days: expected valid %v", c.accessType, c.waitTimeDays, c.valid)
+		}
+	}
+}
@@ -0,0 +1,432 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	bw "github.com/VictorNine/bitwarden-go/internal/common"
+)
+
+// How long newer clients have to upload the data after the attachment is created
+const attachmentUploadTime = 24 * time.Hour
+
+// How long the download URL of an attachment works. The URLs are sent with the ciphers,
+// so old clients need some time. Newer clients get a new URL before they download
+const attachmentDownloadTime = time.Hour
+
+var (
+	errTooLarge        = errors.New("Not enough storage available")
+	errSizeMismatch    = errors.New("The uploaded file doesn't match the expected size")
+	errAlreadyUploaded = errors.New("The file has already been uploaded")
+)
+
+func attachmentBlobName(ciphID, attID string) string {
+	return ciphID + "/" + attID
+}
+
+// The URLs are not stored since they depend on how the server is reached and expire.
+// Only give them to users with access to the cipher
+func (h *APIHandler) attachmentURL(req *http.Request, att bw.Attachment) string {
+	return h.downloadURL(req, "/attachments/"+attachmentBlobName(att.CipherId, att.Id), attachmentDownloadTime)
+}
+
+func (h *APIHandler) setAttachmentURLs(req *http.Request, ciphs []bw.Cipher) {
+	for i := range ciphs {
+		for j := range ciphs[i].Attachments {
+			ciphs[i].Attachments[j].Url = h.attachmentURL(req, ciphs[i].Attachments[j])
+		}
+	}
+}
+
+// Remove the stored data for attachments that are deleted from the database
+func (h *APIHandler) deleteAttachmentData(atts []bw.Attachment) {
+	for _, att := range atts {
+		err := h.blobs.Delete(attachmentBlobName(att.CipherId, att.Id))
+		if err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// A failed upload removes the data, so data that is already stored must not be uploaded again
+func (h *APIHandler) blobExists(name string) bool {
+	r, err := h.blobs.Get(name)
+	if err != nil {
+		return false
+	}
+	r.Close()
+
+	return true
+}
+
+// The number of bytes the user can still store. -1 if there is no limit
+func (h *APIHandler) attachmentSpaceLeft(owner string) (int64, error) {
+	if h.attachmentQuota == 0 {
+		return -1, nil
+	}
+
+	used, err := h.db.GetAttachmentsSize(owner)
+	if err != nil {
+		return 0, err
+	}
+
+	if used > h.attachmentQuota {
+		return 0, nil
+	}
+
+	return h.attachmentQuota - used, nil
+}
+
+// Handles /api/ciphers/{id}/attachment/...
+func (h *APIHandler) handleAttachment(w http.ResponseWriter, req *http.Request, acc bw.Account, ciphID string, attID string) {
+	ciph, err := h.db.GetCipher(acc.Id, ciphID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(http.StatusText(http.StatusNotFound)))
+		log.Println(err)
+		return
+	}
+
+	// Read only collections only give access to download
+	if req.Method != "GET" && !ciph.Edit {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(http.StatusText(http.StatusForbidden)))
+		log.Println(acc.Email + " can't edit cipher " + ciph.Id)
+		return
+	}
+
+	// The web vault posts to /delete instead of using DELETE
+	deleting := req.Method == "DELETE"
+	if req.Method == "POST" && strings.HasSuffix(attID, "/delete") {
+		attID = strings.TrimSuffix(attID, "/delete")
+		deleting = true
+	}
+
+	switch {
+	case attID == "" && req.Method == "POST":
+		h.uploadAttachment(w, req, acc, ciph, nil)
+	case attID == "v2" && req.Method == "POST":
+		h.newAttachment(w, req, acc, ciph)
+	case attID == "":
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte(http.StatusText(http.StatusMethodNotAllowed)))
+	default:
+		att, err := h.db.GetAttachment(ciph.Id, attID)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(http.StatusText(http.StatusNotFound)))
+			log.Println(err)
+			return
+		}
+
+		switch {
+		case deleting:
+			h.deleteAttachment(w, req, acc, att)
+		case req.Method == "POST":
+			h.uploadAttachment(w, req, acc, ciph, &att)
+		case req.Method == "GET":
+			att.Url = h.attachmentURL(req, att)
+			data, err := json.Marshal(&att)
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+				log.Println(err)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(data)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			w.Write([]byte(http.StatusText(http.StatusMethodNotAllowed)))
+		}
+	}
+}
+
+// Creates the attachment before the data is uploaded. Used by newer clients
+func (h *APIHandler) newAttachment(w http.ResponseWriter, req *http.Request, acc bw.Account, ciph bw.Cipher) {
+	decoder := json.NewDecoder(req.Body)
+	var reqData struct {
+		Key      string `json:"key"`
+		FileName string `json:"fileName"`
+		FileSize int64  `json:"fileSize"`
+	}
+	err := decoder.Decode(&reqData)
+	if err != nil || reqData.FileSize < 1 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(http.StatusText(http.StatusBadRequest)))
+		log.Println(err)
+		return
+	}
+	defer req.Body.Close()
+
+	space, err := h.attachmentSpaceLeft(acc.Id)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+		log.Println(err)
+		return
+	}
+
+	if space != -1 && reqData.FileSize > space {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(errTooLarge.Error()))
+		log.Println(acc.Email + " is out of attachment storage")
+		return
+	}
+
+	att, err := h.db.NewAttachment(bw.Attachment{CipherId: ciph.Id, FileName: reqData.FileName, Key: &reqData.Key}, acc.Id, reqData.FileSize)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+		log.Println(err)
+		return
+	}
+
+	ciph.Attachments = append(ciph.Attachments, att)
+	h.setAttachmentURLs(req, []bw.Cipher{ciph})
+
+	resp := struct {
+		AttachmentId   string
+		Url            string
+		FileUploadType int // 0 is direct upload to the server
+		CipherResponse bw.Cipher
+		Object         string
+	}{
+		AttachmentId:   att.Id,
+		Url:            bw.BaseURL(req) + "/api/ciphers/" + ciph.Id + "/attachment/" + att.Id,
+		FileUploadType: 0,
+		CipherResponse: ciph,
+		Object:         "attachment-fileUpload",
+	}
+
+	data, err := json.Marshal(&resp)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+		log.Println(err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// Stores the data of a multipart upload. A new attachment is created if att is nil
+func (h *APIHandler) uploadAttachment(w http.ResponseWriter, req *http.Request, acc bw.Account, ciph bw.Cipher, att *bw.Attachment) {
+	reader, err := req.MultipartReader()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(http.StatusText(http.StatusBadRequest)))
+		log.Println(err)
+		return
+	}
+
+	newAtt := att == nil
+	if !newAtt && h.blobExists(attachmentBlobName(ciph.Id, att.Id)) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(errAlreadyUploaded.Error()))
+		log.Println("Attachment " + att.Id + " has already been uploaded")
+		return
+	}
+
+	var key *string
+	var size int64
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(http.StatusText(http.StatusBadRequest)))
+			log.Println(err)
+			return
+		}
+
+		if part.FormName() == "key" {
+			b, err := ioutil.ReadAll(io.LimitReader(part, 64*1024))
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(http.StatusText(http.StatusBadRequest)))
+				log.Println(err)
+				return
+			}
+			k := string(b)
+			key = &k
+			continue
+		}
+
+		// Only one file per upload
+		if part.FormName() != "data" || size > 0 {
+			continue
+		}
+
+		// The size was checked against the quota when the attachment was created
+		limit := int64(-1)
+		if newAtt {
+			limit, err = h.attachmentSpaceLeft(acc.Id)
+			if err == nil {
+				var a bw.Attachment
+				a, err = h.db.NewAttachment(bw.Attachment{CipherId: ciph.Id, FileName: part.FileName(), Key: key}, acc.Id, 0)
+				att = &a
+			}
+		} else {
+			limit, err = att.ParseSize()
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+			log.Println(err)
+			return
+		}
+
+		var r io.Reader = part
+		if limit != -1 {
+			r = io.LimitReader(part, limit+1)
+		}
+
+		size, err = h.blobs.Put(attachmentBlobName(ciph.Id, att.Id), r)
+		if err == nil && limit != -1 && size > limit {
+			err = errTooLarge
+		}
+		if err == nil && !newAtt && size != limit {
+			err = errSizeMismatch
+		}
+		if err != nil {
+			h.deleteAttachmentData([]bw.Attachment{*att})
+			if newAtt {
+				h.db.DeleteAttachment(acc.Id, att.Id)
+			}
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			log.Println(err)
+			return
+		}
+	}
+
+	if att == nil || size == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(http.StatusText(http.StatusBadRequest)))
+		log.Println("Attachment upload without data")
+		return
+	}
+
+	// Old clients can send the key after the data, so it's stored when the whole upload is read.
+	// This also marks the attachment as uploaded so it isn't purged
+	if !newAtt {
+		key = att.Key
+	}
+	err = h.db.UpdateAttachment(acc.Id, att.Id, key, size)
+	if err != nil {
+		h.deleteAttachmentData([]bw.Attachment{*att})
+		if newAtt {
+			h.db.DeleteAttachment(acc.Id, att.Id)
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+		log.Println(err)
+		return
+	}
+
+	log.Println("Attachment " + att.Id + " uploaded to cipher " + ciph.Id)
+
+	if !newAtt {
+		w.Write([]byte(""))
+		return
+	}
+
+	// Old clients want the updated cipher back
+	ciph, err = h.db.GetCipher(acc.Id, ciph.Id)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+		log.Println(err)
+		return
+	}
+	h.setAttachmentURLs(req, []bw.Cipher{ciph})
+
+	data, err := json.Marshal(&ciph)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+		log.Println(err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+func (h *APIHandler) deleteAttachment(w http.ResponseWriter, req *http.Request, acc bw.Account, att bw.Attachment) {
+	err := h.db.DeleteAttachment(acc.Id, att.Id)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+		log.Println(err)
+		return
+	}
+
+	h.deleteAttachmentData([]bw.Attachment{att})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(""))
+	log.Println("Attachment " + att.Id + " deleted")
+}
+
+// HandleAttachmentDownload serves the encrypted attachment data if the URL is signed and hasn't expired.
+// The clients don't authenticate these requests, so the signed URL is what gives access
+func (h *APIHandler) HandleAttachmentDownload(w http.ResponseWriter, req *http.Request) {
+	ids := strings.Split(strings.TrimPrefix(req.URL.Path, "/attachments/"), "/")
+	if len(ids) != 2 || req.Method != "GET" {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(http.StatusText(http.StatusNotFound)))
+		return
+	}
+
+	err := h.checkDownloadURL(req)
+	if err != nil {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(http.StatusText(http.StatusForbidden)))
+		log.Println("Download link for attachment " + ids[1] + ": " + err.Error())
+		return
+	}
+
+	att, err := h.db.GetAttachment(ids[0], ids[1])
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(http.StatusText(http.StatusNotFound)))
+		log.Println(err)
+		return
+	}
+
+	r, err := h.blobs.Get(attachmentBlobName(att.CipherId, att.Id))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(http.StatusText(http.StatusNotFound)))
+		log.Println(err)
+		return
+	}
+	defer r.Close()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	io.Copy(w, r)
+}
+
+// PurgePendingAttachments deletes the attachments that newer clients created but never uploaded,
+// so they don't count against the quota
+func (h *APIHandler) PurgePendingAttachments() {
+	atts, err := h.db.PurgePendingAttachments(time.Now().Add(-attachmentUploadTime))
+	if err != nil {
+		log.Println("Purging attachments: " + err.Error())
+		return
+	}
+
+	h.deleteAttachmentData(atts)
+}
@@ -0,0 +1,154 @@
+package api
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	bw "github.com/VictorNine/bitwarden-go/internal/common"
+)
+
+func (db *mockDB) GetAttachment(ciphID string, attID string) (bw.Attachment, error) {
+	att, ok := db.attachments[attID]
+	if !ok || att.CipherId != ciphID {
+		return bw.Attachment{}, errors.New("Attachment not found")
+	}
+
+	return att, nil
+}
+
+func (db *mockDB) NewAttachment(att bw.Attachment, owner string, size int64) (bw.Attachment, error) {
+	att.Id = "att" + strconv.Itoa(len(db.attachments)+1)
+	att.SetSize(size)
+	db.attachments[att.Id] = att
+	return att, nil
+}
+
+func (db *mockDB) UpdateAttachment(owner string, attID string, key *string, size int64) error {
+	att, ok := db.attachments[attID]
+	if !ok {
+		return errors.New("Attachment not found")
+	}
+
+	att.Key = key
+	att.SetSize(size)
+	db.attachments[attID] = att
+	return nil
+}
+
+func (db *mockDB) DeleteAttachment(owner string, attID string) error {
+	delete(db.attachments, attID)
+	return nil
+}
+
+func TestAttachmentReupload(t *testing.T) {
+	att := bw.Attachment{Id: "att1", CipherId: "10"}
+	att.SetSize(4)
+	db := &mockDB{
+		accounts:    []bw.Account{{Id: "1", Email: "nobody@example.com"}},
+		ciphers:     map[string]bw.Cipher{cipherKey("1", "10"): {Id: "10", Edit: true}},
+		attachments: map[string]bw.Attachment{"att1": att},
+	}
+	blobs := mockBlobs{}
+	h := New(db, blobs, nil, 0)
+
+	upload := func(data string) int {
+		res := httptest.NewRecorder()
+		h.HandleCipherUpdate(res, uploadRequest("/api/ciphers/10/attachment/att1", "nobody@example.com", "data", data))
+		return res.Code
+	}
+
+	// A failed upload can be retried
+	if code := upload("wrong"); code != 400 || blobs["10/att1"] != nil {
+		t.Fatalf("Upload with the wrong size: expected 400 without data got %v", code)
+	}
+	if code := upload("good"); code != 200 || string(blobs["10/att1"]) != "good" {
+		t.Fatalf("Expected 200 got %v", code)
+	}
+
+	// But data that is stored is never replaced or removed
+	for _, data := range []string{"evil", "wrong"} {
+		if code := upload(data); code != 400 {
+			t.Errorf("Upload of %s: expected 400 got %v", data, code)
+		}
+	}
+	if string(blobs["10/att1"]) != "good" {
+		t.Fatalf("Stored data changed to %s", blobs["10/att1"])
+	}
+}
+
+func TestAttachmentKeyAfterData(t *testing.T) {
+	db := &mockDB{
+		accounts:    []bw.Account{{Id: "1", Email: "nobody@example.com"}},
+		ciphers:     map[string]bw.Cipher{cipherKey("1", "10"): {Id: "10", Edit: true}},
+		attachments: map[string]bw.Attachment{},
+	}
+	blobs := mockBlobs{}
+	h := New(db, blobs, nil, 0)
+
+	for _, parts := range [][]string{
+		{"key", "2.first", "data", "data"},
+		{"data", "data", "key", "2.second"},
+	} {
+		res := httptest.NewRecorder()
+		h.HandleCipherUpdate(res, uploadRequest("/api/ciphers/10/attachment", "nobody@example.com", parts...))
+		if res.Code != 200 {
+			t.Fatalf("Expected 200 got %v", res.Code)
+		}
+	}
+
+	for id, key := range map[string]string{"att1": "2.first", "att2": "2.second"} {
+		att := db.attachments[id]
+		if att.Key == nil || *att.Key != key || att.Size != "4" {
+			t.Errorf("Attachment %s stored without the key %s", id, key)
+		}
+		if string(blobs["10/"+id]) != "data" {
+			t.Errorf("Data of attachment %s not stored", id)
+		}
+	}
+}
+
+func TestAttachmentDownload(t *testing.T) {
+	att := bw.Attachment{Id: "att1", CipherId: "10"}
+	db := &mockDB{attachments: map[string]bw.Attachment{"att1": att}}
+	blobs := mockBlobs{"10/att1": []byte("data")}
+	h := New(db, blobs, nil, 0)
+
+	download := func(h APIHandler, u string) *httptest.ResponseRecorder {
+		res := httptest.NewRecorder()
+		h.HandleAttachmentDownload(res, httptest.NewRequest("GET", u, nil))
+		return res
+	}
+
+	u := h.attachmentURL(httptest.NewRequest("GET", "http://vault.example.com/api/sync", nil), att)
+	if !strings.HasPrefix(u, "http://vault.example.com/attachments/10/att1?") {
+		t.Fatalf("Got wrong URL %s", u)
+	}
+
+	if res := download(h, u); res.Code != 200 || res.Body.String() != "data" {
+		t.Fatalf("Expected the data got %v", res.Code)
+	}
+
+	// The URL only works for the attachment it was made for, and only on this server
+	other := New(db, blobs, nil, 0)
+	for _, u := range []string{
+		"/attachments/10/att1",
+		strings.Replace(u, "att1", "att2", 1),
+		strings.Replace(u, "expires=", "expires=1", 1),
+	} {
+		if res := download(h, u); res.Code != 403 {
+			t.Errorf("Download of %s: expected 403 got %v", u, res.Code)
+		}
+	}
+	if res := download(other, u); res.Code != 403 {
+		t.Errorf("Download with another key: expected 403 got %v", res.Code)
+	}
+
+	expired := h.downloadURL(httptest.NewRequest("GET", "/api/sync", nil), "/attachments/10/att1", -time.Minute)
+	if res := download(h, expired); res.Code != 403 {
+		t.Errorf("Expired download: expected 403 got %v", res.Code)
+	}
+}
@@ -0,0 +1,149 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/VictorNine/bitwarden-go/internal/auth"
+	bw "github.com/VictorNine/bitwarden-go/internal/common"
+)
+
+// The data sent with bulk operations. Only the ids are used by all of them
+type bulkData struct {
+	Ids      []string `json:"ids"`
+	FolderId *string  `json:"folderId"`
+	Favorite bool     `json:"favorite"`
+}
+
+// Decodes a bulk request and looks up the account
+func (h *APIHandler) bulkRequest(w http.ResponseWriter, req *http.Request) (bw.Account, bulkData, bool) {
+	email := auth.GetEmail(req)
+
+	var reqData bulkData
+	acc, err := h.db.GetAccount(email, "")
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(http.StatusText(http.StatusUnauthorized)))
+		log.Println(err)
+		return acc, reqData, false
+	}
+
+	decoder := json.NewDecoder(req.Body)
+	err = decoder.Decode(&reqData)
+	if err != nil || len(reqData.Ids) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(http.StatusText(http.StatusBadRequest)))
+		log.Println(err)
+		return acc, reqData, false
+	}
+	defer req.Body.Close()
+
+	return acc, reqData, true
+}
+
+// Handles /api/ciphers/delete. PUT moves the ciphers to the trash, POST and DELETE deletes them permanently
+func (h *APIHandler) HandleCiphersDelete(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "PUT" && req.Method != "POST" && req.Method != "DELETE" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte(http.StatusText(http.StatusMethodNotAllowed)))
+		return
+	}
+
+	acc, reqData, ok := h.bulkRequest(w, req)
+	if !ok {
+		return
+	}
+
+	if req.Method == "PUT" {
+		h.trashCiphers(w, acc, reqData.Ids)
+		return
+	}
+
+	atts, err := h.db.DeleteCiphers(acc.Id, reqData.Ids)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(http.StatusText(http.StatusBadRequest)))
+		log.Println(err)
+		return
+	}
+
+	h.deleteAttachmentData(atts)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(""))
+	log.Println("Ciphers " + strings.Join(reqData.Ids, ", ") + " deleted")
+}
+
+// Handles /api/ciphers/restore
+func (h *APIHandler) HandleCiphersRestore(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "PUT" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte(http.StatusText(http.StatusMethodNotAllowed)))
+		return
+	}
+
+	acc, reqData, ok := h.bulkRequest(w, req)
+	if !ok {
+		return
+	}
+
+	h.restoreCiphers(w, req, acc, reqData.Ids, true)
+}
+
+// Handles /api/ciphers/move
+func (h *APIHandler) HandleCiphersMove(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "PUT" && req.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte(http.StatusText(http.StatusMethodNotAllowed)))
+		return
+	}
+
+	acc, reqData, ok := h.bulkRequest(w, req)
+	if !ok {
+		return
+	}
+
+	if reqData.FolderId != nil && *reqData.FolderId == "" {
+		reqData.FolderId = nil
+	}
+
+	err := h.db.MoveCiphers(acc.Id, reqData.Ids, reqData.FolderId)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(http.StatusText(http.StatusBadRequest)))
+		log.Println(err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(""))
+	log.Println("Ciphers " + strings.Join(reqData.Ids, ", ") + " moved")
+}
+
+// Handles /api/ciphers/favorite
+func (h *APIHandler) HandleCiphersFavorite(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "PUT" && req.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte(http.StatusText(http.StatusMethodNotAllowed)))
+		return
+	}
+
+	acc, reqData, ok := h.bulkRequest(w, req)
+	if !ok {
+		return
+	}
+
+	err := h.db.FavoriteCiphers(acc.Id, reqData.Ids, reqData.Favorite)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(http.StatusText(http.StatusBadRequest)))
+		log.Println(err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(""))
+	log.Println("Ciphers " + strings.Join(reqData.Ids, ", ") + " updated")
+}
@@ -0,0 +1,197 @@
+package api
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	bw "github.com/VictorNine/bitwarden-go/internal/common"
+)
+
+func (db *mockDB) DeleteCiphers(owner string, ciphIDs []string) ([]bw.Attachment, error) {
+	if err := db.canEdit(owner, ciphIDs); err != nil {
+		return nil, err
+	}
+
+	var atts []bw.Attachment
+	for key, ciph := range db.ciphers {
+		for _, id := range ciphIDs {
+			if ciph.Id == id {
+				delete(db.ciphers, key)
+			}
+		}
+	}
+	for _, att := range db.attachments {
+		for _, id := range ciphIDs {
+			if att.CipherId == id {
+				atts = append(atts, att)
+				delete(db.attachments, att.Id)
+			}
+		}
+	}
+
+	return atts, nil
+}
+
+// Changes the user's own folder or favorite of the ciphers. Like the database, this only needs view access
+func (db *mockDB) updateUserCiphers(owner string, ciphIDs []string, update func(ciph *bw.Cipher)) error {
+	for _, id := range ciphIDs {
+		if _, ok := db.ciphers[cipherKey(owner, id)]; !ok {
+			return errors.New("Cipher " + id + " not found")
+		}
+	}
+
+	for _, id := range ciphIDs {
+		ciph := db.ciphers[cipherKey(owner, id)]
+		update(&ciph)
+		db.ciphers[cipherKey(owner, id)] = ciph
+	}
+	return nil
+}
+
+func (db *mockDB) FavoriteCiphers(owner string, ciphIDs []string, favorite bool) error {
+	return db.updateUserCiphers(owner, ciphIDs, func(ciph *bw.Cipher) { ciph.Favorite = favorite })
+}
+
+// Keeps the folders of the users by user id and folder id
+type folderDB struct {
+	*mockDB
+	folders map[string]bw.Folder
+}
+
+// Makes the cipher database with folder f1 of the first user and f2 of the second
+func newFolderDB() *folderDB {
+	return &folderDB{
+		mockDB: newCipherDB(),
+		folders: map[string]bw.Folder{
+			cipherKey("1", "f1"): {Id: "f1"},
+			cipherKey("2", "f2"): {Id: "f2"},
+		},
+	}
+}
+
+func (db *folderDB) MoveCiphers(owner string, ciphIDs []string, folderID *string) error {
+	if folderID != nil {
+		if _, ok := db.folders[cipherKey(owner, *folderID)]; !ok {
+			return errors.New("Folder " + *folderID + " not found")
+		}
+	}
+
+	return db.updateUserCiphers(owner, ciphIDs, func(ciph *bw.Cipher) { ciph.FolderId = folderID })
+}
+
+func TestDeleteCiphers(t *testing.T) {
+	db := newCipherDB()
+	blobs := mockBlobs{"10/att1": []byte("data"), "20/att2": []byte("data")}
+	h := New(db, blobs, nil, 0)
+
+	remove := func(email string, body string) int {
+		res := httptest.NewRecorder()
+		h.HandleCiphersDelete(res, userRequest("POST", "/api/ciphers/delete", email, body))
+		return res.Code
+	}
+
+	// Nothing is deleted if one of the ciphers can't be edited
+	for _, body := range []string{`{"ids":["10","20"]}`, `{"ids":["10","30"]}`} {
+		if code := remove("nobody@example.com", body); code != 400 {
+			t.Fatalf("%s: expected 400 got %v", body, code)
+		}
+	}
+	if _, ok := db.ciphers[cipherKey("1", "10")]; !ok || blobs["10/att1"] == nil {
+		t.Fatal("Cipher 10 deleted by a failed request")
+	}
+
+	if code := remove("nobody@example.com", `{"ids":["10"]}`); code != 200 {
+		t.Fatalf("Expected 200 got %v", code)
+	}
+	if _, ok := db.ciphers[cipherKey("1", "10")]; ok || blobs["10/att1"] != nil {
+		t.Fatal("Cipher 10 or its attachment data not deleted")
+	}
+
+	// The shared cipher is gone for everyone
+	if code := remove("other@example.com", `{"ids":["20"]}`); code != 200 {
+		t.Fatalf("Expected 200 got %v", code)
+	}
+	if _, ok := db.ciphers[cipherKey("1", "20")]; ok || blobs["20/att2"] != nil {
+		t.Fatal("Cipher 20 or its attachment data not deleted")
+	}
+}
+
+func TestMoveCiphers(t *testing.T) {
+	db := newFolderDB()
+	h := New(db, mockBlobs{}, nil, 0)
+
+	move := func(body string) int {
+		res := httptest.NewRecorder()
+		h.HandleCiphersMove(res, userRequest("PUT", "/api/ciphers/move", "nobody@example.com", body))
+		return res.Code
+	}
+
+	for _, body := range []string{
+		`{"ids":["10"],"folderId":"f2"}`, // Another user's folder
+		`{"ids":["10","30"],"folderId":"f1"}`,
+		`{"ids":[],"folderId":"f1"}`,
+	} {
+		if code := move(body); code != 400 {
+			t.Errorf("%s: expected 400 got %v", body, code)
+		}
+	}
+	if db.ciphers[cipherKey("1", "10")].FolderId != nil {
+		t.Fatal("Cipher 10 moved by a failed request")
+	}
+
+	if code := move(`{"ids":["10"],"folderId":"f1"}`); code != 200 {
+		t.Fatalf("Expected 200 got %v", code)
+	}
+	if f := db.ciphers[cipherKey("1", "10")].FolderId; f == nil || *f != "f1" {
+		t.Fatal("Cipher 10 not moved to folder f1")
+	}
+
+	// An empty folder moves the cipher out of its folder
+	if code := move(`{"ids":["10"],"folderId":""}`); code != 200 {
+		t.Fatalf("Expected 200 got %v", code)
+	}
+	if db.ciphers[cipherKey("1", "10")].FolderId != nil {
+		t.Fatal("Cipher 10 still in a folder")
+	}
+
+	if code := move(`{"ids":["20"],"folderId":"f1"}`); code != 200 {
+		t.Fatalf("Moving a read only cipher: expected 200 got %v", code)
+	}
+	if db.ciphers[cipherKey("2", "20")].FolderId != nil {
+		t.Fatal("Folder changed for another member")
+	}
+}
+
+func TestFavoriteCiphers(t *testing.T) {
+	db := newCipherDB()
+	h := New(db, mockBlobs{}, nil, 0)
+
+	favorite := func(body string) int {
+		res := httptest.NewRecorder()
+		h.HandleCiphersFavorite(res, userRequest("PUT", "/api/ciphers/favorite", "nobody@example.com", body))
+		return res.Code
+	}
+
+	if code := favorite(`{"ids":["10","30"],"favorite":true}`); code != 400 {
+		t.Fatalf("Expected 400 got %v", code)
+	}
+	if db.ciphers[cipherKey("1", "10")].Favorite {
+		t.Fatal("Cipher 10 changed by a failed request")
+	}
+
+	if code := favorite(`{"ids":["10"],"favorite":true}`); code != 200 || !db.ciphers[cipherKey("1", "10")].Favorite {
+		t.Fatalf("Expected cipher 10 to be a favorite got %v", code)
+	}
+	if code := favorite(`{"ids":["10"],"favorite":false}`); code != 200 || db.ciphers[cipherKey("1", "10")].Favorite {
+		t.Fatalf("Expected cipher 10 not to be a favorite got %v", code)
+	}
+
+	// Every member has their own favorites, so read only access is enough
+	if code := favorite(`{"ids":["20"],"favorite":true}`); code != 200 || !db.ciphers[cipherKey("1", "20")].Favorite {
+		t.Fatalf("Expected cipher 20 to be a favorite got %v", code)
+	}
+	if db.ciphers[cipherKey("2", "20")].Favorite {
+		t.Fatal("Favorite changed for another member")
+	}
+}
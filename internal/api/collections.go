@@ -0,0 +1,355 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/VictorNine/bitwarden-go/internal/auth"
+	bw "github.com/VictorNine/bitwarden-go/internal/common"
+)
+
+// The collections the member can see in the organization
+func (h *APIHandler) memberCollections(acc bw.Account, ou bw.OrganizationUser) ([]bw.Collection, error) {
+	if isOrgAdmin(ou) || ou.AccessAll {
+		return h.db.GetCollections(ou.OrganizationId)
+	}
+
+	userCols, err := h.db.GetUserCollections(acc.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	collections := make([]bw.Collection, 0)
+	for _, col := range userCols {
+		if col.OrganizationId == ou.OrganizationId {
+			col.Object = "collection"
+			collections = append(collections, col)
+		}
+	}
+
+	return collections, nil
+}
+
+// Finds the collection if the member is assigned to it. ReadOnly is set for the member
+func (h *APIHandler) assignedCollection(acc bw.Account, ou bw.OrganizationUser, colID string) (bw.Collection, bool, error) {
+	userCols, err := h.db.GetUserCollections(acc.Id)
+	if err != nil {
+		return bw.Collection{}, false, err
+	}
+
+	for _, col := range userCols {
+		if col.Id == colID && col.OrganizationId == ou.OrganizationId {
+			return col, true, nil
+		}
+	}
+
+	return bw.Collection{}, false, nil
+}
+
+// Admins and members with access to all collections can see everything.
+// Others need to be assigned to the collection
+func (h *APIHandler) canSeeCollection(acc bw.Account, ou bw.OrganizationUser, colID string) (bool, error) {
+	if isOrgAdmin(ou) || ou.AccessAll {
+		return true, nil
+	}
+
+	_, assigned, err := h.assignedCollection(acc, ou, colID)
+	return assigned, err
+}
+
+// Admins and members with access to all collections can edit everything.
+// Others need to be assigned to the collection without read only
+func (h *APIHandler) canEditCollection(acc bw.Account, ou bw.OrganizationUser, colID string) (bool, error) {
+	if isOrgAdmin(ou) || ou.AccessAll {
+		_, err := h.db.GetCollection(ou.OrganizationId, colID)
+		return err == nil, nil
+	}
+
+	col, assigned, err := h.assignedCollection(acc, ou, colID)
+	return assigned && !col.ReadOnly, err
+}
+
+// Checks that the user can add ciphers to all the collections in the organization.
+// An organization cipher has to be in at least one collection
+func (h *APIHandler) canAddToCollections(acc bw.Account, orgID string, collectionIDs []string) error {
+	ou, err := h.getOrgMember(orgID, acc)
+	if err != nil {
+		return err
+	}
+
+	if len(collectionIDs) == 0 {
+		return errors.New("An organization cipher has to be in at least one collection")
+	}
+
+	for _, colID := range collectionIDs {
+		allowed, err := h.canEditCollection(acc, ou, colID)
+		if err != nil {
+			return err
+		}
+		if !allowed {
+			return errors.New("Not allowed to add ciphers to collection " + colID)
+		}
+	}
+
+	return nil
+}
+
+// Managers can manage the collections they are assigned to
+func (h *APIHandler) canManageCollection(acc bw.Account, ou bw.OrganizationUser, colID string) (bool, error) {
+	if isOrgAdmin(ou) {
+		return true, nil
+	}
+
+	if ou.Type != bw.OrgUserManager {
+		return false, nil
+	}
+
+	return h.canEditCollection(acc, ou, colID)
+}
+
+// Handles /api/collections. Lists all collections the user has access to
+func (h *APIHandler) HandleCollections(w http.ResponseWriter, req *http.Request) {
+	email := auth.GetEmail(req)
+
+	acc, err := h.db.GetAccount(email, "")
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(http.StatusText(http.StatusUnauthorized)))
+		log.Println(err)
+		return
+	}
+
+	cols, err := h.db.GetUserCollections(acc.Id)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+		log.Println(err)
+		return
+	}
+
+	collections := bw.Data{Object: "list", Data: cols}
+	data, err := json.Marshal(collections)
+	if err != nil {
+		log.Fatal(err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// Handles /api/organizations/{id}/collections/...
+func (h *APIHandler) handleOrgCollections(w http.ResponseWriter, req *http.Request, acc bw.Account, ou bw.OrganizationUser, colID string) {
+	var action string
+	if i := strings.Index(colID, "/"); i >= 0 {
+		colID, action = colID[:i], colID[i+1:]
+	}
+
+	if colID == "" {
+		switch req.Method {
+		case "GET":
+			cols, err := h.memberCollections(acc, ou)
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+				log.Println(err)
+				return
+			}
+
+			writeJSON(w, bw.Data{Object: "list", Data: cols})
+		case "POST":
+			h.saveCollection(w, req, acc, ou, bw.Collection{OrganizationId: ou.OrganizationId})
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			w.Write([]byte(http.StatusText(http.StatusMethodNotAllowed)))
+		}
+		return
+	}
+
+	visible, err := h.canSeeCollection(acc, ou, colID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+		log.Println(err)
+		return
+	}
+
+	col, err := h.db.GetCollection(ou.OrganizationId, colID)
+	if err != nil || !visible {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(http.StatusText(http.StatusNotFound)))
+		log.Println(err)
+		return
+	}
+
+	allowed, err := h.canManageCollection(acc, ou, col.Id)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+		log.Println(err)
+		return
+	}
+
+	switch {
+	case action == "" && req.Method == "GET":
+		writeJSON(w, col)
+	case !allowed:
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(http.StatusText(http.StatusForbidden)))
+	case action == "" && (req.Method == "PUT" || req.Method == "POST"):
+		h.saveCollection(w, req, acc, ou, col)
+	case (action == "" && req.Method == "DELETE") || (action == "delete" && req.Method == "POST"):
+		if !isOrgAdmin(ou) {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(http.StatusText(http.StatusForbidden)))
+			return
+		}
+
+		err = h.db.DeleteCollection(col.OrganizationId, col.Id)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+			log.Println(err)
+			return
+		}
+
+		w.Write([]byte(""))
+		log.Println("Collection " + col.Id + " deleted")
+	case action == "users" && req.Method == "GET":
+		users, err := h.db.GetCollectionUsers(col.Id)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+			log.Println(err)
+			return
+		}
+
+		writeJSON(w, users)
+	case action == "users" && (req.Method == "PUT" || req.Method == "POST"):
+		decoder := json.NewDecoder(req.Body)
+		var users []bw.SelectionReadOnly
+		err := decoder.Decode(&users)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(http.StatusText(http.StatusBadRequest)))
+			log.Println(err)
+			return
+		}
+		defer req.Body.Close()
+
+		err = h.db.UpdateCollectionUsers(col.OrganizationId, col.Id, users)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(http.StatusText(http.StatusBadRequest)))
+			log.Println(err)
+			return
+		}
+
+		w.Write([]byte(""))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(http.StatusText(http.StatusNotFound)))
+	}
+}
+
+// Creates the collection if it doesn't have an id, updates it if it has
+func (h *APIHandler) saveCollection(w http.ResponseWriter, req *http.Request, acc bw.Account, ou bw.OrganizationUser, col bw.Collection) {
+	if col.Id == "" && !isOrgAdmin(ou) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(http.StatusText(http.StatusForbidden)))
+		return
+	}
+
+	decoder := json.NewDecoder(req.Body)
+	var reqData struct {
+		Name  string                 `json:"name"`
+		Users []bw.SelectionReadOnly `json:"users"`
+	}
+	err := decoder.Decode(&reqData)
+	if err != nil || reqData.Name == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(http.StatusText(http.StatusBadRequest)))
+		log.Println(err)
+		return
+	}
+	defer req.Body.Close()
+
+	col.Name = reqData.Name
+
+	if col.Id == "" {
+		col, err = h.db.NewCollection(col)
+	} else {
+		err = h.db.UpdateCollection(col)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+		log.Println(err)
+		return
+	}
+
+	if reqData.Users != nil {
+		err = h.db.UpdateCollectionUsers(col.OrganizationId, col.Id, reqData.Users)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(http.StatusText(http.StatusBadRequest)))
+			log.Println(err)
+			return
+		}
+	}
+
+	writeJSON(w, col)
+	log.Println("Collection " + col.Id + " saved")
+}
+
+// Handles /api/ciphers/{id}/collections
+func (h *APIHandler) updateCipherCollections(w http.ResponseWriter, req *http.Request, acc bw.Account, ciphID string) {
+	ciph, err := h.db.GetCipher(acc.Id, ciphID)
+	if err != nil || ciph.OrganizationId == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(http.StatusText(http.StatusNotFound)))
+		log.Println(err)
+		return
+	}
+
+	ou, err := h.getOrgMember(*ciph.OrganizationId, acc)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(http.StatusText(http.StatusNotFound)))
+		log.Println(err)
+		return
+	}
+
+	if !ciph.Edit && !isOrgAdmin(ou) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(http.StatusText(http.StatusForbidden)))
+		log.Println(acc.Email + " is not allowed to edit cipher " + ciph.Id)
+		return
+	}
+
+	decoder := json.NewDecoder(req.Body)
+	var reqData struct {
+		CollectionIds []string `json:"collectionIds"`
+	}
+	err = decoder.Decode(&reqData)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(http.StatusText(http.StatusBadRequest)))
+		log.Println(err)
+		return
+	}
+	defer req.Body.Close()
+
+	// Only the collections the user can edit are changed
+	err = h.db.UpdateCipherCollections(acc.Id, *ciph.OrganizationId, ciph.Id, reqData.CollectionIds)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(http.StatusText(http.StatusBadRequest)))
+		log.Println(err)
+		return
+	}
+
+	w.Write([]byte(""))
+	log.Println("Collections updated for cipher " + ciph.Id)
+}
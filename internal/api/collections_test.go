@@ -0,0 +1,199 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	bw "github.com/VictorNine/bitwarden-go/internal/common"
+)
+
+// Keeps the members and collections of the organizations
+type orgDB struct {
+	*mockDB
+	orgUsers        []bw.OrganizationUser
+	collections     []bw.Collection
+	userCollections map[string][]bw.Collection // By user id, ReadOnly is what that user can do
+}
+
+// Makes the cipher database with the first user as a member of organization o1 who can edit collection c1
+// and read c2. The second user is an admin of the organization
+func newOrgDB() *orgDB {
+	org, user1, user2 := "o1", "1", "2"
+	c1 := bw.Collection{Id: "c1", OrganizationId: org}
+	c2 := bw.Collection{Id: "c2", OrganizationId: org}
+	c2ReadOnly := c2
+	c2ReadOnly.ReadOnly = true
+
+	return &orgDB{
+		mockDB: newCipherDB(),
+		orgUsers: []bw.OrganizationUser{
+			{Id: "ou1", UserId: &user1, OrganizationId: org, Status: bw.OrgUserConfirmed, Type: bw.OrgUserUser},
+			{Id: "ou2", UserId: &user2, OrganizationId: org, Status: bw.OrgUserConfirmed, Type: bw.OrgUserAdmin},
+		},
+		collections:     []bw.Collection{c1, c2},
+		userCollections: map[string][]bw.Collection{user1: {c1, c2ReadOnly}},
+	}
+}
+
+func (db *orgDB) GetOrganizationUser(orgID string, userID string) (bw.OrganizationUser, error) {
+	for _, ou := range db.orgUsers {
+		if ou.OrganizationId == orgID && ou.UserId != nil && *ou.UserId == userID {
+			return ou, nil
+		}
+	}
+
+	return bw.OrganizationUser{}, errors.New("Organization user not found")
+}
+
+func (db *orgDB) GetCollection(orgID string, colID string) (bw.Collection, error) {
+	for _, col := range db.collections {
+		if col.OrganizationId == orgID && col.Id == colID {
+			return col, nil
+		}
+	}
+
+	return bw.Collection{}, errors.New("Collection not found")
+}
+
+func (db *orgDB) GetUserCollections(userID string) ([]bw.Collection, error) {
+	return db.userCollections[userID], nil
+}
+
+func (db *orgDB) UpdateCipherCollections(userID string, orgID string, ciphID string, collectionIDs []string) error {
+	for _, colID := range collectionIDs {
+		if _, err := db.GetCollection(orgID, colID); err != nil {
+			return err
+		}
+	}
+
+	db.updateCiphers([]string{ciphID}, func(ciph *bw.Cipher) { ciph.CollectionIds = collectionIDs })
+	return nil
+}
+
+func TestUpdateCipherCollections(t *testing.T) {
+	db := newOrgDB()
+	h := New(db, mockBlobs{}, nil, 0)
+
+	update := func(email string, id string, body string) int {
+		res := httptest.NewRecorder()
+		h.HandleCipherUpdate(res, userRequest("PUT", "/api/ciphers/"+id+"/collections", email, body))
+		return res.Code
+	}
+
+	// The first user can only read cipher 20, so it can't be taken out of its collections
+	for _, body := range []string{`{"collectionIds":[]}`, `{"collectionIds":["c1"]}`} {
+		if code := update("nobody@example.com", "20", body); code != 403 {
+			t.Errorf("%s: expected 403 got %v", body, code)
+		}
+	}
+	if cols := db.ciphers[cipherKey("2", "20")].CollectionIds; len(cols) != 1 || cols[0] != "c2" {
+		t.Fatalf("Collections changed to %v by a read only member", cols)
+	}
+
+	// Personal ciphers are not in collections
+	if code := update("nobody@example.com", "10", `{"collectionIds":["c1"]}`); code != 404 {
+		t.Errorf("Personal cipher: expected 404 got %v", code)
+	}
+
+	if code := update("other@example.com", "20", `{"collectionIds":["c1","c2"]}`); code != 200 {
+		t.Fatalf("Expected 200 got %v", code)
+	}
+	if cols := db.ciphers[cipherKey("1", "20")].CollectionIds; len(cols) != 2 {
+		t.Fatalf("Expected 2 collections got %v", cols)
+	}
+}
+
+func (db *mockDB) NewCipher(ciph bw.Cipher, owner string) (bw.Cipher, error) {
+	ciph.Id = "new"
+	db.ciphers[cipherKey(owner, ciph.Id)] = ciph
+	return ciph, nil
+}
+
+func (db *orgDB) NewOrganizationCipher(ciph bw.Cipher, owner string, collectionIDs []string) (bw.Cipher, error) {
+	ciph.CollectionIds = collectionIDs
+	return db.NewCipher(ciph, owner)
+}
+
+func (db *orgDB) GetOrganization(orgID string) (bw.Organization, error) {
+	return bw.Organization{Id: orgID}, nil
+}
+
+func TestCreateOrganizationCipher(t *testing.T) {
+	db := newOrgDB()
+	h := New(db, mockBlobs{}, nil, 0)
+	ciph := `{"type":1,"name":"2.name","organizationId":"o1"}`
+
+	create := func(email string, body string) int {
+		res := httptest.NewRecorder()
+		h.HandleCipherCreate(res, userRequest("POST", "/api/ciphers/create", email, body))
+		return res.Code
+	}
+
+	// An organization cipher can't be made without collections
+	res := httptest.NewRecorder()
+	h.HandleCipher(res, userRequest("POST", "/api/ciphers", "nobody@example.com", ciph))
+	if res.Code != 400 {
+		t.Errorf("Organization cipher without collections: expected 400 got %v", res.Code)
+	}
+
+	// The first user can only read c2
+	for _, cols := range []string{`[]`, `["c2"]`, `["c1","c2"]`, `["c1","c3"]`} {
+		if code := create("nobody@example.com", `{"cipher":`+ciph+`,"collectionIds":`+cols+`}`); code != 400 {
+			t.Errorf("Collections %s: expected 400 got %v", cols, code)
+		}
+	}
+	if code := create("nobody@example.com", `{"cipher":{"type":1,"name":"2.name"},"collectionIds":["c1"]}`); code != 400 {
+		t.Errorf("Personal cipher in a collection: expected 400 got %v", code)
+	}
+	if _, ok := db.ciphers[cipherKey("1", "new")]; ok {
+		t.Fatal("Cipher created by a failed request")
+	}
+
+	if code := create("nobody@example.com", `{"cipher":`+ciph+`,"collectionIds":["c1"]}`); code != 200 {
+		t.Fatalf("Expected 200 got %v", code)
+	}
+	if cols := db.ciphers[cipherKey("1", "new")].CollectionIds; len(cols) != 1 || cols[0] != "c1" {
+		t.Fatalf("Expected the cipher in c1 got %v", cols)
+	}
+
+	// Admins can use every collection
+	if code := create("other@example.com", `{"cipher":`+ciph+`,"collectionIds":["c2"]}`); code != 200 {
+		t.Fatalf("Admin: expected 200 got %v", code)
+	}
+}
+
+func TestMemberCollections(t *testing.T) {
+	db := newOrgDB()
+	db.collections = append(db.collections, bw.Collection{Id: "c3", OrganizationId: "o1"})
+	h := New(db, mockBlobs{}, nil, 0)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		res := httptest.NewRecorder()
+		h.HandleOrganizationUpdate(res, userRequest("GET", "/api/organizations/o1/collections"+path, "nobody@example.com", ""))
+		return res
+	}
+
+	// The member only sees the assigned collections, and c2 stays read only
+	res := get("")
+	var list struct{ Data []bw.Collection }
+	if err := json.Unmarshal(res.Body.Bytes(), &list); err != nil || res.Code != 200 {
+		t.Fatalf("Expected 200 got %v %v", res.Code, err)
+	}
+	readOnly := map[string]bool{}
+	for _, col := range list.Data {
+		readOnly[col.Id] = col.ReadOnly
+	}
+	if !reflect.DeepEqual(readOnly, map[string]bool{"c1": false, "c2": true}) {
+		t.Errorf("Expected c1 and read only c2 got %v", list.Data)
+	}
+
+	if res = get("/c2"); res.Code != 200 {
+		t.Errorf("Assigned collection: expected 200 got %v", res.Code)
+	}
+	if res = get("/c3"); res.Code != 404 {
+		t.Errorf("Unassigned collection: expected 404 got %v", res.Code)
+	}
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	bw "github.com/VictorNine/bitwarden-go/internal/common"
+)
+
+var (
+	errLinkExpired      = errors.New("The download link has expired")
+	errInvalidSignature = errors.New("The download link has an invalid signature")
+)
+
+// Signs the path and expiry time of a download URL
+func (h *APIHandler) downloadSignature(path string, expires int64) []byte {
+	mac := hmac.New(sha256.New, h.urlKey)
+	mac.Write([]byte(path + "?" + strconv.FormatInt(expires, 10)))
+	return mac.Sum(nil)
+}
+
+// Makes a download URL for the path that works for the given time.
+// Anyone with the URL can download, so access has to be checked before it's made
+func (h *APIHandler) downloadURL(req *http.Request, path string, valid time.Duration) string {
+	expires := time.Now().Add(valid).Unix()
+
+	params := url.Values{}
+	params.Set("expires", strconv.FormatInt(expires, 10))
+	params.Set("signature", base64.RawURLEncoding.EncodeToString(h.downloadSignature(path, expires)))
+
+	return bw.BaseURL(req) + path + "?" + params.Encode()
+}
+
+// Checks that the request is for a download URL we signed that hasn't expired
+func (h *APIHandler) checkDownloadURL(req *http.Request) error {
+	expires, err := strconv.ParseInt(req.URL.Query().Get("expires"), 10, 64)
+	if err != nil {
+		return err
+	}
+
+	if time.Now().Unix() > expires {
+		return errLinkExpired
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(req.URL.Query().Get("signature"))
+	if err != nil {
+		return err
+	}
+
+	if !hmac.Equal(signature, h.downloadSignature(req.URL.Path, expires)) {
+		return errInvalidSignature
+	}
+
+	return nil
+}
@@ -0,0 +1,481 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/VictorNine/bitwarden-go/internal/auth"
+	bw "github.com/VictorNine/bitwarden-go/internal/common"
+)
+
+// The longest wait time the grantor can choose
+const maxEmergencyWaitDays = 90
+
+// Handles /api/emergency-access/...
+func (h *APIHandler) HandleEmergencyAccess(w http.ResponseWriter, req *http.Request) {
+	email := auth.GetEmail(req)
+
+	acc, err := h.db.GetAccount(email, "")
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(http.StatusText(http.StatusUnauthorized)))
+		log.Println(err)
+		return
+	}
+
+	id := strings.TrimPrefix(req.URL.Path, "/api/emergency-access/")
+	var action string
+	if i := strings.Index(id, "/"); i >= 0 {
+		id, action = id[:i], id[i+1:]
+	}
+
+	switch {
+	case id == "trusted" && req.Method == "GET":
+		accesses, err := h.db.GetEmergencyAccessGrantees(acc.Id)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+			log.Println(err)
+			return
+		}
+
+		details := make([]bw.EmergencyAccessGranteeDetails, 0, len(accesses))
+		for _, ea := range accesses {
+			details = append(details, ea.GetGranteeDetails())
+		}
+		writeJSON(w, bw.Data{Object: "list", Data: details})
+		return
+	case id == "granted" && req.Method == "GET":
+		accesses, err := h.db.GetEmergencyAccessGrantors(acc.Id)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+			log.Println(err)
+			return
+		}
+
+		details := make([]bw.EmergencyAccessGrantorDetails, 0, len(accesses))
+		for _, ea := range accesses {
+			details = append(details, ea.GetGrantorDetails())
+		}
+		writeJSON(w, bw.Data{Object: "list", Data: details})
+		return
+	case id == "invite" && req.Method == "POST":
+		h.inviteEmergencyAccess(w, req, acc)
+		return
+	}
+
+	ea, err := h.db.GetEmergencyAccess(id)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(http.StatusText(http.StatusNotFound)))
+		log.Println(err)
+		return
+	}
+
+	// The user isn't the grantee before the invitation is accepted
+	if action == "accept" && req.Method == "POST" {
+		h.acceptEmergencyAccess(w, req, acc, ea)
+		return
+	}
+
+	isGrantor := ea.GrantorId == acc.Id
+	isGrantee := ea.GranteeId != nil && *ea.GranteeId == acc.Id
+	if !isGrantor && !isGrantee {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(http.StatusText(http.StatusNotFound)))
+		log.Println(acc.Email + " is not part of emergency access " + ea.Id)
+		return
+	}
+
+	switch {
+	case action == "" && req.Method == "GET" && isGrantor:
+		writeJSON(w, ea.GetGranteeDetails())
+	case action == "" && req.Method == "GET":
+		writeJSON(w, ea.GetGrantorDetails())
+	case action == "" && (req.Method == "PUT" || req.Method == "POST") && isGrantor:
+		h.updateEmergencyAccess(w, req, ea)
+	case (action == "" && req.Method == "DELETE") || (action == "delete" && req.Method == "POST"):
+		err = h.db.DeleteEmergencyAccess(ea.Id)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+			log.Println(err)
+			return
+		}
+
+		w.Write([]byte(""))
+		log.Println(acc.Email + " deleted emergency access " + ea.Id)
+	case action == "reinvite" && req.Method == "POST" && isGrantor:
+		h.reinviteEmergencyAccess(w, req, acc, ea)
+	case action == "confirm" && req.Method == "POST" && isGrantor:
+		h.confirmEmergencyAccess(w, req, acc, ea)
+	case action == "initiate" && req.Method == "POST" && isGrantee:
+		if h.changeEmergencyAccessStatus(w, ea, bw.EmergencyAccessRecoveryInitiated, bw.EmergencyAccessConfirmed) {
+			h.sendEmergencyMail(ea.GrantorEmail, "Emergency access requested", acc.Email+" has requested emergency access to your vault. "+
+				"Access is granted automatically in "+strconv.Itoa(ea.WaitTimeDays)+" days unless you reject the request.")
+		}
+	case action == "approve" && req.Method == "POST" && isGrantor:
+		if h.changeEmergencyAccessStatus(w, ea, bw.EmergencyAccessRecoveryApproved, bw.EmergencyAccessRecoveryInitiated) {
+			h.sendEmergencyMail(granteeEmail(ea), "Emergency access approved", acc.Email+" has approved your request for emergency access.")
+		}
+	case action == "reject" && req.Method == "POST" && isGrantor:
+		if h.changeEmergencyAccessStatus(w, ea, bw.EmergencyAccessConfirmed, bw.EmergencyAccessRecoveryInitiated, bw.EmergencyAccessRecoveryApproved) {
+			h.sendEmergencyMail(granteeEmail(ea), "Emergency access rejected", acc.Email+" has rejected your request for emergency access.")
+		}
+	case action == "view" && req.Method == "POST" && isGrantee:
+		h.viewEmergencyAccess(w, req, ea)
+	case action == "takeover" && req.Method == "POST" && isGrantee:
+		h.takeoverEmergencyAccess(w, ea)
+	case action == "password" && req.Method == "POST" && isGrantee:
+		h.takeoverPassword(w, req, acc, ea)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(http.StatusText(http.StatusNotFound)))
+	}
+}
+
+// The grantee has no account email before the invitation is accepted
+func granteeEmail(ea bw.EmergencyAccess) string {
+	if ea.GranteeEmail != nil {
+		return *ea.GranteeEmail
+	}
+
+	return ea.Email
+}
+
+// Notifications are only logged if they can't be sent. The change has already been made
+func (h *APIHandler) sendEmergencyMail(to string, subject string, body string) {
+	err := h.mail.Send(to, subject, body)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// Checks the type and wait time sent by the grantor
+func validEmergencyAccess(accessType int, waitTimeDays int) bool {
+	return (accessType == bw.EmergencyAccessView || accessType == bw.EmergencyAccessTakeover) &&
+		waitTimeDays >= 1 && waitTimeDays <= maxEmergencyWaitDays
+}
+
+// The web vault handles the link and sends the token to the accept endpoint
+func emergencyInviteMail(baseURL string, grantor bw.Account, ea bw.EmergencyAccess, token string) string {
+	params := url.Values{}
+	params.Set("id", ea.Id)
+	params.Set("name", grantor.Name)
+	params.Set("email", ea.Email)
+	params.Set("token", token)
+
+	return grantor.Email + " has invited you to be an emergency contact.\n\n" +
+		"Open this link to accept the invitation:\n" +
+		baseURL + "/#/accept-emergency?" + params.Encode() + "\n\n" +
+		"Create an account with this email address first if you don't have one."
+}
+
+func (h *APIHandler) inviteEmergencyAccess(w http.ResponseWriter, req *http.Request, acc bw.Account) {
+	decoder := json.NewDecoder(req.Body)
+	var reqData struct {
+		Email        string `json:"email"`
+		Type         int    `json:"type"`
+		WaitTimeDays int    `json:"waitTimeDays"`
+	}
+	err := decoder.Decode(&reqData)
+	if err != nil || !validEmergencyAccess(reqData.Type, reqData.WaitTimeDays) {
+		writeError(w, http.StatusBadRequest, "Invalid emergency access")
+		log.Println(err)
+		return
+	}
+	defer req.Body.Close()
+
+	email := strings.TrimSpace(reqData.Email)
+	if !strings.Contains(email, "@") || strings.EqualFold(email, acc.Email) {
+		writeError(w, http.StatusBadRequest, "You can't invite yourself")
+		return
+	}
+
+	token, err := newInviteToken()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+		log.Println(err)
+		return
+	}
+
+	ea, err := h.db.NewEmergencyAccess(bw.EmergencyAccess{GrantorId: acc.Id, Email: email, Type: reqData.Type, WaitTimeDays: reqData.WaitTimeDays}, token)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Could not invite "+email)
+		log.Println(err)
+		return
+	}
+
+	err = h.mail.Send(email, "Emergency access invitation", emergencyInviteMail(bw.LinkURL(h.publicURL, req), acc, ea, token))
+	if err != nil {
+		// The invitation is useless if the user never gets it
+		h.db.DeleteEmergencyAccess(ea.Id)
+		writeError(w, http.StatusInternalServerError, "Could not send the invitation to "+email)
+		log.Println(err)
+		return
+	}
+
+	w.Write([]byte(""))
+	log.Println(acc.Email + " invited " + email + " as emergency contact")
+}
+
+func (h *APIHandler) reinviteEmergencyAccess(w http.ResponseWriter, req *http.Request, acc bw.Account, ea bw.EmergencyAccess) {
+	token, err := newInviteToken()
+	if err == nil {
+		err = h.db.ReinviteEmergencyAccess(ea.Id, token)
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "The invitation has already been accepted")
+		log.Println(err)
+		return
+	}
+
+	err = h.mail.Send(ea.Email, "Emergency access invitation", emergencyInviteMail(bw.LinkURL(h.publicURL, req), acc, ea, token))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Could not send the invitation to "+ea.Email)
+		log.Println(err)
+		return
+	}
+
+	w.Write([]byte(""))
+	log.Println(acc.Email + " invited " + ea.Email + " again as emergency contact")
+}
+
+func (h *APIHandler) acceptEmergencyAccess(w http.ResponseWriter, req *http.Request, acc bw.Account, ea bw.EmergencyAccess) {
+	decoder := json.NewDecoder(req.Body)
+	var reqData struct {
+		Token string `json:"token"`
+	}
+	err := decoder.Decode(&reqData)
+	if err != nil || reqData.Token == "" {
+		writeError(w, http.StatusBadRequest, "Invalid invitation")
+		log.Println(err)
+		return
+	}
+	defer req.Body.Close()
+
+	if !strings.EqualFold(ea.Email, acc.Email) || ea.GrantorId == acc.Id {
+		writeError(w, http.StatusBadRequest, "This invitation is for another email address")
+		return
+	}
+
+	err = h.db.AcceptEmergencyAccess(ea.Id, acc.Id, reqData.Token)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid invitation")
+		log.Println(err)
+		return
+	}
+
+	h.sendEmergencyMail(ea.GrantorEmail, "Emergency contact accepted", acc.Email+" has accepted your invitation to be an emergency contact. "+
+		"Confirm the contact in your vault to finish the setup.")
+
+	w.Write([]byte(""))
+	log.Println(acc.Email + " accepted emergency access " + ea.Id)
+}
+
+// The grantor sends the vault key encrypted with the public key of the grantee
+func (h *APIHandler) confirmEmergencyAccess(w http.ResponseWriter, req *http.Request, acc bw.Account, ea bw.EmergencyAccess) {
+	decoder := json.NewDecoder(req.Body)
+	var reqData struct {
+		Key string `json:"key"`
+	}
+	err := decoder.Decode(&reqData)
+	if err != nil || reqData.Key == "" {
+		writeError(w, http.StatusBadRequest, "The key is missing")
+		log.Println(err)
+		return
+	}
+	defer req.Body.Close()
+
+	err = h.db.ConfirmEmergencyAccess(ea.Id, reqData.Key)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "The emergency contact has not accepted the invitation")
+		log.Println(err)
+		return
+	}
+
+	h.sendEmergencyMail(granteeEmail(ea), "Emergency contact confirmed", acc.Email+" has confirmed you as an emergency contact.")
+
+	w.Write([]byte(""))
+	log.Println(acc.Email + " confirmed emergency access " + ea.Id)
+}
+
+func (h *APIHandler) updateEmergencyAccess(w http.ResponseWriter, req *http.Request, ea bw.EmergencyAccess) {
+	decoder := json.NewDecoder(req.Body)
+	var reqData struct {
+		Type         int `json:"type"`
+		WaitTimeDays int `json:"waitTimeDays"`
+	}
+	err := decoder.Decode(&reqData)
+	if err != nil || !validEmergencyAccess(reqData.Type, reqData.WaitTimeDays) {
+		writeError(w, http.StatusBadRequest, "Invalid emergency access")
+		log.Println(err)
+		return
+	}
+	defer req.Body.Close()
+
+	err = h.db.UpdateEmergencyAccess(ea.Id, reqData.Type, reqData.WaitTimeDays)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+		log.Println(err)
+		return
+	}
+
+	ea.Type, ea.WaitTimeDays = reqData.Type, reqData.WaitTimeDays
+	writeJSON(w, ea.GetGranteeDetails())
+}
+
+// Changes the status if it's one of from. Returns false if the status couldn't be changed
+func (h *APIHandler) changeEmergencyAccessStatus(w http.ResponseWriter, ea bw.EmergencyAccess, to int, from ...int) bool {
+	err := h.db.UpdateEmergencyAccessStatus(ea.Id, to, from...)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "The emergency access can't be changed in its current state")
+		log.Println(err)
+		return false
+	}
+
+	w.Write([]byte(""))
+	log.Println("Emergency access " + ea.Id + " changed to status " + strconv.Itoa(to))
+	return true
+}
+
+// Checks that the grantee can use the approved emergency access
+func approvedEmergencyAccess(w http.ResponseWriter, ea bw.EmergencyAccess, accessType int) bool {
+	if ea.Status != bw.EmergencyAccessRecoveryApproved || ea.Type != accessType {
+		writeError(w, http.StatusBadRequest, "Emergency access not valid.")
+		log.Println("Emergency access " + ea.Id + " is not approved")
+		return false
+	}
+
+	return true
+}
+
+// The grantee gets the personal ciphers of the grantor and the key to decrypt them
+func (h *APIHandler) viewEmergencyAccess(w http.ResponseWriter, req *http.Request, ea bw.EmergencyAccess) {
+	if !approvedEmergencyAccess(w, ea, bw.EmergencyAccessView) {
+		return
+	}
+
+	ciphs, err := h.db.GetCiphers(ea.GrantorId)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+		log.Println(err)
+		return
+	}
+
+	// Organization ciphers are encrypted with keys the grantee doesn't have
+	personal := make([]bw.Cipher, 0, len(ciphs))
+	for _, ciph := range ciphs {
+		if ciph.OrganizationId == nil {
+			personal = append(personal, ciph)
+		}
+	}
+	h.setAttachmentURLs(req, personal)
+
+	writeJSON(w, struct {
+		KeyEncrypted *string
+		Ciphers      []bw.Cipher
+		Object       string
+	}{
+		KeyEncrypted: ea.KeyEncrypted,
+		Ciphers:      personal,
+		Object:       "emergencyAccessView",
+	})
+	log.Println("Emergency access " + ea.Id + " used to view the vault")
+}
+
+// The grantee needs the KDF settings of the grantor to set a new master password
+func (h *APIHandler) takeoverEmergencyAccess(w http.ResponseWriter, ea bw.EmergencyAccess) {
+	if !approvedEmergencyAccess(w, ea, bw.EmergencyAccessTakeover) {
+		return
+	}
+
+	grantor, err := h.db.GetAccount(ea.GrantorEmail, "")
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+		log.Println(err)
+		return
+	}
+
+	writeJSON(w, struct {
+		KeyEncrypted   *string
+		Kdf            int
+		KdfIterations  int
+		KdfMemory      *int
+		KdfParallelism *int
+		Object         string
+	}{
+		KeyEncrypted:   ea.KeyEncrypted,
+		Kdf:            grantor.Kdf,
+		KdfIterations:  grantor.KdfIterations,
+		KdfMemory:      grantor.KdfMemory,
+		KdfParallelism: grantor.KdfParallelism,
+		Object:         "emergencyAccessTakeover",
+	})
+}
+
+// Sets a new master password for the grantor. The key is the grantor's key encrypted with the new master key.
+// Two-step login and the memberships of organizations the grantor doesn't own are removed
+func (h *APIHandler) takeoverPassword(w http.ResponseWriter, req *http.Request, acc bw.Account, ea bw.EmergencyAccess) {
+	if !approvedEmergencyAccess(w, ea, bw.EmergencyAccessTakeover) {
+		return
+	}
+
+	decoder := json.NewDecoder(req.Body)
+	var reqData struct {
+		NewMasterPasswordHash string `json:"newMasterPasswordHash"`
+		Key                   string `json:"key"`
+	}
+	err := decoder.Decode(&reqData)
+	if err != nil || reqData.NewMasterPasswordHash == "" || reqData.Key == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(http.StatusText(http.StatusBadRequest)))
+		log.Println(err)
+		return
+	}
+	defer req.Body.Close()
+
+	grantor, err := h.db.GetAccount(ea.GrantorEmail, "")
+	if err == nil {
+		err = auth.SetMasterPassword(&grantor, reqData.NewMasterPasswordHash, reqData.Key)
+	}
+	if err == nil {
+		err = h.db.TakeoverAccount(grantor)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+		log.Println(err)
+		return
+	}
+
+	h.sendEmergencyMail(grantor.Email, "Your master password was changed", acc.Email+" has used emergency access to change your master password.")
+
+	w.Write([]byte(""))
+	log.Println(acc.Email + " took over the account of " + grantor.Email)
+}
+
+// ApproveEmergencyAccess approves the recoveries the grantors haven't rejected within the wait time
+func (h *APIHandler) ApproveEmergencyAccess() {
+	accesses, err := h.db.ApproveEmergencyAccesses(time.Now())
+	if err != nil {
+		log.Println("Approving emergency access: " + err.Error())
+		return
+	}
+
+	for _, ea := range accesses {
+		h.sendEmergencyMail(granteeEmail(ea), "Emergency access approved", "Your request for emergency access to the vault of "+ea.GrantorEmail+" has been approved.")
+		h.sendEmergencyMail(ea.GrantorEmail, "Emergency access granted", granteeEmail(ea)+" has been given emergency access to your vault because the request wasn't rejected in time.")
+		log.Println("Emergency access " + ea.Id + " approved after the wait time")
+	}
+}
@@ -0,0 +1,249 @@
+package api
+
+import (
+	"errors"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	bw "github.com/VictorNine/bitwarden-go/internal/common"
+)
+
+// Keeps the emergency accesses and the tokens of their invitations by emergency access id
+type emergencyDB struct {
+	*mockDB
+	emergencyAccesses []bw.EmergencyAccess
+	emergencyTokens   map[string]string
+}
+
+func (db *emergencyDB) updateAccount(acc bw.Account) {
+	for i := range db.accounts {
+		if db.accounts[i].Id == acc.Id {
+			db.accounts[i] = acc
+		}
+	}
+}
+
+func (db *emergencyDB) GetCiphers(owner string) ([]bw.Cipher, error) {
+	var ciphs []bw.Cipher
+	for key, ciph := range db.ciphers {
+		if strings.HasPrefix(key, owner+"/") {
+			ciphs = append(ciphs, ciph)
+		}
+	}
+
+	return ciphs, nil
+}
+
+func (db *emergencyDB) GetEmergencyAccess(id string) (bw.EmergencyAccess, error) {
+	for _, ea := range db.emergencyAccesses {
+		if ea.Id == id {
+			return ea, nil
+		}
+	}
+
+	return bw.EmergencyAccess{}, errors.New("Emergency access not found")
+}
+
+// Changes the emergency access if it has one of the statuses
+func (db *emergencyDB) updateEmergencyAccess(id string, from []int, update func(ea *bw.EmergencyAccess)) error {
+	for i, ea := range db.emergencyAccesses {
+		if ea.Id != id {
+			continue
+		}
+		for _, status := range from {
+			if ea.Status == status {
+				update(&db.emergencyAccesses[i])
+				return nil
+			}
+		}
+	}
+
+	return errors.New("Emergency access " + id + " can't be changed")
+}
+
+func (db *emergencyDB) AcceptEmergencyAccess(id string, granteeID string, token string) error {
+	if db.emergencyTokens[id] != token {
+		return errors.New("Invalid emergency access invitation " + id)
+	}
+
+	return db.updateEmergencyAccess(id, []int{bw.EmergencyAccessInvited}, func(ea *bw.EmergencyAccess) {
+		ea.GranteeId = &granteeID
+		ea.Status = bw.EmergencyAccessAccepted
+	})
+}
+
+func (db *emergencyDB) UpdateEmergencyAccess(id string, accessType int, waitTimeDays int) error {
+	return db.updateEmergencyAccess(id, []int{bw.EmergencyAccessInvited, bw.EmergencyAccessAccepted, bw.EmergencyAccessConfirmed,
+		bw.EmergencyAccessRecoveryInitiated, bw.EmergencyAccessRecoveryApproved}, func(ea *bw.EmergencyAccess) {
+		ea.Type, ea.WaitTimeDays = accessType, waitTimeDays
+	})
+}
+
+func (db *emergencyDB) UpdateEmergencyAccessStatus(id string, to int, from ...int) error {
+	return db.updateEmergencyAccess(id, from, func(ea *bw.EmergencyAccess) {
+		ea.Status = to
+	})
+}
+
+func (db *emergencyDB) TakeoverAccount(acc bw.Account) error {
+	db.updateAccount(acc)
+	return nil
+}
+
+// Makes a database where the first user has confirmed the second as an emergency contact
+// and has invited invitee@example.com. The third user is not part of either
+func newEmergencyDB() *emergencyDB {
+	org, grantee, granteeEmail, key := "o1", "2", "other@example.com", "4.key"
+
+	db := &emergencyDB{
+		mockDB: &mockDB{
+			accounts: []bw.Account{
+				{Id: "1", Email: "nobody@example.com"},
+				{Id: grantee, Email: granteeEmail},
+				{Id: "3", Email: "outsider@example.com"},
+				{Id: "4", Email: "invitee@example.com"},
+			},
+			ciphers: map[string]bw.Cipher{
+				cipherKey("1", "10"): {Id: "10", Edit: true},
+				cipherKey("1", "20"): {Id: "20", OrganizationId: &org},
+			},
+		},
+		emergencyAccesses: []bw.EmergencyAccess{
+			{Id: "ea1", GrantorId: "1", GranteeId: &grantee, Email: granteeEmail, KeyEncrypted: &key, Type: bw.EmergencyAccessView,
+				Status: bw.EmergencyAccessConfirmed, WaitTimeDays: 7, GrantorEmail: "nobody@example.com", GranteeEmail: &granteeEmail},
+			{Id: "ea2", GrantorId: "1", Email: "invitee@example.com", Type: bw.EmergencyAccessView,
+				Status: bw.EmergencyAccessInvited, WaitTimeDays: 7, GrantorEmail: "nobody@example.com"},
+		},
+		emergencyTokens: map[string]string{"ea2": "token"},
+	}
+	db.setPasswords()
+
+	return db
+}
+
+func emergencyRequest(h APIHandler, method string, path string, email string, body string) int {
+	res := httptest.NewRecorder()
+	h.HandleEmergencyAccess(res, userRequest(method, "/api/emergency-access/"+path, email, body))
+	return res.Code
+}
+
+func TestEmergencyAccessParties(t *testing.T) {
+	db := newEmergencyDB()
+	db.emergencyAccesses[0].Status = bw.EmergencyAccessRecoveryInitiated
+	h := New(db, mockBlobs{}, &mockMailer{}, 0)
+	before := db.emergencyAccesses[0]
+
+	if code := emergencyRequest(h, "GET", "missing", "nobody@example.com", ""); code != 404 {
+		t.Errorf("Unknown emergency access: expected 404 got %v", code)
+	}
+
+	// Others don't even learn that the emergency access exists
+	for _, action := range []string{"", "approve", "reject", "initiate", "view", "takeover", "password", "delete"} {
+		for _, method := range []string{"GET", "POST"} {
+			if code := emergencyRequest(h, method, "ea1/"+action, "outsider@example.com", `{"type":1,"waitTimeDays":1}`); code != 404 {
+				t.Errorf("%s %s by outsider: expected 404 got %v", method, action, code)
+			}
+		}
+	}
+
+	// Only the grantor can change the emergency access
+	for _, req := range []struct{ method, action string }{
+		{"POST", "approve"},
+		{"POST", "reject"},
+		{"POST", "confirm"},
+		{"POST", "reinvite"},
+		{"PUT", ""},
+		{"POST", ""},
+	} {
+		if code := emergencyRequest(h, req.method, "ea1/"+req.action, "other@example.com", `{"type":1,"waitTimeDays":1,"key":"4.other"}`); code != 404 {
+			t.Errorf("%s %s by grantee: expected 404 got %v", req.method, req.action, code)
+		}
+	}
+	if !reflect.DeepEqual(db.emergencyAccesses[0], before) {
+		t.Fatalf("Emergency access changed by a failed request: %v", db.emergencyAccesses[0])
+	}
+
+	if code := emergencyRequest(h, "POST", "ea1/approve", "nobody@example.com", ""); code != 200 {
+		t.Fatalf("Approve by grantor: expected 200 got %v", code)
+	}
+	if db.emergencyAccesses[0].Status != bw.EmergencyAccessRecoveryApproved {
+		t.Fatal("Emergency access not approved")
+	}
+}
+
+func TestEmergencyAccessApproved(t *testing.T) {
+	db := newEmergencyDB()
+	h := New(db, mockBlobs{}, &mockMailer{}, 0)
+	password := `{"newMasterPasswordHash":"bmV3","key":"2.newkey"}`
+
+	// The grantee can only use the access of the type the grantor chose after the recovery is approved
+	for _, c := range []struct {
+		accessType int
+		status     int
+		action     string
+		code       int
+	}{
+		{bw.EmergencyAccessView, bw.EmergencyAccessConfirmed, "view", 400},
+		{bw.EmergencyAccessView, bw.EmergencyAccessRecoveryInitiated, "view", 400},
+		{bw.EmergencyAccessView, bw.EmergencyAccessRecoveryApproved, "takeover", 400},
+		{bw.EmergencyAccessView, bw.EmergencyAccessRecoveryApproved, "password", 400},
+		{bw.EmergencyAccessTakeover, bw.EmergencyAccessRecoveryInitiated, "takeover", 400},
+		{bw.EmergencyAccessTakeover, bw.EmergencyAccessRecoveryInitiated, "password", 400},
+		{bw.EmergencyAccessTakeover, bw.EmergencyAccessRecoveryApproved, "view", 400},
+		{bw.EmergencyAccessView, bw.EmergencyAccessRecoveryApproved, "view", 200},
+		{bw.EmergencyAccessTakeover, bw.EmergencyAccessRecoveryApproved, "takeover", 200},
+	} {
+		db.emergencyAccesses[0].Type, db.emergencyAccesses[0].Status = c.accessType, c.status
+		if code := emergencyRequest(h, "POST", "ea1/"+c.action, "other@example.com", password); code != c.code {
+			t.Errorf("%s with type %d and status %d: expected %d got %v", c.action, c.accessType, c.status, c.code, code)
+		}
+	}
+	if db.accounts[0].Key != "2.key1" {
+		t.Fatal("Master password changed by a failed request")
+	}
+
+	if code := emergencyRequest(h, "POST", "ea1/password", "other@example.com", password); code != 200 {
+		t.Fatalf("Expected 200 got %v", code)
+	}
+	if db.accounts[0].Key != "2.newkey" {
+		t.Fatal("Master password not changed")
+	}
+}
+
+func TestAcceptEmergencyAccess(t *testing.T) {
+	db := newEmergencyDB()
+	mail := &mockMailer{}
+	h := New(db, mockBlobs{}, mail, 0)
+
+	for _, c := range []struct{ email, body string }{
+		{"other@example.com", `{"token":"token"}`},
+		{"nobody@example.com", `{"token":"token"}`},
+		{"invitee@example.com", `{"token":"wrong"}`},
+		{"invitee@example.com", `{}`},
+	} {
+		if code := emergencyRequest(h, "POST", "ea2/accept", c.email, c.body); code != 400 {
+			t.Errorf("Accept by %s with %s: expected 400 got %v", c.email, c.body, code)
+		}
+	}
+	if db.emergencyAccesses[1].Status != bw.EmergencyAccessInvited || len(mail.sent) != 0 {
+		t.Fatal("Invitation accepted by a failed request")
+	}
+
+	if code := emergencyRequest(h, "POST", "ea2/accept", "invitee@example.com", `{"token":"token"}`); code != 200 {
+		t.Fatalf("Expected 200 got %v", code)
+	}
+	ea := db.emergencyAccesses[1]
+	if ea.Status != bw.EmergencyAccessAccepted || ea.GranteeId == nil || *ea.GranteeId != "4" {
+		t.Fatalf("Invitation not accepted: %v", ea)
+	}
+	if !reflect.DeepEqual(mail.sent, []string{"nobody@example.com"}) {
+		t.Errorf("Expected mail to the grantor got %v", mail.sent)
+	}
+
+	// The invitation can only be used once
+	if code := emergencyRequest(h, "POST", "ea2/accept", "invitee@example.com", `{"token":"token"}`); code != 400 {
+		t.Errorf("Second accept: expected 400 got %v", code)
+	}
+}
@@ -0,0 +1,114 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/VictorNine/bitwarden-go/internal/auth"
+	bw "github.com/VictorNine/bitwarden-go/internal/common"
+)
+
+// Handles /api/accounts/key. The client re-encrypts the whole vault with a new user key
+func (h *APIHandler) HandleKeyRotation(w http.ResponseWriter, req *http.Request) {
+	email := auth.GetEmail(req)
+
+	if req.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte(http.StatusText(http.StatusMethodNotAllowed)))
+		return
+	}
+
+	log.Println(email + " is trying to rotate the encryption key")
+
+	acc, err := h.db.GetAccount(email, "")
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(http.StatusText(http.StatusUnauthorized)))
+		log.Println(err)
+		return
+	}
+
+	decoder := json.NewDecoder(req.Body)
+	var reqData struct {
+		MasterPasswordHash string              `json:"masterPasswordHash"`
+		Key                string              `json:"key"`
+		PrivateKey         string              `json:"privateKey"`
+		Ciphers            []reencryptedCipher `json:"ciphers"`
+		Folders            []struct {
+			Id   string `json:"id"`
+			Name string `json:"name"`
+		} `json:"folders"`
+		Sends []struct {
+			Id  string `json:"id"`
+			Key string `json:"key"` // The rest of the send is encrypted with this key and doesn't change
+		} `json:"sends"`
+	}
+	err = decoder.Decode(&reqData)
+	if err != nil || reqData.Key == "" || reqData.PrivateKey == "" {
+		writeError(w, http.StatusBadRequest, "Invalid key rotation")
+		log.Println(err)
+		return
+	}
+	defer req.Body.Close()
+
+	if !auth.VerifyPassword(acc, reqData.MasterPasswordHash) {
+		writeError(w, http.StatusBadRequest, "Invalid password")
+		log.Println("Wrong password for key rotation by " + email)
+		return
+	}
+
+	ciphers := make([]bw.Cipher, len(reqData.Ciphers))
+	for i, rciph := range reqData.Ciphers {
+		old, err := h.db.GetCipher(acc.Id, rciph.Id)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Cipher "+rciph.Id+" not found")
+			log.Println(err)
+			return
+		}
+
+		ciphers[i], err = rciph.toCipher(old)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			log.Println(err)
+			return
+		}
+	}
+
+	folders := make([]bw.Folder, len(reqData.Folders))
+	for i, f := range reqData.Folders {
+		folders[i] = bw.Folder{Id: f.Id, Name: f.Name}
+	}
+
+	sends := make([]bw.Send, len(reqData.Sends))
+	for i, s := range reqData.Sends {
+		if s.Key == "" {
+			writeError(w, http.StatusBadRequest, "Send "+s.Id+" is missing the key")
+			return
+		}
+		sends[i] = bw.Send{Id: s.Id, Key: s.Key, UserId: acc.Id}
+	}
+
+	acc.Key = reqData.Key
+	acc.KeyPair.EncryptedPrivateKey = reqData.PrivateKey
+
+	// Log out all clients
+	acc.SecurityStamp, err = auth.NewSecurityStamp()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+		log.Println(err)
+		return
+	}
+
+	// Checks that every personal cipher, folder and send was sent
+	err = h.db.RotateKeys(acc, ciphers, folders, sends)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		log.Println(err)
+		return
+	}
+
+	w.Write([]byte(""))
+	log.Println(email + " rotated the encryption key")
+}
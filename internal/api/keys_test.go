@@ -0,0 +1,111 @@
+package api
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/VictorNine/bitwarden-go/internal/auth"
+	bw "github.com/VictorNine/bitwarden-go/internal/common"
+)
+
+// The password hash the client sends for the test accounts
+const testPassword = "cGFzc3dvcmQ="
+
+// Sets testPassword as the master password of all accounts
+func (db *mockDB) setPasswords() {
+	for i := range db.accounts {
+		db.accounts[i].KdfIterations = 1
+		auth.SetMasterPassword(&db.accounts[i], testPassword, "2.key"+db.accounts[i].Id)
+	}
+}
+
+// Records the rotation. The database checks that every personal cipher, folder and send was sent
+type rotationDB struct {
+	*mockDB
+	fail bool
+
+	rotated    bool
+	newAcc     bw.Account
+	newCiphers []bw.Cipher
+	newFolders []bw.Folder
+	newSends   []bw.Send
+}
+
+func (db *rotationDB) RotateKeys(acc bw.Account, ciphers []bw.Cipher, folders []bw.Folder, sends []bw.Send) error {
+	if db.fail {
+		return errors.New("Missing folder f1")
+	}
+
+	db.rotated = true
+	db.newAcc, db.newCiphers, db.newFolders, db.newSends = acc, ciphers, folders, sends
+	return nil
+}
+
+func TestKeyRotation(t *testing.T) {
+	db := &rotationDB{mockDB: newCipherDB()}
+	db.setPasswords()
+	h := New(db, mockBlobs{}, nil, 0)
+
+	// Attachment att1 has its own key, so it has to be re-encrypted with the new key
+	attKey := "2.oldattkey"
+	db.ciphers[cipherKey("1", "10")].Attachments[0].Key = &attKey
+
+	rotate := func(body string) int {
+		res := httptest.NewRecorder()
+		h.HandleKeyRotation(res, userRequest("POST", "/api/accounts/key", "nobody@example.com", body))
+		return res.Code
+	}
+	request := func(password string, ciphers string, sends string) string {
+		return `{"masterPasswordHash":"` + password + `","key":"2.new","privateKey":"2.private",` +
+			`"ciphers":[` + ciphers + `],"folders":[{"id":"f1","name":"2.folder"}],"sends":[` + sends + `]}`
+	}
+	withAttachment := `{"id":"10","name":"2.name","attachments2":{"att1":{"fileName":"2.file","key":"2.attkey"}}}`
+
+	for _, body := range []string{
+		request("d3Jvbmc=", withAttachment, `{"id":"s1","key":"2.s"}`),
+		`{"masterPasswordHash":"` + testPassword + `","privateKey":"2.private","ciphers":[` + withAttachment + `]}`,
+		request(testPassword, withAttachment+`,{"id":"30"}`, `{"id":"s1","key":"2.s"}`),
+		request(testPassword, withAttachment, `{"id":"s1"}`),
+		request(testPassword, `{"id":"10","name":"2.name"}`, `{"id":"s1","key":"2.s"}`),
+		request(testPassword, `{"id":"10","name":"2.name","attachments2":{"att1":{"fileName":"2.file"}}}`, `{"id":"s1","key":"2.s"}`),
+	} {
+		if code := rotate(body); code != 400 {
+			t.Errorf("%s: expected 400 got %v", body, code)
+		}
+	}
+	if db.rotated {
+		t.Fatal("Keys rotated by a failed request")
+	}
+
+	// Anything the database rejects is a bad request as well
+	body := request(testPassword, withAttachment, `{"id":"s1","key":"2.newsendkey"}`)
+	db.fail = true
+	if code := rotate(body); code != 400 {
+		t.Fatalf("Rejected by the database: expected 400 got %v", code)
+	}
+
+	db.fail = false
+	if code := rotate(body); code != 200 {
+		t.Fatalf("Expected 200 got %v", code)
+	}
+
+	if db.newAcc.Key != "2.new" || db.newAcc.KeyPair.EncryptedPrivateKey != "2.private" {
+		t.Error("Account keys not updated")
+	}
+	if db.newAcc.SecurityStamp == "" || db.newAcc.SecurityStamp == db.accounts[0].SecurityStamp {
+		t.Error("Security stamp not changed")
+	}
+	if len(db.newCiphers) != 1 || db.newCiphers[0].Id != "10" || *db.newCiphers[0].Data.Name != "2.name" {
+		t.Fatalf("Cipher not re-encrypted: %v", db.newCiphers)
+	}
+	if att := db.newCiphers[0].Attachments; len(att) != 1 || att[0].FileName != "2.file" || *att[0].Key != "2.attkey" {
+		t.Errorf("Attachment not re-encrypted: %v", att)
+	}
+	if len(db.newFolders) != 1 || db.newFolders[0].Name != "2.folder" {
+		t.Errorf("Folder not re-encrypted: %v", db.newFolders)
+	}
+	if len(db.newSends) != 1 || db.newSends[0].Key != "2.newsendkey" || db.newSends[0].UserId != "1" {
+		t.Errorf("Send key not re-encrypted: %v", db.newSends)
+	}
+}
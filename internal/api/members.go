@@ -0,0 +1,318 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/VictorNine/bitwarden-go/internal/auth"
+	bw "github.com/VictorNine/bitwarden-go/internal/common"
+)
+
+// Handles /api/organizations/{id}/users/...
+func (h *APIHandler) handleOrgUsers(w http.ResponseWriter, req *http.Request, acc bw.Account, ou bw.OrganizationUser, org bw.Organization, path string) {
+	if !isOrgAdmin(ou) && !(ou.Type == bw.OrgUserManager && path == "" && req.Method == "GET") {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(http.StatusText(http.StatusForbidden)))
+		log.Println(acc.Email + " is not allowed to manage the users of organization " + org.Id)
+		return
+	}
+
+	orgUserID := path
+	var action string
+	if i := strings.Index(path, "/"); i >= 0 {
+		orgUserID, action = path[:i], path[i+1:]
+	}
+
+	switch {
+	case path == "" && req.Method == "GET":
+		users, err := h.db.GetOrganizationUsers(org.Id)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+			log.Println(err)
+			return
+		}
+
+		writeJSON(w, bw.Data{Object: "list", Data: users})
+	case path == "invite" && req.Method == "POST":
+		h.inviteOrgUsers(w, req, ou, org)
+	case action == "confirm" && req.Method == "POST":
+		h.confirmOrgUser(w, req, org, orgUserID)
+	case (action == "" && req.Method == "DELETE") || (action == "delete" && req.Method == "POST"):
+		h.removeOrgUser(w, ou, org, orgUserID)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(http.StatusText(http.StatusNotFound)))
+	}
+}
+
+// Creates the invitations and mails them to the users
+func (h *APIHandler) inviteOrgUsers(w http.ResponseWriter, req *http.Request, ou bw.OrganizationUser, org bw.Organization) {
+	decoder := json.NewDecoder(req.Body)
+	var reqData struct {
+		Emails      []string               `json:"emails"`
+		Type        int                    `json:"type"`
+		AccessAll   bool                   `json:"accessAll"`
+		Collections []bw.SelectionReadOnly `json:"collections"`
+	}
+	err := decoder.Decode(&reqData)
+	if err != nil || len(reqData.Emails) == 0 {
+		writeError(w, http.StatusBadRequest, "Invalid invitation")
+		log.Println(err)
+		return
+	}
+	defer req.Body.Close()
+
+	if reqData.Type < bw.OrgUserOwner || reqData.Type > bw.OrgUserManager {
+		writeError(w, http.StatusBadRequest, "Invalid user type")
+		return
+	}
+
+	// Only owners can make new owners
+	if reqData.Type == bw.OrgUserOwner && ou.Type != bw.OrgUserOwner {
+		writeError(w, http.StatusForbidden, "Only owners can invite owners")
+		return
+	}
+
+	// Check all the emails first so nobody is invited if one of them is wrong
+	users, err := h.db.GetOrganizationUsers(org.Id)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+		log.Println(err)
+		return
+	}
+
+	emails := make([]string, len(reqData.Emails))
+	for i, email := range reqData.Emails {
+		emails[i] = strings.TrimSpace(email)
+		if !strings.Contains(emails[i], "@") {
+			writeError(w, http.StatusBadRequest, "Invalid email address "+emails[i])
+			return
+		}
+
+		for _, other := range emails[:i] {
+			if strings.EqualFold(other, emails[i]) {
+				writeError(w, http.StatusBadRequest, emails[i]+" is in the list twice")
+				return
+			}
+		}
+
+		for _, user := range users {
+			if strings.EqualFold(user.Email, emails[i]) {
+				writeError(w, http.StatusBadRequest, emails[i]+" is already in the organization")
+				return
+			}
+		}
+	}
+
+	invited := make([]bw.OrganizationUser, 0, len(emails))
+	tokens := make([]string, 0, len(emails))
+	for _, email := range emails {
+		token, err := newInviteToken()
+		if err != nil {
+			h.removeInvites(org, invited)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+			log.Println(err)
+			return
+		}
+
+		member := bw.OrganizationUser{
+			OrganizationId: org.Id,
+			Email:          email,
+			Type:           reqData.Type,
+			AccessAll:      reqData.AccessAll,
+		}
+		member, err = h.db.InviteOrganizationUser(member, token, reqData.Collections)
+		if err != nil {
+			h.removeInvites(org, invited)
+			writeError(w, http.StatusBadRequest, "Could not invite "+email)
+			log.Println(err)
+			return
+		}
+
+		invited = append(invited, member)
+		tokens = append(tokens, token)
+	}
+
+	for i, member := range invited {
+		err = h.mail.Send(member.Email, "Join "+org.Name, inviteMail(bw.LinkURL(h.publicURL, req), org, member, tokens[i]))
+		if err != nil {
+			// The invitations are useless if the users never get them
+			h.removeInvites(org, invited[i:])
+			writeError(w, http.StatusInternalServerError, "Could not send the invitation to "+member.Email+", only the invitations before it were sent")
+			log.Println(err)
+			return
+		}
+
+		log.Println(member.Email + " invited to organization " + org.Id)
+	}
+
+	w.Write([]byte(""))
+}
+
+// Random token the invited user has to send back to accept the invitation
+func newInviteToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// The web vault handles the link and sends the token to the accept endpoint
+// Deletes invitations that couldn't be completed
+func (h *APIHandler) removeInvites(org bw.Organization, invited []bw.OrganizationUser) {
+	for _, ou := range invited {
+		err := h.db.DeleteOrganizationUser(org.Id, ou.Id)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+func inviteMail(baseURL string, org bw.Organization, invited bw.OrganizationUser, token string) string {
+	params := url.Values{}
+	params.Set("organizationId", org.Id)
+	params.Set("organizationUserId", invited.Id)
+	params.Set("email", invited.Email)
+	params.Set("organizationName", org.Name)
+	params.Set("token", token)
+
+	return "You have been invited to join the organization " + org.Name + ".\n\n" +
+		"Open this link to accept the invitation:\n" +
+		baseURL + "/#/accept-organization?" + params.Encode() + "\n\n" +
+		"Create an account with this email address first if you don't have one."
+}
+
+// Handles /api/organizations/{id}/users/{id}/accept. The user isn't a member of the organization yet
+func (h *APIHandler) acceptOrgInvite(w http.ResponseWriter, req *http.Request, acc bw.Account, orgID string, orgUserID string) {
+	decoder := json.NewDecoder(req.Body)
+	var reqData struct {
+		Token string `json:"token"`
+	}
+	err := decoder.Decode(&reqData)
+	if err != nil || reqData.Token == "" {
+		writeError(w, http.StatusBadRequest, "Invalid invitation")
+		log.Println(err)
+		return
+	}
+	defer req.Body.Close()
+
+	invited, err := h.db.GetOrganizationUserByID(orgID, orgUserID)
+	if err != nil || !strings.EqualFold(invited.Email, acc.Email) {
+		writeError(w, http.StatusBadRequest, "This invitation is for another email address")
+		log.Println(err)
+		return
+	}
+
+	if _, err := h.db.GetOrganizationUser(orgID, acc.Id); err == nil {
+		writeError(w, http.StatusBadRequest, "You are already a member of this organization")
+		return
+	}
+
+	err = h.db.AcceptOrganizationUser(orgID, orgUserID, acc.Id, reqData.Token)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid invitation")
+		log.Println(err)
+		return
+	}
+
+	w.Write([]byte(""))
+	log.Println(acc.Email + " accepted the invitation to organization " + orgID)
+}
+
+// The admin sends the organization key encrypted with the user's public key
+func (h *APIHandler) confirmOrgUser(w http.ResponseWriter, req *http.Request, org bw.Organization, orgUserID string) {
+	decoder := json.NewDecoder(req.Body)
+	var reqData struct {
+		Key string `json:"key"`
+	}
+	err := decoder.Decode(&reqData)
+	if err != nil || reqData.Key == "" {
+		writeError(w, http.StatusBadRequest, "The organization key is missing")
+		log.Println(err)
+		return
+	}
+	defer req.Body.Close()
+
+	err = h.db.ConfirmOrganizationUser(org.Id, orgUserID, reqData.Key)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "The user has not accepted the invitation")
+		log.Println(err)
+		return
+	}
+
+	w.Write([]byte(""))
+	log.Println("Organization user " + orgUserID + " confirmed in organization " + org.Id)
+}
+
+func (h *APIHandler) removeOrgUser(w http.ResponseWriter, ou bw.OrganizationUser, org bw.Organization, orgUserID string) {
+	removed, err := h.db.GetOrganizationUserByID(org.Id, orgUserID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(http.StatusText(http.StatusNotFound)))
+		log.Println(err)
+		return
+	}
+
+	// Only owners can remove owners
+	if removed.Type == bw.OrgUserOwner && ou.Type != bw.OrgUserOwner {
+		writeError(w, http.StatusForbidden, "Only owners can remove owners")
+		return
+	}
+
+	err = h.db.DeleteOrganizationUser(org.Id, orgUserID)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "The user could not be removed")
+		log.Println(err)
+		return
+	}
+
+	w.Write([]byte(""))
+	log.Println("Organization user " + orgUserID + " removed from organization " + org.Id)
+}
+
+// Handles /api/users/{id}/public-key. Used to encrypt the organization key when confirming a user
+func (h *APIHandler) HandleUserPublicKey(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte(http.StatusText(http.StatusMethodNotAllowed)))
+		return
+	}
+
+	userID := strings.TrimPrefix(req.URL.Path, "/api/users/")
+	if !strings.HasSuffix(userID, "/public-key") {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(http.StatusText(http.StatusNotFound)))
+		return
+	}
+	userID = strings.TrimSuffix(userID, "/public-key")
+
+	pubKey, err := h.db.GetPublicKey(userID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(http.StatusText(http.StatusNotFound)))
+		log.Println(err)
+		return
+	}
+
+	log.Println(auth.GetEmail(req) + " fetched the public key of user " + userID)
+	writeJSON(w, struct {
+		UserId    string
+		PublicKey string
+		Object    string
+	}{
+		UserId:    userID,
+		PublicKey: pubKey,
+		Object:    "userKey",
+	})
+}
@@ -0,0 +1,109 @@
+package api
+
+import (
+	"errors"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+
+	bw "github.com/VictorNine/bitwarden-go/internal/common"
+)
+
+func (db *orgDB) GetOrganizationUsers(orgID string) ([]bw.OrganizationUser, error) {
+	var users []bw.OrganizationUser
+	for _, ou := range db.orgUsers {
+		if ou.OrganizationId == orgID {
+			users = append(users, ou)
+		}
+	}
+
+	return users, nil
+}
+
+func (db *orgDB) InviteOrganizationUser(ou bw.OrganizationUser, token string, collections []bw.SelectionReadOnly) (bw.OrganizationUser, error) {
+	for _, col := range collections {
+		if _, err := db.GetCollection(ou.OrganizationId, col.Id); err != nil {
+			return bw.OrganizationUser{}, err
+		}
+	}
+
+	ou.Id = "invited" + strconv.Itoa(len(db.orgUsers)+1)
+	ou.Status = bw.OrgUserInvited
+	db.orgUsers = append(db.orgUsers, ou)
+	return ou, nil
+}
+
+func (db *orgDB) DeleteOrganizationUser(orgID string, orgUserID string) error {
+	for i, ou := range db.orgUsers {
+		if ou.OrganizationId == orgID && ou.Id == orgUserID {
+			db.orgUsers = append(db.orgUsers[:i], db.orgUsers[i+1:]...)
+			return nil
+		}
+	}
+
+	return errors.New("Organization user not found")
+}
+
+// mock mailer that remembers who got mail and fails for one address
+type mockMailer struct {
+	sent []string
+	fail string
+}
+
+func (m *mockMailer) Send(to string, subject string, body string) error {
+	if to == m.fail {
+		return errors.New("Could not send mail to " + to)
+	}
+
+	m.sent = append(m.sent, to)
+	return nil
+}
+
+func TestInviteOrgUsers(t *testing.T) {
+	db := newOrgDB()
+	db.orgUsers[1].Email = "Other@example.com"
+	mail := &mockMailer{fail: "fail@example.com"}
+	h := New(db, mockBlobs{}, mail, 0)
+	org := bw.Organization{Id: "o1", Name: "org"}
+
+	invite := func(body string) int {
+		res := httptest.NewRecorder()
+		h.inviteOrgUsers(res, userRequest("POST", "/api/organizations/o1/users/invite", "other@example.com", body), db.orgUsers[1], org)
+		return res.Code
+	}
+
+	// Nobody is invited if one of the emails is wrong
+	for _, body := range []string{
+		`{"emails":["new@example.com","wrong"],"type":2}`,
+		`{"emails":["new@example.com","NEW@example.com"],"type":2}`,
+		`{"emails":["new@example.com","other@example.com"],"type":2}`,
+		`{"emails":["new@example.com"],"type":2,"collections":[{"id":"c3"}]}`,
+		`{"emails":[],"type":2}`,
+	} {
+		if code := invite(body); code != 400 {
+			t.Errorf("%s: expected 400 got %v", body, code)
+		}
+	}
+	if len(db.orgUsers) != 2 || len(mail.sent) != 0 {
+		t.Fatalf("Users invited by a failed request: %v", mail.sent)
+	}
+
+	// Invitations that couldn't be mailed are removed
+	if code := invite(`{"emails":["new@example.com"," fail@example.com","last@example.com"],"type":2}`); code != 500 {
+		t.Fatalf("Expected 500 got %v", code)
+	}
+	if !reflect.DeepEqual(mail.sent, []string{"new@example.com"}) {
+		t.Fatalf("Expected mail to new@example.com got %v", mail.sent)
+	}
+	if len(db.orgUsers) != 3 || db.orgUsers[2].Email != "new@example.com" {
+		t.Fatalf("Expected only the mailed invitation got %v", db.orgUsers)
+	}
+
+	if code := invite(`{"emails":["last@example.com","another@example.com"],"type":2}`); code != 200 {
+		t.Fatalf("Expected 200 got %v", code)
+	}
+	if len(db.orgUsers) != 5 || len(mail.sent) != 3 {
+		t.Fatalf("Expected 2 more invitations got %v", db.orgUsers)
+	}
+}
@@ -0,0 +1,144 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+
+	"github.com/VictorNine/bitwarden-go/internal/auth"
+	bw "github.com/VictorNine/bitwarden-go/internal/common"
+)
+
+// mock database used by the handler tests. The embedded interface is nil,
+// so a test panics if it calls a method that isn't implemented here
+type mockDB struct {
+	database
+
+	accounts    []bw.Account
+	ciphers     map[string]bw.Cipher // By user id and cipher id, Edit is what that user can do
+	attachments map[string]bw.Attachment
+}
+
+// Makes a database with two users. Cipher 10 is the first user's own, cipher 20 is in collection c2 of
+// organization o1 where the first user can only read and the second can edit. Cipher 30 is the second user's own
+func newCipherDB() *mockDB {
+	org := "o1"
+	att1 := bw.Attachment{Id: "att1", CipherId: "10"}
+	att2 := bw.Attachment{Id: "att2", CipherId: "20"}
+
+	return &mockDB{
+		accounts: []bw.Account{{Id: "1", Email: "nobody@example.com"}, {Id: "2", Email: "other@example.com"}},
+		ciphers: map[string]bw.Cipher{
+			cipherKey("1", "10"): {Id: "10", Edit: true, Attachments: []bw.Attachment{att1}},
+			cipherKey("1", "20"): {Id: "20", OrganizationId: &org, CollectionIds: []string{"c2"}, Attachments: []bw.Attachment{att2}},
+			cipherKey("2", "20"): {Id: "20", OrganizationId: &org, CollectionIds: []string{"c2"}, Attachments: []bw.Attachment{att2}, Edit: true},
+			cipherKey("2", "30"): {Id: "30", Edit: true},
+		},
+		attachments: map[string]bw.Attachment{"att1": att1, "att2": att2},
+	}
+}
+
+func cipherKey(userID string, ciphID string) string {
+	return userID + "/" + ciphID
+}
+
+func (db *mockDB) GetAccount(username string, refreshtoken string) (bw.Account, error) {
+	for _, acc := range db.accounts {
+		if acc.Email == username {
+			return acc, nil
+		}
+	}
+
+	return bw.Account{}, errors.New("Account not found")
+}
+
+func (db *mockDB) GetCipher(owner string, ciphID string) (bw.Cipher, error) {
+	ciph, ok := db.ciphers[cipherKey(owner, ciphID)]
+	if !ok {
+		return bw.Cipher{}, errors.New("Cipher not found")
+	}
+
+	return ciph, nil
+}
+
+// Like the database, changes to many ciphers are all or nothing and need edit access to every one
+func (db *mockDB) canEdit(owner string, ciphIDs []string) error {
+	for _, id := range ciphIDs {
+		if ciph, ok := db.ciphers[cipherKey(owner, id)]; !ok || !ciph.Edit {
+			return errors.New("Cipher " + id + " can't be edited")
+		}
+	}
+
+	return nil
+}
+
+// Changes the ciphers for all the users that can see them
+func (db *mockDB) updateCiphers(ciphIDs []string, update func(ciph *bw.Cipher)) {
+	for key, ciph := range db.ciphers {
+		for _, id := range ciphIDs {
+			if ciph.Id == id {
+				update(&ciph)
+				db.ciphers[key] = ciph
+			}
+		}
+	}
+}
+
+// mock blob store keeping the data in memory
+type mockBlobs map[string][]byte
+
+func (b mockBlobs) Put(name string, r io.Reader) (int64, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return 0, err
+	}
+
+	b[name] = data
+	return int64(len(data)), nil
+}
+
+func (b mockBlobs) Get(name string) (io.ReadCloser, error) {
+	data, ok := b[name]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (b mockBlobs) Delete(name string) error {
+	delete(b, name)
+	return nil
+}
+
+// Makes a request from the user like JwtMiddleware does
+func userRequest(method string, path string, email string, body string) *http.Request {
+	return auth.WithEmail(httptest.NewRequest(method, path, strings.NewReader(body)), email)
+}
+
+// Makes a multipart upload from the user with the form parts in the given order.
+// parts are name and value pairs, the data part is sent as a file
+func uploadRequest(path string, email string, parts ...string) *http.Request {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for i := 0; i+1 < len(parts); i += 2 {
+		var w io.Writer
+		if parts[i] == "data" {
+			w, _ = mw.CreateFormFile("data", "file.txt")
+		} else {
+			w, _ = mw.CreateFormField(parts[i])
+		}
+		w.Write([]byte(parts[i+1]))
+	}
+	mw.Close()
+
+	req := httptest.NewRequest("POST", path, &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return auth.WithEmail(req, email)
+}
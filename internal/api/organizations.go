@@ -0,0 +1,354 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/VictorNine/bitwarden-go/internal/auth"
+	bw "github.com/VictorNine/bitwarden-go/internal/common"
+)
+
+// Looks up the account's membership. Only confirmed members have access to the organization
+func (h *APIHandler) getOrgMember(orgID string, acc bw.Account) (bw.OrganizationUser, error) {
+	ou, err := h.db.GetOrganizationUser(orgID, acc.Id)
+	if err != nil {
+		return ou, err
+	}
+
+	if ou.Status != bw.OrgUserConfirmed {
+		return ou, errors.New(acc.Email + " is not a confirmed member of organization " + orgID)
+	}
+
+	return ou, nil
+}
+
+// Owners and admins can manage everything in the organization
+func isOrgAdmin(ou bw.OrganizationUser) bool {
+	return ou.Type == bw.OrgUserOwner || ou.Type == bw.OrgUserAdmin
+}
+
+// The profile with the organizations the account is a member of
+func (h *APIHandler) getProfile(acc bw.Account) (bw.Profile, error) {
+	prof := acc.GetProfile()
+
+	orgs, err := h.db.GetProfileOrganizations(acc.Id)
+	if err != nil {
+		return prof, err
+	}
+	prof.Organizations = orgs
+
+	return prof, nil
+}
+
+// Handles /api/organizations. Creates a new organization with the user as the owner
+func (h *APIHandler) HandleOrganization(w http.ResponseWriter, req *http.Request) {
+	email := auth.GetEmail(req)
+
+	if req.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte(http.StatusText(http.StatusMethodNotAllowed)))
+		return
+	}
+
+	log.Println(email + " is trying to create an organization")
+
+	acc, err := h.db.GetAccount(email, "")
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(http.StatusText(http.StatusUnauthorized)))
+		log.Println(err)
+		return
+	}
+
+	decoder := json.NewDecoder(req.Body)
+	var reqData struct {
+		Name           string     `json:"name"`
+		BusinessName   *string    `json:"businessName"`
+		BillingEmail   string     `json:"billingEmail"`
+		PlanType       int        `json:"planType"`
+		Key            string     `json:"key"` // The organization key encrypted with the user's key
+		Keys           bw.KeyPair `json:"keys"`
+		CollectionName string     `json:"collectionName"`
+	}
+	err = decoder.Decode(&reqData)
+	if err != nil || reqData.Name == "" || reqData.Key == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(http.StatusText(http.StatusBadRequest)))
+		log.Println(err)
+		return
+	}
+	defer req.Body.Close()
+
+	org := bw.Organization{
+		Name:         reqData.Name,
+		BusinessName: reqData.BusinessName,
+		BillingEmail: reqData.BillingEmail,
+		PlanType:     reqData.PlanType,
+		Keys:         reqData.Keys,
+	}
+
+	owner := bw.OrganizationUser{
+		UserId: &acc.Id,
+		Email:  acc.Email,
+		Key:    reqData.Key,
+	}
+
+	org, err = h.db.NewOrganization(org, owner)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+		log.Println(err)
+		return
+	}
+
+	// The client names the first collection
+	if reqData.CollectionName != "" {
+		_, err = h.db.NewCollection(bw.Collection{OrganizationId: org.Id, Name: reqData.CollectionName})
+		if err != nil {
+			log.Println(err)
+		}
+	}
+
+	data, err := json.Marshal(&org)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+		log.Println(err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+	log.Println("Organization " + org.Id + " created")
+}
+
+// Handles /api/organizations/{id}/...
+func (h *APIHandler) HandleOrganizationUpdate(w http.ResponseWriter, req *http.Request) {
+	email := auth.GetEmail(req)
+
+	// Get the organization id and what to do with it
+	orgID := strings.TrimPrefix(req.URL.Path, "/api/organizations/")
+	var action string
+	if i := strings.Index(orgID, "/"); i >= 0 {
+		orgID, action = orgID[:i], orgID[i+1:]
+	}
+
+	acc, err := h.db.GetAccount(email, "")
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(http.StatusText(http.StatusUnauthorized)))
+		log.Println(err)
+		return
+	}
+
+	// Invited users are not members until they have accepted
+	if strings.HasPrefix(action, "users/") && strings.HasSuffix(action, "/accept") && req.Method == "POST" {
+		h.acceptOrgInvite(w, req, acc, orgID, strings.TrimSuffix(strings.TrimPrefix(action, "users/"), "/accept"))
+		return
+	}
+
+	ou, err := h.getOrgMember(orgID, acc)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(http.StatusText(http.StatusNotFound)))
+		log.Println(err)
+		return
+	}
+
+	org, err := h.db.GetOrganization(orgID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(http.StatusText(http.StatusNotFound)))
+		log.Println(err)
+		return
+	}
+
+	switch {
+	case action == "" && req.Method == "GET":
+		h.writeOrganization(w, org)
+	case action == "" && (req.Method == "PUT" || req.Method == "POST"):
+		h.updateOrganization(w, req, ou, org)
+	case (action == "" && req.Method == "DELETE") || (action == "delete" && req.Method == "POST"):
+		h.deleteOrganization(w, req, acc, ou, org)
+	case action == "keys":
+		h.handleOrganizationKeys(w, req, ou, org)
+	case action == "users" || strings.HasPrefix(action, "users/"):
+		h.handleOrgUsers(w, req, acc, ou, org, strings.TrimPrefix(strings.TrimPrefix(action, "users"), "/"))
+	case action == "collections" || strings.HasPrefix(action, "collections/"):
+		h.handleOrgCollections(w, req, acc, ou, strings.TrimPrefix(strings.TrimPrefix(action, "collections"), "/"))
+	case action == "leave" && req.Method == "POST":
+		err = h.db.DeleteOrganizationUser(org.Id, ou.Id)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(http.StatusText(http.StatusBadRequest)))
+			log.Println(err)
+			return
+		}
+
+		w.Write([]byte(""))
+		log.Println(email + " left organization " + org.Id)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(http.StatusText(http.StatusNotFound)))
+	}
+}
+
+func (h *APIHandler) writeOrganization(w http.ResponseWriter, org bw.Organization) {
+	data, err := json.Marshal(&org)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+		log.Println(err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+func (h *APIHandler) updateOrganization(w http.ResponseWriter, req *http.Request, ou bw.OrganizationUser, org bw.Organization) {
+	if ou.Type != bw.OrgUserOwner {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(http.StatusText(http.StatusForbidden)))
+		return
+	}
+
+	decoder := json.NewDecoder(req.Body)
+	var reqData struct {
+		Name         string  `json:"name"`
+		BusinessName *string `json:"businessName"`
+		BillingEmail string  `json:"billingEmail"`
+	}
+	err := decoder.Decode(&reqData)
+	if err != nil || reqData.Name == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(http.StatusText(http.StatusBadRequest)))
+		log.Println(err)
+		return
+	}
+	defer req.Body.Close()
+
+	org.Name = reqData.Name
+	org.BusinessName = reqData.BusinessName
+	org.BillingEmail = reqData.BillingEmail
+
+	err = h.db.UpdateOrganization(org)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+		log.Println(err)
+		return
+	}
+
+	h.writeOrganization(w, org)
+	log.Println("Organization " + org.Id + " updated")
+}
+
+// Deletes the organization and all the shared ciphers. Requires the owner's password
+func (h *APIHandler) deleteOrganization(w http.ResponseWriter, req *http.Request, acc bw.Account, ou bw.OrganizationUser, org bw.Organization) {
+	if ou.Type != bw.OrgUserOwner {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(http.StatusText(http.StatusForbidden)))
+		return
+	}
+
+	decoder := json.NewDecoder(req.Body)
+	var reqData struct {
+		MasterPasswordHash string `json:"masterPasswordHash"`
+	}
+	err := decoder.Decode(&reqData)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(http.StatusText(http.StatusBadRequest)))
+		log.Println(err)
+		return
+	}
+	defer req.Body.Close()
+
+	if !auth.VerifyPassword(acc, reqData.MasterPasswordHash) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(http.StatusText(http.StatusUnauthorized)))
+		log.Println("Wrong password when deleting organization " + org.Id)
+		return
+	}
+
+	atts, err := h.db.DeleteOrganization(org.Id)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+		log.Println(err)
+		return
+	}
+
+	h.deleteAttachmentData(atts)
+
+	w.Write([]byte(""))
+	log.Println("Organization " + org.Id + " deleted")
+}
+
+// The key pair is created by the client. It can only be set once
+func (h *APIHandler) handleOrganizationKeys(w http.ResponseWriter, req *http.Request, ou bw.OrganizationUser, org bw.Organization) {
+	switch req.Method {
+	case "GET":
+	case "POST":
+		if !isOrgAdmin(ou) {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(http.StatusText(http.StatusForbidden)))
+			return
+		}
+
+		if org.Keys.PublicKey != "" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Organization already has keys"))
+			return
+		}
+
+		decoder := json.NewDecoder(req.Body)
+		var kp bw.KeyPair
+		err := decoder.Decode(&kp)
+		if err != nil || kp.PublicKey == "" || kp.EncryptedPrivateKey == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(http.StatusText(http.StatusBadRequest)))
+			log.Println(err)
+			return
+		}
+		defer req.Body.Close()
+
+		err = h.db.UpdateOrganizationKeys(org.Id, kp)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+			log.Println(err)
+			return
+		}
+		org.Keys = kp
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte(http.StatusText(http.StatusMethodNotAllowed)))
+		return
+	}
+
+	keys := struct {
+		PublicKey  string
+		PrivateKey string
+		Object     string
+	}{
+		PublicKey:  org.Keys.PublicKey,
+		PrivateKey: org.Keys.EncryptedPrivateKey,
+		Object:     "organizationKeys",
+	}
+
+	data, err := json.Marshal(&keys)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+		log.Println(err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
@@ -0,0 +1,350 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	bw "github.com/VictorNine/bitwarden-go/internal/common"
+)
+
+// How long the download URL of a file send works
+const sendDownloadTime = 5 * time.Minute
+
+// How long newer clients have to upload the file after the send is created
+const sendUploadTime = 24 * time.Hour
+
+func sendFileBlobName(sendID, fileID string) string {
+	return "sends/" + sendID + "/" + fileID
+}
+
+// Remove the stored files of sends that are deleted from the database
+func (h *APIHandler) deleteSendFiles(sends []bw.Send) {
+	for _, send := range sends {
+		if send.File == nil {
+			continue
+		}
+
+		err := h.blobs.Delete(sendFileBlobName(send.Id, send.File.Id))
+		if err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// The number of bytes the user can still store in file sends. -1 if there is no limit.
+// The sends waiting for their file count until they are uploaded or purged
+func (h *APIHandler) sendSpaceLeft(owner string) (int64, error) {
+	if h.sendQuota == 0 {
+		return -1, nil
+	}
+
+	sends, err := h.db.GetSends(owner)
+	if err != nil {
+		return 0, err
+	}
+
+	pending, err := h.db.GetPendingSends(owner)
+	if err != nil {
+		return 0, err
+	}
+	sends = append(sends, pending...)
+
+	var used int64
+	for _, send := range sends {
+		if send.File == nil {
+			continue
+		}
+
+		size, err := send.File.ParseSize()
+		if err != nil {
+			return 0, err
+		}
+		used += size
+	}
+
+	if used > h.sendQuota {
+		return 0, nil
+	}
+
+	return h.sendQuota - used, nil
+}
+
+// Handles /api/sends/file/v2. Creates the file send before the data is uploaded
+func (h *APIHandler) newSendFile(w http.ResponseWriter, req *http.Request, acc bw.Account) {
+	decoder := json.NewDecoder(req.Body)
+	var rsend sendRequest
+	err := decoder.Decode(&rsend)
+	if err != nil || rsend.Type != bw.SendTypeFile || rsend.File == nil || rsend.FileLength < 1 {
+		writeError(w, http.StatusBadRequest, "Invalid send")
+		log.Println(err)
+		return
+	}
+	defer req.Body.Close()
+
+	space, err := h.sendSpaceLeft(acc.Id)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+		log.Println(err)
+		return
+	}
+
+	if space != -1 && rsend.FileLength > space {
+		writeError(w, http.StatusBadRequest, errTooLarge.Error())
+		log.Println(acc.Email + " is out of send storage")
+		return
+	}
+
+	send := bw.Send{UserId: acc.Id, File: &bw.SendFile{FileName: rsend.File.FileName}}
+	send.File.SetSize(rsend.FileLength)
+	send, err = rsend.toSend(send)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+		log.Println(err)
+		return
+	}
+
+	if msg := validSend(send, true); msg != "" {
+		writeError(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	send, err = h.db.NewSend(send)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+		log.Println(err)
+		return
+	}
+
+	writeJSON(w, struct {
+		Url            string
+		FileUploadType int // 0 is direct upload to the server
+		SendResponse   bw.Send
+		Object         string
+	}{
+		Url:            bw.BaseURL(req) + "/api/sends/" + send.Id + "/file/" + send.File.Id,
+		FileUploadType: 0,
+		SendResponse:   send,
+		Object:         "send-fileUpload",
+	})
+	log.Println(acc.Email + " created file send " + send.Id)
+}
+
+// Handles /api/sends/{id}/file/{fileId}. Stores the data of a multipart upload
+func (h *APIHandler) uploadSendFile(w http.ResponseWriter, req *http.Request, send bw.Send, fileID string) {
+	if send.File == nil || send.File.Id != fileID {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(http.StatusText(http.StatusNotFound)))
+		return
+	}
+
+	if h.blobExists(sendFileBlobName(send.Id, send.File.Id)) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(errAlreadyUploaded.Error()))
+		log.Println("The file of send " + send.Id + " has already been uploaded")
+		return
+	}
+
+	// The size was checked against the quota when the send was created
+	expected, err := send.File.ParseSize()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+		log.Println(err)
+		return
+	}
+
+	reader, err := req.MultipartReader()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(http.StatusText(http.StatusBadRequest)))
+		log.Println(err)
+		return
+	}
+
+	var size int64
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(http.StatusText(http.StatusBadRequest)))
+			log.Println(err)
+			return
+		}
+
+		// Only one file per upload
+		if part.FormName() != "data" || size > 0 {
+			continue
+		}
+
+		size, err = h.blobs.Put(sendFileBlobName(send.Id, send.File.Id), io.LimitReader(part, expected+1))
+		if err == nil && size != expected {
+			err = errSizeMismatch
+		}
+		if err != nil {
+			h.deleteSendFiles([]bw.Send{send})
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			log.Println(err)
+			return
+		}
+	}
+
+	if size == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(http.StatusText(http.StatusBadRequest)))
+		log.Println("Send upload without data")
+		return
+	}
+
+	// Fails if the send was purged during the upload
+	err = h.db.SendFileUploaded(send.UserId, send.Id)
+	if err != nil {
+		h.deleteSendFiles([]bw.Send{send})
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(http.StatusText(http.StatusNotFound)))
+		log.Println(err)
+		return
+	}
+
+	w.Write([]byte(""))
+	log.Println("File uploaded to send " + send.Id)
+}
+
+// The download URL only works for a short time. The password has been checked before it's made
+func (h *APIHandler) sendFileURL(req *http.Request, send bw.Send) string {
+	return h.downloadURL(req, "/sendfiles/"+send.AccessId+"/"+send.File.Id, sendDownloadTime)
+}
+
+// Handles /api/sends/{accessId}/access/file/{fileId}. Anyone with the link can get the download URL
+func (h *APIHandler) HandleSendFileAccess(w http.ResponseWriter, req *http.Request) {
+	ids := strings.Split(strings.TrimPrefix(req.URL.Path, "/api/sends/"), "/")
+	if len(ids) != 4 || ids[1] != "access" || ids[2] != "file" {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(http.StatusText(http.StatusNotFound)))
+		return
+	}
+
+	if req.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte(http.StatusText(http.StatusMethodNotAllowed)))
+		return
+	}
+
+	decoder := json.NewDecoder(req.Body)
+	var reqData struct {
+		Password string `json:"password"`
+	}
+	err := decoder.Decode(&reqData)
+	if err != nil && err != io.EOF {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(http.StatusText(http.StatusBadRequest)))
+		log.Println(err)
+		return
+	}
+	defer req.Body.Close()
+
+	send, _, err := h.db.GetSendByAccessID(ids[0])
+	if err != nil || !sendAvailable(send) || send.File == nil || send.File.Id != ids[3] {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(http.StatusText(http.StatusNotFound)))
+		log.Println("Send " + ids[0] + " is not available")
+		return
+	}
+
+	if !checkSendAccess(w, send, reqData.Password) {
+		return
+	}
+
+	writeJSON(w, struct {
+		Id     string
+		Url    string
+		Object string
+	}{
+		Id:     send.File.Id,
+		Url:    h.sendFileURL(req, send),
+		Object: "send-fileDownload",
+	})
+}
+
+// HandleSendFileDownload serves the encrypted file of a send if the URL is signed and hasn't expired.
+// The file is removed when the send reaches the max access count
+func (h *APIHandler) HandleSendFileDownload(w http.ResponseWriter, req *http.Request) {
+	ids := strings.Split(strings.TrimPrefix(req.URL.Path, "/sendfiles/"), "/")
+	if len(ids) != 2 || req.Method != "GET" {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(http.StatusText(http.StatusNotFound)))
+		return
+	}
+
+	err := h.checkDownloadURL(req)
+	if err != nil {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(http.StatusText(http.StatusForbidden)))
+		log.Println("Download link for send " + ids[0] + ": " + err.Error())
+		return
+	}
+
+	send, _, err := h.db.GetSendByAccessID(ids[0])
+	if err != nil || !sendAvailable(send) || send.File == nil || send.File.Id != ids[1] {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(http.StatusText(http.StatusNotFound)))
+		log.Println("Send " + ids[0] + " is not available")
+		return
+	}
+
+	r, err := h.blobs.Get(sendFileBlobName(send.Id, send.File.Id))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(http.StatusText(http.StatusNotFound)))
+		log.Println(err)
+		return
+	}
+	defer r.Close()
+
+	// Counting the access fails if another request got the last one
+	count, err := h.db.AccessSend(send.Id)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(http.StatusText(http.StatusNotFound)))
+		log.Println(err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	io.Copy(w, r)
+	log.Println("File of send " + send.Id + " downloaded")
+
+	if send.MaxAccessCount != nil && count >= *send.MaxAccessCount {
+		h.deleteSendFiles([]bw.Send{send})
+	}
+}
+
+// PurgeSends deletes the sends that have passed their deletion date and the file sends
+// that never got their file, so they don't count against the quota
+func (h *APIHandler) PurgeSends() {
+	sends, err := h.db.PurgeSends(time.Now())
+	if err != nil {
+		log.Println("Purging sends: " + err.Error())
+		return
+	}
+
+	h.deleteSendFiles(sends)
+
+	sends, err = h.db.PurgePendingSends(time.Now().Add(-sendUploadTime))
+	if err != nil {
+		log.Println("Purging pending sends: " + err.Error())
+		return
+	}
+
+	h.deleteSendFiles(sends)
+}
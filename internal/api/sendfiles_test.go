@@ -0,0 +1,144 @@
+package api
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	bw "github.com/VictorNine/bitwarden-go/internal/common"
+)
+
+// Keeps the file sends that are waiting for their file apart like the database
+type sendFileDB struct {
+	*mockDB
+	sends   []bw.Send
+	pending map[string]bool
+}
+
+func (db *sendFileDB) GetSend(owner string, sendID string) (bw.Send, error) {
+	for _, send := range db.sends {
+		if send.UserId == owner && send.Id == sendID {
+			return send, nil
+		}
+	}
+
+	return bw.Send{}, errors.New("Send not found")
+}
+
+func (db *sendFileDB) NewSend(send bw.Send) (bw.Send, error) {
+	send.Id = "new"
+	send.File.Id = "newfile"
+	db.sends = append(db.sends, send)
+	db.pending[send.Id] = true
+	return send, nil
+}
+
+func (db *sendFileDB) getSends(owner string, pending bool) []bw.Send {
+	var sends []bw.Send
+	for _, send := range db.sends {
+		if send.UserId == owner && db.pending[send.Id] == pending {
+			sends = append(sends, send)
+		}
+	}
+
+	return sends
+}
+
+func (db *sendFileDB) GetSends(owner string) ([]bw.Send, error) {
+	return db.getSends(owner, false), nil
+}
+
+func (db *sendFileDB) GetPendingSends(owner string) ([]bw.Send, error) {
+	return db.getSends(owner, true), nil
+}
+
+func (db *sendFileDB) SendFileUploaded(owner string, sendID string) error {
+	if !db.pending[sendID] {
+		return errors.New("Send " + sendID + " is not waiting for a file")
+	}
+
+	delete(db.pending, sendID)
+	return nil
+}
+
+// The first user has a file send of 4 bytes that is waiting for the file
+func newSendFileDB() *sendFileDB {
+	file := &bw.SendFile{Id: "file1"}
+	file.SetSize(4)
+	return &sendFileDB{
+		mockDB:  &mockDB{accounts: []bw.Account{{Id: "1", Email: "nobody@example.com"}}},
+		sends:   []bw.Send{{Id: "s1", UserId: "1", File: file}},
+		pending: map[string]bool{"s1": true},
+	}
+}
+
+func TestSendFileReupload(t *testing.T) {
+	db := newSendFileDB()
+	blobs := mockBlobs{}
+	h := New(db, blobs, nil, 0)
+
+	upload := func(data string) int {
+		res := httptest.NewRecorder()
+		h.HandleSendUpdate(res, uploadRequest("/api/sends/s1/file/file1", "nobody@example.com", "data", data))
+		return res.Code
+	}
+
+	// A failed upload can be retried
+	if code := upload("wrong"); code != 400 || blobs["sends/s1/file1"] != nil || !db.pending["s1"] {
+		t.Fatalf("Upload with the wrong size: expected 400 without data got %v", code)
+	}
+	if code := upload("good"); code != 200 || string(blobs["sends/s1/file1"]) != "good" {
+		t.Fatalf("Expected 200 got %v", code)
+	}
+	if db.pending["s1"] {
+		t.Fatal("Send still pending after the upload")
+	}
+
+	// But a file that is stored is never replaced or removed
+	for _, data := range []string{"evil", "wrong"} {
+		if code := upload(data); code != 400 {
+			t.Errorf("Upload of %s: expected 400 got %v", data, code)
+		}
+	}
+	if string(blobs["sends/s1/file1"]) != "good" {
+		t.Fatalf("Stored file changed to %s", blobs["sends/s1/file1"])
+	}
+}
+
+func TestSendFilePurgedDuringUpload(t *testing.T) {
+	db := newSendFileDB()
+	delete(db.pending, "s1")
+	blobs := mockBlobs{}
+	h := New(db, blobs, nil, 0)
+
+	res := httptest.NewRecorder()
+	h.HandleSendUpdate(res, uploadRequest("/api/sends/s1/file/file1", "nobody@example.com", "data", "good"))
+	if res.Code != 404 || blobs["sends/s1/file1"] != nil {
+		t.Fatalf("Expected 404 without data got %v", res.Code)
+	}
+}
+
+func TestSendFileQuota(t *testing.T) {
+	db := newSendFileDB()
+	h := New(db, mockBlobs{}, nil, 0)
+	h.SetSendQuota(10)
+
+	create := func(length int) int {
+		deletion := time.Now().Add(time.Hour).Format(time.RFC3339)
+		body := `{"type":1,"name":"2.name","key":"2.key","file":{"fileName":"2.file"},"fileLength":` + strconv.Itoa(length) +
+			`,"deletionDate":"` + deletion + `"}`
+		res := httptest.NewRecorder()
+		h.HandleSendUpdate(res, userRequest("POST", "/api/sends/file/v2", "nobody@example.com", body))
+		return res.Code
+	}
+
+	// The send waiting for its file keeps its space until it's uploaded or purged
+	if code := create(7); code != 400 {
+		t.Fatalf("Send over the quota: expected 400 got %v", code)
+	}
+	if code := create(6); code != 200 || !db.pending["new"] {
+		t.Fatalf("Expected a pending send got %v", code)
+	}
+}
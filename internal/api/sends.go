@@ -0,0 +1,371 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/pbkdf2"
+
+	"github.com/VictorNine/bitwarden-go/internal/auth"
+	bw "github.com/VictorNine/bitwarden-go/internal/common"
+)
+
+// The official server doesn't allow deletion dates further away than this
+const maxSendDays = 31
+
+// The password is already hashed by the client, but it's hashed again so the stored hash can't be used
+const sendPasswordIterations = 100000
+
+// The send sent by the client
+type sendRequest struct {
+	Type           int
+	Name           string
+	Notes          *string
+	Text           *bw.SendText
+	File           *bw.SendFile
+	FileLength     int64 // Only sent when a file send is created
+	Key            string
+	Password       *string
+	MaxAccessCount *int
+	ExpirationDate *time.Time
+	DeletionDate   time.Time
+	Disabled       bool
+	HideEmail      bool
+}
+
+// Applies the request to the send. The password is only changed if a new one is sent.
+// The file can't be changed after it's uploaded
+func (rsend *sendRequest) toSend(send bw.Send) (bw.Send, error) {
+	send.Type = rsend.Type
+	send.Name = rsend.Name
+	send.Notes = rsend.Notes
+	if send.Type == bw.SendTypeText {
+		send.Text = rsend.Text
+	}
+	send.Key = rsend.Key
+	send.MaxAccessCount = rsend.MaxAccessCount
+	send.ExpirationDate = rsend.ExpirationDate
+	send.DeletionDate = rsend.DeletionDate
+	send.Disabled = rsend.Disabled
+	send.HideEmail = rsend.HideEmail
+	send.RevisionDate = time.Now()
+
+	if rsend.Password != nil && *rsend.Password != "" {
+		hash, err := hashSendPassword(*rsend.Password)
+		if err != nil {
+			return send, err
+		}
+		send.Password = &hash
+	}
+
+	return send, nil
+}
+
+// Returns a message for the user if the send is invalid. Dates in the past are only
+// rejected for new sends so old sends can still be edited
+func validSend(send bw.Send, creating bool) string {
+	now := time.Now()
+
+	switch {
+	case send.Type != bw.SendTypeText && send.Type != bw.SendTypeFile:
+		return "Unsupported send type"
+	case send.Name == "":
+		return "The name is required"
+	case send.Type == bw.SendTypeText && send.Text == nil:
+		return "The text is required"
+	case send.Type == bw.SendTypeFile && (send.File == nil || send.File.FileName == ""):
+		return "The file is required"
+	case send.Key == "":
+		return "The key is required"
+	case send.DeletionDate.After(now.AddDate(0, 0, maxSendDays)):
+		return "You cannot have a Send with a deletion date that far into the future. Adjust the Deletion Date to a value less than 31 days from now and try again."
+	case creating && !send.DeletionDate.After(now):
+		return "You cannot create a Send that is already deleted. Adjust the Deletion Date and try again."
+	case creating && send.ExpirationDate != nil && !send.ExpirationDate.After(now):
+		return "You cannot create a Send that is already expired. Adjust the Expiration Date and try again."
+	case send.MaxAccessCount != nil && *send.MaxAccessCount < 1:
+		return "The maximum access count must be at least 1"
+	}
+
+	return ""
+}
+
+// Stored as base64 salt and hash separated by a dot
+func hashSendPassword(password string) (string, error) {
+	salt := make([]byte, 16)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+
+	hash := pbkdf2.Key([]byte(password), salt, sendPasswordIterations, 256/8, sha256.New)
+	return base64.StdEncoding.EncodeToString(salt) + "." + base64.StdEncoding.EncodeToString(hash), nil
+}
+
+func checkSendPassword(stored string, password string) bool {
+	i := strings.Index(stored, ".")
+	if i < 0 {
+		return false
+	}
+
+	salt, err := base64.StdEncoding.DecodeString(stored[:i])
+	if err != nil {
+		return false
+	}
+	expected, err := base64.StdEncoding.DecodeString(stored[i+1:])
+	if err != nil {
+		return false
+	}
+
+	hash := pbkdf2.Key([]byte(password), salt, sendPasswordIterations, 256/8, sha256.New)
+	return subtle.ConstantTimeCompare(hash, expected) == 1
+}
+
+// Handles /api/sends. Lists or creates sends
+func (h *APIHandler) HandleSends(w http.ResponseWriter, req *http.Request) {
+	email := auth.GetEmail(req)
+
+	acc, err := h.db.GetAccount(email, "")
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(http.StatusText(http.StatusUnauthorized)))
+		log.Println(err)
+		return
+	}
+
+	switch req.Method {
+	case "GET":
+		sends, err := h.db.GetSends(acc.Id)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+			log.Println(err)
+			return
+		}
+
+		writeJSON(w, bw.Data{Object: "list", Data: sends})
+	case "POST":
+		h.saveSend(w, req, acc, bw.Send{UserId: acc.Id})
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte(http.StatusText(http.StatusMethodNotAllowed)))
+	}
+}
+
+// Handles /api/sends/{id}/...
+func (h *APIHandler) HandleSendUpdate(w http.ResponseWriter, req *http.Request) {
+	email := auth.GetEmail(req)
+
+	id := strings.TrimPrefix(req.URL.Path, "/api/sends/")
+	var action string
+	if i := strings.Index(id, "/"); i >= 0 {
+		id, action = id[:i], id[i+1:]
+	}
+
+	acc, err := h.db.GetAccount(email, "")
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(http.StatusText(http.StatusUnauthorized)))
+		log.Println(err)
+		return
+	}
+
+	// Newer clients create the file send first and upload the data after
+	if id == "file" && action == "v2" && req.Method == "POST" {
+		h.newSendFile(w, req, acc)
+		return
+	}
+
+	send, err := h.db.GetSend(acc.Id, id)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(http.StatusText(http.StatusNotFound)))
+		log.Println(err)
+		return
+	}
+
+	switch {
+	case action == "" && req.Method == "GET":
+		writeJSON(w, send)
+	case action == "" && req.Method == "PUT":
+		h.saveSend(w, req, acc, send)
+	case action == "" && req.Method == "DELETE":
+		err = h.db.DeleteSend(acc.Id, send.Id)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+			log.Println(err)
+			return
+		}
+
+		h.deleteSendFiles([]bw.Send{send})
+
+		w.Write([]byte(""))
+		log.Println(email + " deleted send " + send.Id)
+	case action == "remove-password" && req.Method == "PUT":
+		send.Password = nil
+		send.RevisionDate = time.Now()
+		err = h.db.UpdateSend(send)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+			log.Println(err)
+			return
+		}
+
+		writeJSON(w, send)
+	case strings.HasPrefix(action, "file/") && req.Method == "POST":
+		h.uploadSendFile(w, req, send, strings.TrimPrefix(action, "file/"))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(http.StatusText(http.StatusNotFound)))
+	}
+}
+
+// Creates the send if it has no id, otherwise updates it
+func (h *APIHandler) saveSend(w http.ResponseWriter, req *http.Request, acc bw.Account, send bw.Send) {
+	decoder := json.NewDecoder(req.Body)
+	var rsend sendRequest
+	err := decoder.Decode(&rsend)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid send")
+		log.Println(err)
+		return
+	}
+	defer req.Body.Close()
+
+	creating := send.Id == ""
+	if !creating && rsend.Type != send.Type {
+		writeError(w, http.StatusBadRequest, "Sends can't change type")
+		return
+	}
+
+	if creating && rsend.Type == bw.SendTypeFile {
+		writeError(w, http.StatusBadRequest, "File sends have to be created with /api/sends/file/v2")
+		return
+	}
+
+	send, err = rsend.toSend(send)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+		log.Println(err)
+		return
+	}
+
+	if msg := validSend(send, creating); msg != "" {
+		writeError(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	if creating {
+		send, err = h.db.NewSend(send)
+	} else {
+		err = h.db.UpdateSend(send)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+		log.Println(err)
+		return
+	}
+
+	writeJSON(w, send)
+	log.Println(acc.Email + " saved send " + send.Id)
+}
+
+// Handles /api/sends/access/{id}. Anyone with the link can access the send
+func (h *APIHandler) HandleSendAccess(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte(http.StatusText(http.StatusMethodNotAllowed)))
+		return
+	}
+
+	accessID := strings.TrimPrefix(req.URL.Path, "/api/sends/access/")
+
+	decoder := json.NewDecoder(req.Body)
+	var reqData struct {
+		Password string `json:"password"`
+	}
+	err := decoder.Decode(&reqData)
+	if err != nil && err != io.EOF {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(http.StatusText(http.StatusBadRequest)))
+		log.Println(err)
+		return
+	}
+	defer req.Body.Close()
+
+	send, creator, err := h.db.GetSendByAccessID(accessID)
+	if err != nil || !sendAvailable(send) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(http.StatusText(http.StatusNotFound)))
+		log.Println("Send " + accessID + " is not available")
+		return
+	}
+
+	if !checkSendAccess(w, send, reqData.Password) {
+		return
+	}
+
+	// File sends are counted when the file is downloaded
+	if send.Type == bw.SendTypeText {
+		_, err = h.db.AccessSend(send.Id)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(http.StatusText(http.StatusNotFound)))
+			log.Println(err)
+			return
+		}
+	}
+
+	writeJSON(w, send.GetSendAccess(creator))
+	log.Println("Send " + send.Id + " accessed")
+}
+
+// Checks the password of protected sends. Writes the error if it's wrong
+func checkSendAccess(w http.ResponseWriter, send bw.Send, password string) bool {
+	if send.Password == nil {
+		return true
+	}
+
+	if password == "" {
+		writeError(w, http.StatusUnauthorized, "Password is required.")
+		return false
+	}
+
+	if !checkSendPassword(*send.Password, password) {
+		writeError(w, http.StatusBadRequest, "Invalid password.")
+		log.Println("Wrong password for send " + send.Id)
+		return false
+	}
+
+	return true
+}
+
+// Disabled, expired, deleted and used up sends can't be accessed
+func sendAvailable(send bw.Send) bool {
+	now := time.Now()
+
+	switch {
+	case send.Disabled:
+		return false
+	case send.MaxAccessCount != nil && send.AccessCount >= *send.MaxAccessCount:
+		return false
+	case send.ExpirationDate != nil && now.After(*send.ExpirationDate):
+		return false
+	case now.After(send.DeletionDate):
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,181 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/VictorNine/bitwarden-go/internal/auth"
+	bw "github.com/VictorNine/bitwarden-go/internal/common"
+)
+
+// A cipher re-encrypted by the client with a new key. Sent when sharing or rotating keys
+type reencryptedCipher struct {
+	newCipher
+	Id string `json:"id"`
+
+	// The attachment keys re-encrypted with the new key
+	Attachments2 map[string]struct {
+		FileName string `json:"fileName"`
+		Key      string `json:"key"`
+	} `json:"attachments2"`
+}
+
+// Makes the cipher the client sent ready to replace old. Every attachment with a key
+// has to be re-encrypted, or it can't be opened with the new key
+func (rciph *reencryptedCipher) toCipher(old bw.Cipher) (bw.Cipher, error) {
+	ciph, err := rciph.newCipher.toCipher()
+	if err != nil {
+		return ciph, err
+	}
+
+	ciph.Id = old.Id
+	for _, att := range old.Attachments {
+		a, ok := rciph.Attachments2[att.Id]
+		if att.Key != nil && (!ok || a.Key == "") {
+			return ciph, errors.New("Attachment " + att.Id + " of cipher " + old.Id + " is missing the new key")
+		}
+		if ok {
+			key := a.Key
+			att.FileName, att.Key = a.FileName, &key
+		}
+		ciph.Attachments = append(ciph.Attachments, att)
+	}
+
+	return ciph, nil
+}
+
+// Shared ciphers are encrypted with the organization key
+func toSharedCipher(rciph reencryptedCipher, old bw.Cipher) (bw.Cipher, error) {
+	ciph, err := rciph.toCipher(old)
+	if err == nil && ciph.OrganizationId == nil {
+		err = errors.New("Cipher " + old.Id + " is missing the organization")
+	}
+
+	return ciph, err
+}
+
+// Handles /api/ciphers/{id}/share
+func (h *APIHandler) shareCipher(w http.ResponseWriter, req *http.Request, acc bw.Account, ciphID string) {
+	decoder := json.NewDecoder(req.Body)
+	var reqData struct {
+		Cipher        reencryptedCipher `json:"cipher"`
+		CollectionIds []string          `json:"collectionIds"`
+	}
+	err := decoder.Decode(&reqData)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid share request")
+		log.Println(err)
+		return
+	}
+	defer req.Body.Close()
+
+	old, err := h.db.GetCipher(acc.Id, ciphID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(http.StatusText(http.StatusNotFound)))
+		log.Println(err)
+		return
+	}
+
+	ciph, err := toSharedCipher(reqData.Cipher, old)
+	if err == nil {
+		err = h.canAddToCollections(acc, *ciph.OrganizationId, reqData.CollectionIds)
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		log.Println(err)
+		return
+	}
+
+	err = h.db.ShareCiphers(acc.Id, *ciph.OrganizationId, []bw.Cipher{ciph}, reqData.CollectionIds)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "The cipher could not be shared")
+		log.Println(err)
+		return
+	}
+
+	ciph, err = h.db.GetCipher(acc.Id, ciphID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+		log.Println(err)
+		return
+	}
+	h.setAttachmentURLs(req, []bw.Cipher{ciph})
+
+	writeJSON(w, ciph)
+	log.Println("Cipher " + ciphID + " shared with organization " + *ciph.OrganizationId)
+}
+
+// Handles /api/ciphers/share. All the ciphers are shared with the same organization and collections
+func (h *APIHandler) HandleCiphersShare(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "PUT" && req.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte(http.StatusText(http.StatusMethodNotAllowed)))
+		return
+	}
+
+	email := auth.GetEmail(req)
+	acc, err := h.db.GetAccount(email, "")
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(http.StatusText(http.StatusUnauthorized)))
+		log.Println(err)
+		return
+	}
+
+	decoder := json.NewDecoder(req.Body)
+	var reqData struct {
+		Ciphers       []reencryptedCipher `json:"ciphers"`
+		CollectionIds []string            `json:"collectionIds"`
+	}
+	err = decoder.Decode(&reqData)
+	if err != nil || len(reqData.Ciphers) == 0 {
+		writeError(w, http.StatusBadRequest, "Invalid share request")
+		log.Println(err)
+		return
+	}
+	defer req.Body.Close()
+
+	var orgID string
+	ciphers := make([]bw.Cipher, len(reqData.Ciphers))
+	for i, rciph := range reqData.Ciphers {
+		old, err := h.db.GetCipher(acc.Id, rciph.Id)
+		if err != nil {
+			writeError(w, http.StatusNotFound, "Cipher "+rciph.Id+" not found")
+			log.Println(err)
+			return
+		}
+
+		ciphers[i], err = toSharedCipher(rciph, old)
+		if err == nil && orgID != "" && *ciphers[i].OrganizationId != orgID {
+			err = errors.New("All ciphers have to be shared with the same organization")
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			log.Println(err)
+			return
+		}
+		orgID = *ciphers[i].OrganizationId
+	}
+
+	err = h.canAddToCollections(acc, orgID, reqData.CollectionIds)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		log.Println(err)
+		return
+	}
+
+	err = h.db.ShareCiphers(acc.Id, orgID, ciphers, reqData.CollectionIds)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "The ciphers could not be shared")
+		log.Println(err)
+		return
+	}
+
+	w.Write([]byte(""))
+	log.Println(email + " shared " + strconv.Itoa(len(ciphers)) + " ciphers with organization " + orgID)
+}
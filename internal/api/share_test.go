@@ -0,0 +1,123 @@
+package api
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	bw "github.com/VictorNine/bitwarden-go/internal/common"
+)
+
+// Like the database, only the owner can share a cipher and only once
+func (db *orgDB) ShareCiphers(owner string, orgID string, ciphers []bw.Cipher, collectionIDs []string) error {
+	for _, ciph := range ciphers {
+		if old, ok := db.ciphers[cipherKey(owner, ciph.Id)]; !ok || old.OrganizationId != nil {
+			return errors.New("Cipher " + ciph.Id + " not found")
+		}
+	}
+	for _, colID := range collectionIDs {
+		if _, err := db.GetCollection(orgID, colID); err != nil {
+			return err
+		}
+	}
+
+	for _, ciph := range ciphers {
+		ciph.OrganizationId = &orgID
+		ciph.CollectionIds = collectionIDs
+		ciph.Edit = true
+		db.ciphers[cipherKey(owner, ciph.Id)] = ciph
+		for _, att := range ciph.Attachments {
+			db.attachments[att.Id] = att
+		}
+	}
+
+	return nil
+}
+
+func TestShareCipher(t *testing.T) {
+	db := newOrgDB()
+	h := New(db, mockBlobs{}, nil, 0)
+
+	share := func(email string, id string, body string) int {
+		res := httptest.NewRecorder()
+		h.HandleCipherUpdate(res, userRequest("PUT", "/api/ciphers/"+id+"/share", email, body))
+		return res.Code
+	}
+
+	for _, tc := range []struct {
+		email  string
+		id     string
+		body   string
+		status int
+	}{
+		{"nobody@example.com", "30", `{"cipher":{"type":1,"organizationId":"o1"},"collectionIds":["c1"]}`, 404},
+		{"nobody@example.com", "10", `{"cipher":{"type":1},"collectionIds":["c1"]}`, 400},
+		{"nobody@example.com", "10", `{"cipher":{"type":1,"organizationId":"o2"},"collectionIds":["c1"]}`, 400},
+		{"nobody@example.com", "10", `{"cipher":{"type":1,"organizationId":"o1"},"collectionIds":[]}`, 400},
+		{"nobody@example.com", "10", `{"cipher":{"type":1,"organizationId":"o1"},"collectionIds":["c2"]}`, 400}, // Read only
+		{"nobody@example.com", "10", `{"cipher":{"type":1,"organizationId":"o1"},"collectionIds":["c3"]}`, 400},
+		{"other@example.com", "20", `{"cipher":{"type":1,"organizationId":"o1"},"collectionIds":["c1"]}`, 400}, // Already shared
+	} {
+		if code := share(tc.email, tc.id, tc.body); code != tc.status {
+			t.Errorf("%s sharing %s with %s: expected %v got %v", tc.email, tc.id, tc.body, tc.status, code)
+		}
+	}
+
+	// Attachment att1 has its own key, so it has to be re-encrypted with the organization key
+	attKey := "2.oldkey"
+	db.ciphers[cipherKey("1", "10")].Attachments[0].Key = &attKey
+	if code := share("nobody@example.com", "10", `{"cipher":{"type":1,"organizationId":"o1"},"collectionIds":["c1"]}`); code != 400 {
+		t.Errorf("Without the attachment key: expected 400 got %v", code)
+	}
+	if db.ciphers[cipherKey("1", "10")].OrganizationId != nil {
+		t.Fatal("Cipher 10 shared by a failed request")
+	}
+
+	body := `{"cipher":{"type":1,"organizationId":"o1","attachments2":{"att1":{"fileName":"2.name","key":"2.key"}}},"collectionIds":["c1"]}`
+	if code := share("nobody@example.com", "10", body); code != 200 {
+		t.Fatalf("Expected 200 got %v", code)
+	}
+
+	ciph := db.ciphers[cipherKey("1", "10")]
+	if ciph.OrganizationId == nil || *ciph.OrganizationId != "o1" || len(ciph.CollectionIds) != 1 || ciph.CollectionIds[0] != "c1" {
+		t.Fatal("Cipher 10 not shared with collection c1")
+	}
+	if att := db.attachments["att1"]; att.FileName != "2.name" || att.Key == nil || *att.Key != "2.key" {
+		t.Fatal("Attachment not re-encrypted")
+	}
+}
+
+func TestShareCiphers(t *testing.T) {
+	db := newOrgDB()
+	h := New(db, mockBlobs{}, nil, 0)
+
+	share := func(email string, body string) int {
+		res := httptest.NewRecorder()
+		h.HandleCiphersShare(res, userRequest("PUT", "/api/ciphers/share", email, body))
+		return res.Code
+	}
+
+	// The other user is an admin, but cipher 20 is already shared
+	if code := share("other@example.com", `{"ciphers":[{"id":"30","organizationId":"o1"},{"id":"20","organizationId":"o1"}],"collectionIds":["c2"]}`); code != 400 {
+		t.Fatalf("Expected 400 got %v", code)
+	}
+	if code := share("other@example.com", `{"ciphers":[{"id":"30","organizationId":"o1"},{"id":"10","organizationId":"o1"}],"collectionIds":["c2"]}`); code != 404 {
+		t.Fatalf("Sharing another user's cipher: expected 404 got %v", code)
+	}
+	if code := share("other@example.com", `{"ciphers":[{"id":"30","organizationId":"o1"},{"id":"20","organizationId":"o2"}],"collectionIds":["c2"]}`); code != 400 {
+		t.Fatalf("Sharing with two organizations: expected 400 got %v", code)
+	}
+	if code := share("other@example.com", `{"ciphers":[],"collectionIds":["c2"]}`); code != 400 {
+		t.Fatalf("Without ciphers: expected 400 got %v", code)
+	}
+	if db.ciphers[cipherKey("2", "30")].OrganizationId != nil {
+		t.Fatal("Cipher 30 shared by a failed request")
+	}
+
+	if code := share("other@example.com", `{"ciphers":[{"id":"30","organizationId":"o1"}],"collectionIds":["c2"]}`); code != 200 {
+		t.Fatalf("Expected 200 got %v", code)
+	}
+	if ciph := db.ciphers[cipherKey("2", "30")]; ciph.OrganizationId == nil || *ciph.OrganizationId != "o1" {
+		t.Fatal("Cipher 30 not shared")
+	}
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	bw "github.com/VictorNine/bitwarden-go/internal/common"
+)
+
+func (h *APIHandler) trashCiphers(w http.ResponseWriter, acc bw.Account, ids []string) {
+	err := h.db.SoftDeleteCiphers(acc.Id, ids)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(http.StatusText(http.StatusBadRequest)))
+		log.Println(err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(""))
+	log.Println("Ciphers " + strings.Join(ids, ", ") + " moved to trash")
+}
+
+// Restored ciphers are sent back to the client. As a list if it's a bulk restore
+func (h *APIHandler) restoreCiphers(w http.ResponseWriter, req *http.Request, acc bw.Account, ids []string, bulk bool) {
+	err := h.db.RestoreCiphers(acc.Id, ids)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(http.StatusText(http.StatusBadRequest)))
+		log.Println(err)
+		return
+	}
+
+	ciphs := make([]bw.Cipher, 0, len(ids))
+	for _, id := range ids {
+		ciph, err := h.db.GetCipher(acc.Id, id)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+			log.Println(err)
+			return
+		}
+		ciphs = append(ciphs, ciph)
+	}
+	h.setAttachmentURLs(req, ciphs)
+
+	var data []byte
+	if bulk {
+		data, err = json.Marshal(bw.Data{Object: "list", Data: ciphs})
+	} else {
+		data, err = json.Marshal(&ciphs[0])
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+		log.Println(err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+	log.Println("Ciphers " + strings.Join(ids, ", ") + " restored")
+}
+
+// PurgeTrash permanently deletes ciphers that have been in the trash for more than the given number of days
+func (h *APIHandler) PurgeTrash(days int) {
+	atts, err := h.db.PurgeDeletedCiphers(time.Now().AddDate(0, 0, -days))
+	if err != nil {
+		log.Println("Purging trash: " + err.Error())
+		return
+	}
+
+	h.deleteAttachmentData(atts)
+}
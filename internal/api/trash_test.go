@@ -0,0 +1,111 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	bw "github.com/VictorNine/bitwarden-go/internal/common"
+)
+
+func (db *mockDB) SoftDeleteCiphers(owner string, ciphIDs []string) error {
+	if err := db.canEdit(owner, ciphIDs); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	db.updateCiphers(ciphIDs, func(ciph *bw.Cipher) { ciph.DeletedDate = &now })
+	return nil
+}
+
+func (db *mockDB) RestoreCiphers(owner string, ciphIDs []string) error {
+	if err := db.canEdit(owner, ciphIDs); err != nil {
+		return err
+	}
+
+	db.updateCiphers(ciphIDs, func(ciph *bw.Cipher) { ciph.DeletedDate = nil })
+	return nil
+}
+
+func TestTrashCipher(t *testing.T) {
+	db := newCipherDB()
+	h := New(db, mockBlobs{}, nil, 0)
+
+	for _, tc := range []struct {
+		path   string
+		status int
+	}{
+		{"/api/ciphers/10/delete", 200},
+		{"/api/ciphers/20/delete", 400}, // Read only
+		{"/api/ciphers/30/delete", 400}, // Another user's
+	} {
+		res := httptest.NewRecorder()
+		h.HandleCipherUpdate(res, userRequest("PUT", tc.path, "nobody@example.com", ""))
+		if res.Code != tc.status {
+			t.Errorf("%s: expected %v got %v", tc.path, tc.status, res.Code)
+		}
+	}
+
+	if db.ciphers[cipherKey("1", "10")].DeletedDate == nil {
+		t.Error("Cipher 10 not moved to the trash")
+	}
+	for _, key := range []string{cipherKey("2", "20"), cipherKey("2", "30")} {
+		if db.ciphers[key].DeletedDate != nil {
+			t.Errorf("Cipher %s moved to the trash", key)
+		}
+	}
+
+	res := httptest.NewRecorder()
+	h.HandleCipherUpdate(res, userRequest("PUT", "/api/ciphers/10/restore", "nobody@example.com", ""))
+	var ciph bw.Cipher
+	if res.Code != 200 || json.Unmarshal(res.Body.Bytes(), &ciph) != nil || ciph.Id != "10" {
+		t.Fatalf("Restore: expected the cipher got %v %s", res.Code, res.Body)
+	}
+	if ciph.DeletedDate != nil || db.ciphers[cipherKey("1", "10")].DeletedDate != nil {
+		t.Error("Cipher 10 still in the trash")
+	}
+}
+
+func TestTrashCiphers(t *testing.T) {
+	db := newCipherDB()
+	h := New(db, mockBlobs{}, nil, 0)
+
+	trash := func(method string, body string) int {
+		res := httptest.NewRecorder()
+		h.HandleCiphersDelete(res, userRequest(method, "/api/ciphers/delete", "nobody@example.com", body))
+		return res.Code
+	}
+
+	// Nothing is moved if one of the ciphers can't be edited
+	if code := trash("PUT", `{"ids":["10","20"]}`); code != 400 {
+		t.Fatalf("Expected 400 got %v", code)
+	}
+	if db.ciphers[cipherKey("1", "10")].DeletedDate != nil {
+		t.Fatal("Cipher 10 moved to the trash by a failed request")
+	}
+
+	if code := trash("PUT", `{"ids":[]}`); code != 400 {
+		t.Errorf("Without ids: expected 400 got %v", code)
+	}
+
+	if code := trash("PUT", `{"ids":["10"]}`); code != 200 {
+		t.Fatalf("Expected 200 got %v", code)
+	}
+	if db.ciphers[cipherKey("1", "10")].DeletedDate == nil {
+		t.Fatal("Cipher 10 not moved to the trash")
+	}
+
+	res := httptest.NewRecorder()
+	h.HandleCiphersRestore(res, userRequest("PUT", "/api/ciphers/restore", "nobody@example.com", `{"ids":["10"]}`))
+	var list struct {
+		Object string
+		Data   []bw.Cipher
+	}
+	if res.Code != 200 || json.Unmarshal(res.Body.Bytes(), &list) != nil || list.Object != "list" || len(list.Data) != 1 {
+		t.Fatalf("Restore: expected a list with the cipher got %v %s", res.Code, res.Body)
+	}
+	if db.ciphers[cipherKey("1", "10")].DeletedDate != nil {
+		t.Error("Cipher 10 still in the trash")
+	}
+}
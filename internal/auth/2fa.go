@@ -130,7 +130,7 @@ func (auth *Auth) VerifyAuthenticatorSecret(w http.ResponseWriter, req *http.Req
 	}
 	defer req.Body.Close()
 
-	_, err = checkPassword(auth.db, email, reqData.MasterPasswordHash)
+	acc, err := checkPassword(auth.db, email, reqData.MasterPasswordHash)
 	if err != nil {
 		w.WriteHeader(http.StatusUnauthorized)
 		w.Write([]byte(http.StatusText(401)))
@@ -153,6 +153,9 @@ func (auth *Auth) VerifyAuthenticatorSecret(w http.ResponseWriter, req *http.Req
 	}
 
 	err = auth.db.Update2FAsecret(reqData.Key, email)
+	if err == nil {
+		err = auth.rotateSecurityStamp(acc.Id)
+	}
 	if err != nil || !authenticated {
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte(http.StatusText(500)))
@@ -232,7 +235,7 @@ func (auth *Auth) HandleDisableTwoFactor(w http.ResponseWriter, req *http.Reques
 	}
 	defer req.Body.Close()
 
-	_, err = checkPassword(auth.db, email, reqData.MasterPasswordHash)
+	acc, err := checkPassword(auth.db, email, reqData.MasterPasswordHash)
 	if err != nil {
 		w.WriteHeader(http.StatusUnauthorized)
 		w.Write([]byte(http.StatusText(401)))
@@ -241,6 +244,9 @@ func (auth *Auth) HandleDisableTwoFactor(w http.ResponseWriter, req *http.Reques
 	}
 
 	err = auth.db.Update2FAsecret("", email)
+	if err == nil {
+		err = auth.rotateSecurityStamp(acc.Id)
+	}
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte(http.StatusText(500)))
@@ -0,0 +1,531 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	bw "github.com/VictorNine/bitwarden-go/internal/common"
+)
+
+// Handles /api/accounts/password. The client sends the new password hash and the
+// symmetric key encrypted with the new master key
+func (auth *Auth) HandlePasswordChange(w http.ResponseWriter, req *http.Request) {
+	email := GetEmail(req)
+
+	if req.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte(http.StatusText(http.StatusMethodNotAllowed)))
+		return
+	}
+
+	log.Println(email + " is trying to change the master password")
+
+	decoder := json.NewDecoder(req.Body)
+	var reqData struct {
+		MasterPasswordHash    string `json:"masterPasswordHash"`
+		NewMasterPasswordHash string `json:"newMasterPasswordHash"`
+		Key                   string `json:"key"`
+	}
+	err := decoder.Decode(&reqData)
+	if err != nil || reqData.NewMasterPasswordHash == "" || reqData.Key == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(http.StatusText(http.StatusBadRequest)))
+		log.Println(err)
+		return
+	}
+	defer req.Body.Close()
+
+	acc, err := checkPassword(auth.db, email, reqData.MasterPasswordHash)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(http.StatusText(http.StatusBadRequest)))
+		log.Println(err)
+		return
+	}
+
+	acc.MasterPasswordHash, err = hashPassword(acc, reqData.NewMasterPasswordHash)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(http.StatusText(http.StatusBadRequest)))
+		log.Println(err)
+		return
+	}
+	acc.Key = reqData.Key
+
+	err = auth.updateMasterPassword(acc)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+		log.Println(err)
+		return
+	}
+
+	w.Write([]byte(""))
+	log.Println(email + " changed the master password")
+}
+
+// Stores the new password and logs out all clients
+func (auth *Auth) updateMasterPassword(acc bw.Account) error {
+	stamp, err := NewSecurityStamp()
+	if err != nil {
+		return err
+	}
+
+	acc.SecurityStamp = stamp
+	acc.RefreshToken = ""
+	return auth.db.UpdateMasterPassword(acc)
+}
+
+// Logs out all clients. JwtMiddleware rejects access tokens with the old stamp and the refresh token is removed
+func (auth *Auth) rotateSecurityStamp(userID string) error {
+	stamp, err := NewSecurityStamp()
+	if err != nil {
+		return err
+	}
+
+	return auth.db.UpdateSecurityStamp(userID, stamp)
+}
+
+// Handles /api/accounts/security-stamp. Logs out all sessions
+func (auth *Auth) HandleSecurityStamp(w http.ResponseWriter, req *http.Request) {
+	email := GetEmail(req)
+
+	if req.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte(http.StatusText(http.StatusMethodNotAllowed)))
+		return
+	}
+
+	decoder := json.NewDecoder(req.Body)
+	var reqData struct {
+		MasterPasswordHash string `json:"masterPasswordHash"`
+	}
+	err := decoder.Decode(&reqData)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(http.StatusText(http.StatusBadRequest)))
+		log.Println(err)
+		return
+	}
+	defer req.Body.Close()
+
+	acc, err := checkPassword(auth.db, email, reqData.MasterPasswordHash)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(http.StatusText(http.StatusBadRequest)))
+		log.Println(err)
+		return
+	}
+
+	err = auth.rotateSecurityStamp(acc.Id)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+		log.Println(err)
+		return
+	}
+
+	w.Write([]byte(""))
+	log.Println(email + " logged out all sessions")
+}
+
+// NewSecurityStamp makes a new security stamp for the account. Storing it logs out all clients
+func NewSecurityStamp() (string, error) {
+	return newToken()
+}
+
+// Random token that has to be sent back by the user
+func newToken() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Handles /api/accounts/email-token. Mails a token to the new address to make sure the user owns it
+func (auth *Auth) HandleEmailToken(w http.ResponseWriter, req *http.Request) {
+	email := GetEmail(req)
+
+	if req.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte(http.StatusText(http.StatusMethodNotAllowed)))
+		return
+	}
+
+	decoder := json.NewDecoder(req.Body)
+	var reqData struct {
+		NewEmail           string `json:"newEmail"`
+		MasterPasswordHash string `json:"masterPasswordHash"`
+	}
+	err := decoder.Decode(&reqData)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(http.StatusText(http.StatusBadRequest)))
+		log.Println(err)
+		return
+	}
+	defer req.Body.Close()
+
+	acc, err := checkPassword(auth.db, email, reqData.MasterPasswordHash)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(http.StatusText(http.StatusBadRequest)))
+		log.Println(err)
+		return
+	}
+
+	newEmail := strings.TrimSpace(reqData.NewEmail)
+	if !strings.Contains(newEmail, "@") || strings.EqualFold(newEmail, acc.Email) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(http.StatusText(http.StatusBadRequest)))
+		log.Println("Invalid new email " + newEmail)
+		return
+	}
+
+	if _, err := auth.db.GetAccount(newEmail, ""); err == nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(http.StatusText(http.StatusBadRequest)))
+		log.Println(newEmail + " is already taken")
+		return
+	}
+
+	token, err := newToken()
+	if err == nil {
+		err = auth.db.SetToken(acc.Id, "email", token, newEmail, time.Now().Add(time.Hour))
+	}
+	if err == nil {
+		err = auth.mail.Send(newEmail, "Your email change", "Use this token to change your email address:\n\n"+token+"\n\nThe token expires in one hour.")
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+		log.Println(err)
+		return
+	}
+
+	w.Write([]byte(""))
+	log.Println(email + " requested to change email to " + newEmail)
+}
+
+// Handles /api/accounts/email. The email is the salt of the password hash, so the client sends
+// a new hash and the key encrypted with the new master key
+func (auth *Auth) HandleEmailChange(w http.ResponseWriter, req *http.Request) {
+	email := GetEmail(req)
+
+	if req.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte(http.StatusText(http.StatusMethodNotAllowed)))
+		return
+	}
+
+	decoder := json.NewDecoder(req.Body)
+	var reqData struct {
+		NewEmail              string `json:"newEmail"`
+		MasterPasswordHash    string `json:"masterPasswordHash"`
+		NewMasterPasswordHash string `json:"newMasterPasswordHash"`
+		Token                 string `json:"token"`
+		Key                   string `json:"key"`
+	}
+	err := decoder.Decode(&reqData)
+	if err != nil || reqData.NewMasterPasswordHash == "" || reqData.Key == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(http.StatusText(http.StatusBadRequest)))
+		log.Println(err)
+		return
+	}
+	defer req.Body.Close()
+
+	acc, err := checkPassword(auth.db, email, reqData.MasterPasswordHash)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(http.StatusText(http.StatusBadRequest)))
+		log.Println(err)
+		return
+	}
+
+	newEmail, err := auth.db.UseToken(acc.Id, "email", reqData.Token)
+	if err != nil || newEmail != strings.TrimSpace(reqData.NewEmail) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(http.StatusText(http.StatusBadRequest)))
+		log.Println("Invalid email change token for " + email)
+		return
+	}
+
+	acc.Email = newEmail
+	acc.MasterPasswordHash, err = hashPassword(acc, reqData.NewMasterPasswordHash)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(http.StatusText(http.StatusBadRequest)))
+		log.Println(err)
+		return
+	}
+	acc.Key = reqData.Key
+
+	// Log out all clients
+	acc.SecurityStamp, err = NewSecurityStamp()
+	if err == nil {
+		acc.RefreshToken = ""
+		err = auth.db.UpdateEmail(acc)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+		log.Println(err)
+		return
+	}
+
+	w.Write([]byte(""))
+	log.Println(email + " changed email to " + newEmail)
+}
+
+// Mails a link to the web vault which sends the token to /api/accounts/verify-email-token
+func (auth *Auth) sendVerifyEmail(req *http.Request, acc bw.Account) error {
+	token, err := newToken()
+	if err != nil {
+		return err
+	}
+
+	// The email is stored with the token so it can't verify an address the account has changed from
+	err = auth.db.SetToken(acc.Id, "verify-email", token, acc.Email, time.Now().Add(24*time.Hour))
+	if err != nil {
+		return err
+	}
+
+	params := url.Values{}
+	params.Set("userId", acc.Id)
+	params.Set("token", token)
+
+	return auth.mail.Send(acc.Email, "Verify your email", "Open this link to verify your email address:\n"+
+		bw.LinkURL(auth.publicURL, req)+"/#/verify-email?"+params.Encode()+"\n\nThe link expires in 24 hours.")
+}
+
+// Handles /api/accounts/verify-email. Sends a new verification mail
+func (auth *Auth) HandleVerifyEmail(w http.ResponseWriter, req *http.Request) {
+	email := GetEmail(req)
+
+	if req.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte(http.StatusText(http.StatusMethodNotAllowed)))
+		return
+	}
+
+	acc, err := auth.db.GetAccount(email, "")
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+		log.Println(err)
+		return
+	}
+
+	if acc.EmailVerified {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(http.StatusText(http.StatusBadRequest)))
+		log.Println(email + " is already verified")
+		return
+	}
+
+	err = auth.sendVerifyEmail(req, acc)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+		log.Println(err)
+		return
+	}
+
+	w.Write([]byte(""))
+	log.Println("Verification mail sent to " + email)
+}
+
+// Handles /api/accounts/verify-email-token. The user may not be able to log in yet, so the token is
+// the only authentication
+func (auth *Auth) HandleVerifyEmailToken(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte(http.StatusText(http.StatusMethodNotAllowed)))
+		return
+	}
+
+	decoder := json.NewDecoder(req.Body)
+	var reqData struct {
+		UserId string `json:"userId"`
+		Token  string `json:"token"`
+	}
+	err := decoder.Decode(&reqData)
+	if err != nil || reqData.UserId == "" || reqData.Token == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(http.StatusText(http.StatusBadRequest)))
+		log.Println(err)
+		return
+	}
+	defer req.Body.Close()
+
+	email, err := auth.db.UseToken(reqData.UserId, "verify-email", reqData.Token)
+	if err == nil {
+		err = auth.db.VerifyEmail(reqData.UserId, email)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(http.StatusText(http.StatusBadRequest)))
+		log.Println(err)
+		return
+	}
+
+	w.Write([]byte(""))
+	log.Println(email + " verified the email")
+}
+
+// Handles /api/accounts/kdf. The client derives a new master key with the new settings and
+// sends the new password hash and the key encrypted with the new master key
+func (auth *Auth) HandleKdfChange(w http.ResponseWriter, req *http.Request) {
+	email := GetEmail(req)
+
+	if req.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte(http.StatusText(http.StatusMethodNotAllowed)))
+		return
+	}
+
+	decoder := json.NewDecoder(req.Body)
+	var reqData struct {
+		Kdf                   int    `json:"kdf"`
+		KdfIterations         int    `json:"kdfIterations"`
+		KdfMemory             *int   `json:"kdfMemory"`
+		KdfParallelism        *int   `json:"kdfParallelism"`
+		MasterPasswordHash    string `json:"masterPasswordHash"`
+		NewMasterPasswordHash string `json:"newMasterPasswordHash"`
+		Key                   string `json:"key"`
+	}
+	err := decoder.Decode(&reqData)
+	if err != nil || reqData.NewMasterPasswordHash == "" || reqData.Key == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(http.StatusText(http.StatusBadRequest)))
+		log.Println(err)
+		return
+	}
+	defer req.Body.Close()
+
+	acc, err := checkPassword(auth.db, email, reqData.MasterPasswordHash)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(http.StatusText(http.StatusBadRequest)))
+		log.Println(err)
+		return
+	}
+
+	acc.Kdf = reqData.Kdf
+	acc.KdfIterations = reqData.KdfIterations
+	acc.KdfMemory = reqData.KdfMemory
+	acc.KdfParallelism = reqData.KdfParallelism
+	if acc.Kdf == bw.KdfPBKDF2 {
+		acc.KdfMemory, acc.KdfParallelism = nil, nil
+	}
+
+	if !auth.validKdf(acc) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(http.StatusText(http.StatusBadRequest)))
+		log.Println("Unsupported KDF settings")
+		return
+	}
+
+	acc.MasterPasswordHash, err = hashPassword(acc, reqData.NewMasterPasswordHash)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(http.StatusText(http.StatusBadRequest)))
+		log.Println(err)
+		return
+	}
+	acc.Key = reqData.Key
+
+	err = auth.updateMasterPassword(acc)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+		log.Println(err)
+		return
+	}
+
+	w.Write([]byte(""))
+	log.Println(email + " changed the KDF settings")
+}
+
+// Handles /api/accounts/password-hint. The response is the same whether the account exists or not
+func (auth *Auth) HandlePasswordHint(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte(http.StatusText(http.StatusMethodNotAllowed)))
+		return
+	}
+
+	decoder := json.NewDecoder(req.Body)
+	var reqData struct {
+		Email string `json:"email"`
+	}
+	err := decoder.Decode(&reqData)
+	if err != nil || reqData.Email == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(http.StatusText(http.StatusBadRequest)))
+		log.Println(err)
+		return
+	}
+	defer req.Body.Close()
+
+	ip := auth.clientIP(req)
+	email := strings.ToLower(strings.TrimSpace(reqData.Email))
+	if !auth.hintsPerIP.allow(ip) || !auth.hintsPerEmail.allow(email) {
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(http.StatusText(http.StatusTooManyRequests)))
+		log.Println("Too many password hint requests from " + ip)
+		return
+	}
+
+	acc, err := auth.db.GetAccount(strings.TrimSpace(reqData.Email), "")
+	if err != nil {
+		// Don't tell the client that the account doesn't exist
+		w.Write([]byte(""))
+		log.Println("Password hint requested for unknown account " + reqData.Email)
+		return
+	}
+
+	body := "You have not set a master password hint."
+	if acc.MasterPasswordHint != "" {
+		body = "Your master password hint is:\n\n" + acc.MasterPasswordHint
+	}
+
+	// A failed mail can't get another response than unknown accounts either
+	err = auth.mail.Send(acc.Email, "Your master password hint", body)
+	w.Write([]byte(""))
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	log.Println("Password hint sent to " + acc.Email)
+}
+
+// Behind a reverse proxy all requests come from the proxy, so the address of the client is in a header.
+// The last address is the one the proxy added, the others are sent by the client and can't be trusted
+func (auth *Auth) clientIP(req *http.Request) string {
+	if auth.clientIPHeader != "" {
+		if header := req.Header.Get(auth.clientIPHeader); header != "" {
+			ips := strings.Split(header, ",")
+			return strings.TrimSpace(ips[len(ips)-1])
+		}
+	}
+
+	ip, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+
+	return ip
+}
@@ -0,0 +1,294 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/VictorNine/bitwarden-go/internal/database/mock"
+)
+
+// Adds the email like JwtMiddleware does
+func withEmail(req *http.Request, email string) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), ctxKey("email"), email))
+}
+
+func TestHandlePasswordChange(t *testing.T) {
+	keyHash, _ := reHashPassword("sjlcxv1TSe1wTHoYF50WJL3X07oCFxqhXYFeGfrbtII=", "nobody@example.com", 5000)
+
+	cases := []struct {
+		body     string
+		expected int
+	}{{`{"masterPasswordHash": "sjlcxv1TSe1wTHoYF50WJL3X07oCFxqhXYFeGfrbtII=", "newMasterPasswordHash": "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=", "key": "2.newkey"}`, 200},
+		{`{"masterPasswordHash": "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=", "newMasterPasswordHash": "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=", "key": "2.newkey"}`, 400},
+		{`{"masterPasswordHash": "sjlcxv1TSe1wTHoYF50WJL3X07oCFxqhXYFeGfrbtII=", "newMasterPasswordHash": "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="}`, 400},
+	}
+
+	for _, c := range cases {
+		db := &mock.MockDB{Username: "nobody@example.com", Password: keyHash, RefreshToken: "abcdef", KdfIterations: 5000}
+		authHandler := New(db, nil, "", 3600)
+
+		req := withEmail(httptest.NewRequest("POST", "/api/accounts/password", strings.NewReader(c.body)), "nobody@example.com")
+		res := httptest.NewRecorder()
+
+		authHandler.HandlePasswordChange(res, req)
+		if res.Code != c.expected {
+			t.Errorf("Expected %v got %v", c.expected, res.Code)
+		}
+
+		changed := db.Password != keyHash
+		if changed != (c.expected == 200) {
+			t.Errorf("Password changed: %v", changed)
+		}
+		if changed && db.RefreshToken != "" {
+			t.Error("Refresh token not removed")
+		}
+	}
+}
+
+type testMailer struct {
+	to   string
+	body string
+	fail bool
+}
+
+func (m *testMailer) Send(to string, subject string, body string) error {
+	if m.fail {
+		return errors.New("mail server down")
+	}
+	m.to, m.body = to, body
+	return nil
+}
+
+func TestHandleEmailChange(t *testing.T) {
+	keyHash, _ := reHashPassword("sjlcxv1TSe1wTHoYF50WJL3X07oCFxqhXYFeGfrbtII=", "nobody@example.com", 5000)
+	db := &mock.MockDB{Username: "nobody@example.com", Password: keyHash, RefreshToken: "abcdef", KdfIterations: 5000}
+	mailer := &testMailer{}
+	authHandler := New(db, mailer, "", 3600)
+
+	body := `{"newEmail": "new@example.com", "masterPasswordHash": "sjlcxv1TSe1wTHoYF50WJL3X07oCFxqhXYFeGfrbtII="}`
+	req := withEmail(httptest.NewRequest("POST", "/api/accounts/email-token", strings.NewReader(body)), "nobody@example.com")
+	res := httptest.NewRecorder()
+	authHandler.HandleEmailToken(res, req)
+	if res.Code != 200 {
+		t.Fatalf("Expected 200 got %v", res.Code)
+	}
+	if mailer.to != "new@example.com" || !strings.Contains(mailer.body, db.Token) {
+		t.Fatal("Token not mailed to the new address")
+	}
+
+	change := func(token string) int {
+		body := `{"newEmail": "new@example.com", "masterPasswordHash": "sjlcxv1TSe1wTHoYF50WJL3X07oCFxqhXYFeGfrbtII=", "newMasterPasswordHash": "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=", "key": "2.newkey", "token": "` + token + `"}`
+		req := withEmail(httptest.NewRequest("POST", "/api/accounts/email", strings.NewReader(body)), "nobody@example.com")
+		res := httptest.NewRecorder()
+		authHandler.HandleEmailChange(res, req)
+		return res.Code
+	}
+
+	if code := change("wrong"); code != 400 {
+		t.Fatalf("Expected 400 for a wrong token got %v", code)
+	}
+
+	if code := change(db.Token); code != 200 {
+		t.Fatalf("Expected 200 got %v", code)
+	}
+
+	newHash, _ := reHashPassword("AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=", "new@example.com", 5000)
+	if db.Username != "new@example.com" || db.Password != newHash || db.RefreshToken != "" {
+		t.Fatal("Email not changed")
+	}
+}
+
+func TestHandleKdfChange(t *testing.T) {
+	keyHash, _ := reHashPassword("sjlcxv1TSe1wTHoYF50WJL3X07oCFxqhXYFeGfrbtII=", "nobody@example.com", 5000)
+
+	cases := []struct {
+		kdf      string
+		expected int
+	}{{`"kdf": 0, "kdfIterations": 600000`, 200},
+		{`"kdf": 0, "kdfIterations": 1000`, 400},
+		{`"kdf": 0, "kdfIterations": 3000000`, 400},
+		{`"kdf": 7, "kdfIterations": 600000`, 400},
+		{`"kdf": 1, "kdfIterations": 3, "kdfMemory": 64, "kdfParallelism": 4`, 200},
+		{`"kdf": 1, "kdfIterations": 3, "kdfParallelism": 4`, 400},
+		{`"kdf": 1, "kdfIterations": 3, "kdfMemory": 4096, "kdfParallelism": 4`, 400},
+		{`"kdf": 1, "kdfIterations": 1, "kdfMemory": 64, "kdfParallelism": 4`, 400},
+	}
+
+	for _, c := range cases {
+		db := &mock.MockDB{Username: "nobody@example.com", Password: keyHash, RefreshToken: "abcdef", KdfIterations: 5000}
+		authHandler := New(db, nil, "", 3600)
+
+		body := `{` + c.kdf + `, "masterPasswordHash": "sjlcxv1TSe1wTHoYF50WJL3X07oCFxqhXYFeGfrbtII=", "newMasterPasswordHash": "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=", "key": "2.newkey"}`
+		req := withEmail(httptest.NewRequest("POST", "/api/accounts/kdf", strings.NewReader(body)), "nobody@example.com")
+		res := httptest.NewRecorder()
+
+		authHandler.HandleKdfChange(res, req)
+		if res.Code != c.expected {
+			t.Errorf("Expected %v got %v for %s", c.expected, res.Code, c.kdf)
+		}
+	}
+}
+
+func TestSecurityStamp(t *testing.T) {
+	keyHash, _ := reHashPassword("sjlcxv1TSe1wTHoYF50WJL3X07oCFxqhXYFeGfrbtII=", "nobody@example.com", 5000)
+	db := &mock.MockDB{Username: "nobody@example.com", Password: keyHash, RefreshToken: "abcdef", KdfIterations: 5000, SecurityStamp: "first"}
+	authHandler := New(db, nil, "secret", 3600)
+
+	data := url.Values{"client_id": {"web"}, "grant_type": {"password"}, "username": {"nobody@example.com"}, "password": {"sjlcxv1TSe1wTHoYF50WJL3X07oCFxqhXYFeGfrbtII="}}
+	req := httptest.NewRequest("POST", "/identity/connect/token", strings.NewReader(data.Encode()))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	res := httptest.NewRecorder()
+	authHandler.HandleLogin(res, req)
+
+	var token resToken
+	err := json.Unmarshal(res.Body.Bytes(), &token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	protected := authHandler.JwtMiddleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	call := func() int {
+		req := httptest.NewRequest("GET", "/api/sync", nil)
+		req.Header.Add("Authorization", "Bearer "+token.AccessToken)
+		res := httptest.NewRecorder()
+		protected.ServeHTTP(res, req)
+		return res.Code
+	}
+
+	if code := call(); code != 200 {
+		t.Fatalf("Expected 200 got %v", code)
+	}
+
+	req = withEmail(httptest.NewRequest("POST", "/api/accounts/security-stamp", strings.NewReader(`{"masterPasswordHash": "sjlcxv1TSe1wTHoYF50WJL3X07oCFxqhXYFeGfrbtII="}`)), "nobody@example.com")
+	res = httptest.NewRecorder()
+	authHandler.HandleSecurityStamp(res, req)
+	if res.Code != 200 || db.SecurityStamp == "first" || db.RefreshToken != "" {
+		t.Fatal("Security stamp not changed")
+	}
+
+	if code := call(); code != 401 {
+		t.Fatalf("Expected 401 with the old security stamp got %v", code)
+	}
+}
+
+func TestHandlePasswordHint(t *testing.T) {
+	db := &mock.MockDB{Username: "nobody@example.com"}
+	mailer := &testMailer{}
+	authHandler := New(db, mailer, "", 3600)
+
+	hint := func(email string) int {
+		req := httptest.NewRequest("POST", "/api/accounts/password-hint", strings.NewReader(`{"email": "`+email+`"}`))
+		res := httptest.NewRecorder()
+		authHandler.HandlePasswordHint(res, req)
+		return res.Code
+	}
+
+	if code := hint("nobody@example.com"); code != 200 || mailer.to != "nobody@example.com" {
+		t.Fatalf("Hint not sent, got %v", code)
+	}
+
+	// Unknown accounts get the same response
+	mailer.to = ""
+	if code := hint("unknown@example.com"); code != 200 || mailer.to != "" {
+		t.Fatalf("Expected 200 without a mail got %v", code)
+	}
+
+	if code := hint("nobody@example.com"); code != 429 {
+		t.Fatalf("Expected 429 for the same email got %v", code)
+	}
+
+	for i := 0; i < 5; i++ {
+		hint("other" + strconv.Itoa(i) + "@example.com")
+	}
+	if code := hint("another@example.com"); code != 429 {
+		t.Fatalf("Expected 429 for the same ip got %v", code)
+	}
+}
+
+func TestHandlePasswordHintFailedMail(t *testing.T) {
+	db := &mock.MockDB{Username: "nobody@example.com"}
+	authHandler := New(db, &testMailer{fail: true}, "", 3600)
+
+	req := httptest.NewRequest("POST", "/api/accounts/password-hint", strings.NewReader(`{"email": "nobody@example.com"}`))
+	res := httptest.NewRecorder()
+	authHandler.HandlePasswordHint(res, req)
+	if res.Code != 200 || res.Body.String() != "" {
+		t.Fatalf("Expected the response of unknown accounts got %v %q", res.Code, res.Body.String())
+	}
+}
+
+func TestHandlePasswordHintBehindProxy(t *testing.T) {
+	authHandler := New(&mock.MockDB{}, &testMailer{}, "", 3600)
+	authHandler.SetClientIPHeader("X-Forwarded-For")
+
+	// All requests come from the proxy, but each client gets its own limit
+	hint := func(email string, client string) int {
+		req := httptest.NewRequest("POST", "/api/accounts/password-hint", strings.NewReader(`{"email": "`+email+`"}`))
+		req.Header.Set("X-Forwarded-For", "10.0.0.1, "+client)
+		res := httptest.NewRecorder()
+		authHandler.HandlePasswordHint(res, req)
+		return res.Code
+	}
+
+	for i := 0; i < 5; i++ {
+		hint("other"+strconv.Itoa(i)+"@example.com", "192.0.2.1")
+	}
+	if code := hint("another@example.com", "192.0.2.1"); code != 429 {
+		t.Fatalf("Expected 429 for the same client got %v", code)
+	}
+	if code := hint("another@example.com", "192.0.2.2"); code != 200 {
+		t.Fatalf("Expected 200 for another client got %v", code)
+	}
+}
+
+func TestVerifyEmail(t *testing.T) {
+	keyHash, _ := reHashPassword("sjlcxv1TSe1wTHoYF50WJL3X07oCFxqhXYFeGfrbtII=", "nobody@example.com", 5000)
+	db := &mock.MockDB{Username: "nobody@example.com", Password: keyHash, KdfIterations: 5000}
+	mailer := &testMailer{}
+	authHandler := New(db, mailer, "", 3600)
+	authHandler.SetRequireVerifiedEmail(true)
+	authHandler.SetPublicURL("https://vault.example.com/")
+
+	login := func() int {
+		data := url.Values{"client_id": {"web"}, "grant_type": {"password"}, "username": {"nobody@example.com"}, "password": {"sjlcxv1TSe1wTHoYF50WJL3X07oCFxqhXYFeGfrbtII="}}
+		req := httptest.NewRequest("POST", "/identity/connect/token", strings.NewReader(data.Encode()))
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		res := httptest.NewRecorder()
+		authHandler.HandleLogin(res, req)
+		return res.Code
+	}
+
+	if code := login(); code != 400 {
+		t.Fatalf("Unverified login: expected 400 got %v", code)
+	}
+	if mailer.to != "nobody@example.com" || db.Token == "" || !strings.Contains(mailer.body, db.Token) {
+		t.Fatal("Verification link not mailed")
+	}
+	if !strings.Contains(mailer.body, "https://vault.example.com/#/verify-email?") {
+		t.Fatalf("Link doesn't use the public URL: %s", mailer.body)
+	}
+
+	for _, c := range []struct {
+		token    string
+		expected int
+	}{{"wrong", 400}, {db.Token, 200}, {db.Token, 400}} {
+		body := `{"userId": "1", "token": "` + c.token + `"}`
+		req := httptest.NewRequest("POST", "/api/accounts/verify-email-token", strings.NewReader(body))
+		res := httptest.NewRecorder()
+		authHandler.HandleVerifyEmailToken(res, req)
+		if res.Code != c.expected {
+			t.Errorf("Token %s: expected %v got %v", c.token, c.expected, res.Code)
+		}
+	}
+
+	if code := login(); code != 200 {
+		t.Errorf("Verified login: expected 200 got %v", code)
+	}
+}
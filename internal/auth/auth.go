@@ -4,6 +4,7 @@ import (
 	"context"
 	"crypto/rand"
 	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
@@ -21,27 +22,124 @@ import (
 )
 
 type Auth struct {
-	db         database
-	signingKey []byte
-	jwtExpire  int
+	db            database
+	mail          mailer
+	signingKey    []byte
+	jwtExpire     int
+	minIterations int
+	maxIterations int
+
+	// Unverified accounts can't log in
+	requireVerifiedEmail bool
+
+	// Limits password hint mails so they can't be used to spam or probe for accounts
+	hintsPerIP    *rateLimiter
+	hintsPerEmail *rateLimiter
+
+	// Header with the address of the client when running behind a reverse proxy
+	clientIPHeader string
+
+	// Address of the server used in mailed links
+	publicURL string
+
+	// Limits the verification mails sent on failed logins
+	verifyMailsPerEmail *rateLimiter
 }
 
-func New(db database, signingKey string, jwtExpire int) Auth {
+func New(db database, mail mailer, signingKey string, jwtExpire int) Auth {
 	auth := Auth{
 		db:         db,
+		mail:       mail,
 		signingKey: []byte(signingKey),
 		jwtExpire:  jwtExpire,
+
+		minIterations: 5000,
+		maxIterations: 2000000,
+
+		hintsPerIP:    newRateLimiter(5, time.Hour),
+		hintsPerEmail: newRateLimiter(1, 15*time.Minute),
+
+		verifyMailsPerEmail: newRateLimiter(1, 15*time.Minute),
 	}
 
 	return auth
 }
 
+// SetKdfIterations sets the range of KDF iterations accounts are allowed to use
+func (auth *Auth) SetKdfIterations(min int, max int) {
+	auth.minIterations = min
+	auth.maxIterations = max
+}
+
+// SetClientIPHeader makes the rate limits use the client address from the header set by a reverse proxy
+func (auth *Auth) SetClientIPHeader(header string) {
+	auth.clientIPHeader = header
+}
+
+// SetPublicURL sets the address of the server used in mailed links instead of the one from the request
+func (auth *Auth) SetPublicURL(publicURL string) {
+	auth.publicURL = publicURL
+}
+
+// SetRequireVerifiedEmail stops accounts from logging in before they have verified their email
+func (auth *Auth) SetRequireVerifiedEmail(require bool) {
+	auth.requireVerifiedEmail = require
+}
+
+// Argon2id limits of the official server. The memory is in MB
+const (
+	argon2MinIterations  = 2
+	argon2MaxIterations  = 10
+	argon2MinMemory      = 15
+	argon2MaxMemory      = 1024
+	argon2MinParallelism = 1
+	argon2MaxParallelism = 16
+)
+
+// Checks the KDF settings from the client
+func (auth *Auth) validKdf(acc bw.Account) bool {
+	switch acc.Kdf {
+	case bw.KdfPBKDF2:
+		return acc.KdfIterations >= auth.minIterations && acc.KdfIterations <= auth.maxIterations
+	case bw.KdfArgon2id:
+		return acc.KdfIterations >= argon2MinIterations && acc.KdfIterations <= argon2MaxIterations &&
+			acc.KdfMemory != nil && *acc.KdfMemory >= argon2MinMemory && *acc.KdfMemory <= argon2MaxMemory &&
+			acc.KdfParallelism != nil && *acc.KdfParallelism >= argon2MinParallelism && *acc.KdfParallelism <= argon2MaxParallelism
+	}
+
+	return false
+}
+
 // Interface to make testing easier
 type database interface {
 	AddAccount(acc bw.Account) error
 	GetAccount(username string, refreshtoken string) (bw.Account, error)
 	UpdateAccountInfo(acc bw.Account) error
+	UpdateMasterPassword(acc bw.Account) error
+	UpdateEmail(acc bw.Account) error
+	UpdateSecurityStamp(userID string, stamp string) error
+	VerifyEmail(userID string, email string) error
 	Update2FAsecret(secret string, email string) error
+	SetToken(userID string, purpose string, token string, data string, expires time.Time) error
+	UseToken(userID string, purpose string, token string) (string, error)
+}
+
+// Interface for sending mails like the email change token
+type mailer interface {
+	Send(to string, subject string, body string) error
+}
+
+// Argon2id uses few iterations so the server hash uses a fixed number instead
+const argon2ServerIterations = 100000
+
+// hashPassword hashes the password hash from the client again before it's stored or compared
+func hashPassword(acc bw.Account, passwordHash string) (string, error) {
+	itr := acc.KdfIterations
+	if acc.Kdf == bw.KdfArgon2id {
+		itr = argon2ServerIterations
+	}
+
+	return reHashPassword(passwordHash, acc.Email, itr)
 }
 
 func reHashPassword(key, salt string, itr int) (string, error) {
@@ -79,11 +177,15 @@ func (auth *Auth) HandlePrelogin(w http.ResponseWriter, req *http.Request) {
 
 	// Return Kdf data
 	itrData := struct {
-		Kdf           int
-		KdfIterations int
+		Kdf            int
+		KdfIterations  int
+		KdfMemory      *int
+		KdfParallelism *int
 	}{
-		Kdf:           acc.Kdf,
-		KdfIterations: acc.KdfIterations,
+		Kdf:            acc.Kdf,
+		KdfIterations:  acc.KdfIterations,
+		KdfMemory:      acc.KdfMemory,
+		KdfParallelism: acc.KdfParallelism,
 	}
 
 	data, err := json.Marshal(&itrData)
@@ -111,14 +213,21 @@ func (auth *Auth) HandleRegister(w http.ResponseWriter, req *http.Request) {
 
 	log.Println(acc.Email + " is trying to register")
 
-	// Check iterations
-	if acc.KdfIterations < 5000 || acc.KdfIterations > 100000 {
+	// Check the KDF settings
+	if !auth.validKdf(acc) {
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte(http.StatusText(http.StatusBadRequest)))
 		return
 	}
 
-	acc.MasterPasswordHash, err = reHashPassword(acc.MasterPasswordHash, acc.Email, acc.KdfIterations)
+	if acc.Kdf == bw.KdfPBKDF2 {
+		acc.KdfMemory, acc.KdfParallelism = nil, nil
+	}
+
+	acc.MasterPasswordHash, err = hashPassword(acc, acc.MasterPasswordHash)
+	if err == nil {
+		acc.SecurityStamp, err = NewSecurityStamp()
+	}
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte(http.StatusText(500)))
@@ -134,6 +243,17 @@ func (auth *Auth) HandleRegister(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	// The user can't log in without the mail, the account id is needed for the token
+	if auth.requireVerifiedEmail {
+		acc, err = auth.db.GetAccount(acc.Email, "")
+		if err == nil {
+			err = auth.sendVerifyEmail(req, acc)
+		}
+		if err != nil {
+			log.Println(err)
+		}
+	}
+
 	w.Write([]byte{0x00})
 }
 
@@ -155,6 +275,12 @@ type resToken struct {
 	TokenType    string `json:"token_type"`
 	RefreshToken string `json:"refresh_token"`
 	Key          string `json:"Key"`
+
+	// The clients need the KDF settings to unlock the vault
+	Kdf            int
+	KdfIterations  int
+	KdfMemory      *int
+	KdfParallelism *int
 }
 
 // PrivateKey is needed by the web vault. But android will crash if it's included
@@ -224,6 +350,31 @@ func (auth *Auth) HandleLogin(w http.ResponseWriter, req *http.Request) {
 				return
 			}
 		}
+
+		// The mail from the registration may be lost, send a new one
+		if auth.requireVerifiedEmail && !acc.EmailVerified && auth.verifyMailsPerEmail.allow(strings.ToLower(acc.Email)) {
+			err := auth.sendVerifyEmail(req, acc)
+			if err != nil {
+				log.Println(err)
+			}
+		}
+	}
+
+	if auth.requireVerifiedEmail && !acc.EmailVerified {
+		resp := struct {
+			Error            string `json:"error"`
+			ErrorDescription string `json:"error_description"`
+		}{
+			Error:            "invalid_grant",
+			ErrorDescription: "Your email is not verified. Check your mail for the verification link.",
+		}
+		data, _ := json.Marshal(&resp)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(data)
+		log.Println(acc.Email + " has not verified the email")
+		return
 	}
 
 	// Don't change refresh token every time or the other clients will be logged out
@@ -249,7 +400,8 @@ func (auth *Auth) HandleLogin(w http.ResponseWriter, req *http.Request) {
 	claims["email"] = acc.Email
 	claims["name"] = acc.Name
 	claims["premium"] = false
-	claims["email_verified"] = false
+	claims["email_verified"] = acc.EmailVerified
+	claims["sstamp"] = acc.SecurityStamp
 	tokenString, _ := token.SignedString(auth.signingKey)
 
 	rtoken := resToken{AccessToken: tokenString,
@@ -257,6 +409,11 @@ func (auth *Auth) HandleLogin(w http.ResponseWriter, req *http.Request) {
 		TokenType:    "Bearer",
 		RefreshToken: acc.RefreshToken,
 		Key:          acc.Key,
+
+		Kdf:            acc.Kdf,
+		KdfIterations:  acc.KdfIterations,
+		KdfMemory:      acc.KdfMemory,
+		KdfParallelism: acc.KdfParallelism,
 	}
 
 	var data []byte
@@ -290,6 +447,12 @@ func GetEmail(req *http.Request) string {
 	return req.Context().Value(ctxKey("email")).(string)
 }
 
+// WithEmail returns the request with the email of the authenticated user.
+// JwtMiddleware sets it after the token is checked
+func WithEmail(req *http.Request, email string) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), ctxKey("email"), email))
+}
+
 func (auth *Auth) JwtMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		tokens, ok := req.Header["Authorization"]
@@ -322,9 +485,8 @@ func (auth *Auth) JwtMiddleware(next http.Handler) http.Handler {
 
 		if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
 			email, ok := claims["email"].(string)
-			if ok {
-				ctx := context.WithValue(req.Context(), ctxKey("email"), email)
-				next.ServeHTTP(w, req.WithContext(ctx))
+			if ok && auth.validSecurityStamp(email, claims["sstamp"]) {
+				next.ServeHTTP(w, WithEmail(req, email))
 				return
 			}
 		}
@@ -334,17 +496,62 @@ func (auth *Auth) JwtMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// Tokens issued before the security stamp changed are no longer valid
+func (auth *Auth) validSecurityStamp(email string, stamp interface{}) bool {
+	acc, err := auth.db.GetAccount(email, "")
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+
+	s, ok := stamp.(string)
+	if !ok || subtle.ConstantTimeCompare([]byte(s), []byte(acc.SecurityStamp)) != 1 {
+		log.Println("JWT: Old security stamp for " + email)
+		return false
+	}
+
+	return true
+}
+
 func checkPassword(db database, username, passwordHash string) (bw.Account, error) {
 	acc, err := db.GetAccount(username, "")
 	if err != nil {
 		return bw.Account{}, err
 	}
 
-	reHash, _ := reHashPassword(passwordHash, acc.Email, acc.KdfIterations)
-
-	if acc.MasterPasswordHash != reHash {
+	if !VerifyPassword(acc, passwordHash) {
 		return bw.Account{}, errors.New("Login attempt failed")
 	}
 
 	return acc, nil
 }
+
+// VerifyPassword checks the password hash from the client against the one stored for the account
+func VerifyPassword(acc bw.Account, passwordHash string) bool {
+	reHash, err := hashPassword(acc, passwordHash)
+	if err != nil {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(acc.MasterPasswordHash), []byte(reHash)) == 1
+}
+
+// SetMasterPassword sets the new password hash and key from the client and a new security stamp,
+// so all clients are logged out when the account is stored. Used when someone else sets the password
+func SetMasterPassword(acc *bw.Account, passwordHash string, key string) error {
+	hash, err := hashPassword(*acc, passwordHash)
+	if err != nil {
+		return err
+	}
+
+	stamp, err := NewSecurityStamp()
+	if err != nil {
+		return err
+	}
+
+	acc.MasterPasswordHash = hash
+	acc.Key = key
+	acc.SecurityStamp = stamp
+	acc.RefreshToken = ""
+	return nil
+}
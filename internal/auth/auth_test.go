@@ -8,6 +8,7 @@ import (
 	"strings"
 	"testing"
 
+	bw "github.com/VictorNine/bitwarden-go/internal/common"
 	"github.com/VictorNine/bitwarden-go/internal/database/mock"
 )
 
@@ -27,7 +28,7 @@ func TestHandleLogin(t *testing.T) {
 	}
 
 	for _, c := range cases {
-		authHandler := New(c.db, "", 3600)
+		authHandler := New(c.db, nil, "", 3600)
 
 		req, err := http.NewRequest("POST", "/identity/connect/token", strings.NewReader(c.data.Encode()))
 		if err != nil {
@@ -46,3 +47,38 @@ func TestHandleLogin(t *testing.T) {
 	}
 
 }
+
+func TestVerifyPassword(t *testing.T) {
+	keyHash, _ := reHashPassword("sjlcxv1TSe1wTHoYF50WJL3X07oCFxqhXYFeGfrbtII=", "nobody@example.com", 5000)
+	acc := bw.Account{Email: "nobody@example.com", MasterPasswordHash: keyHash, KdfIterations: 5000}
+
+	if !VerifyPassword(acc, "sjlcxv1TSe1wTHoYF50WJL3X07oCFxqhXYFeGfrbtII=") {
+		t.Error("Correct password rejected")
+	}
+
+	for _, hash := range []string{"", "not base64", "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="} {
+		if VerifyPassword(acc, hash) {
+			t.Errorf("Wrong password %s accepted", hash)
+		}
+	}
+}
+
+func TestHashPasswordArgon2id(t *testing.T) {
+	memory, parallelism := 64, 4
+	acc := bw.Account{Email: "nobody@example.com", Kdf: bw.KdfArgon2id, KdfIterations: 3, KdfMemory: &memory, KdfParallelism: &parallelism}
+
+	// The few Argon2id iterations must not be used for the server hash
+	weak, _ := reHashPassword("sjlcxv1TSe1wTHoYF50WJL3X07oCFxqhXYFeGfrbtII=", acc.Email, acc.KdfIterations)
+	hash, err := hashPassword(acc, "sjlcxv1TSe1wTHoYF50WJL3X07oCFxqhXYFeGfrbtII=")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash == weak {
+		t.Fatal("Server hash uses the Argon2id iterations")
+	}
+
+	acc.MasterPasswordHash = hash
+	if !VerifyPassword(acc, "sjlcxv1TSe1wTHoYF50WJL3X07oCFxqhXYFeGfrbtII=") {
+		t.Error("Correct password rejected")
+	}
+}
@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiter allows max events for each key within the interval
+type rateLimiter struct {
+	mu       sync.Mutex
+	max      int
+	interval time.Duration
+	events   map[string][]time.Time
+}
+
+func newRateLimiter(max int, interval time.Duration) *rateLimiter {
+	return &rateLimiter{
+		max:      max,
+		interval: interval,
+		events:   make(map[string][]time.Time),
+	}
+}
+
+// allow records the event if the key is below the limit
+func (rl *rateLimiter) allow(key string) bool {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := time.Now()
+
+	// Forget old keys now and then so the map doesn't grow forever
+	if len(rl.events) > 10000 {
+		for k, events := range rl.events {
+			if now.Sub(events[len(events)-1]) > rl.interval {
+				delete(rl.events, k)
+			}
+		}
+	}
+
+	var recent []time.Time
+	for _, t := range rl.events[key] {
+		if now.Sub(t) < rl.interval {
+			recent = append(recent, t)
+		}
+	}
+
+	if len(recent) >= rl.max {
+		rl.events[key] = recent
+		return false
+	}
+
+	rl.events[key] = append(recent, now)
+	return true
+}
@@ -0,0 +1,27 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	rl := newRateLimiter(2, time.Hour)
+
+	if !rl.allow("a") || !rl.allow("a") {
+		t.Fatal("Events below the limit rejected")
+	}
+	if rl.allow("a") {
+		t.Fatal("Event above the limit allowed")
+	}
+	if !rl.allow("b") {
+		t.Fatal("Limit shared between keys")
+	}
+
+	rl = newRateLimiter(1, time.Millisecond)
+	rl.allow("a")
+	time.Sleep(2 * time.Millisecond)
+	if !rl.allow("a") {
+		t.Fatal("Old events not forgotten")
+	}
+}
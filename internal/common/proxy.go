@@ -4,8 +4,29 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"strings"
 )
 
+// BaseURL is the address the client used to reach us. Used to create links back to the server
+func BaseURL(req *http.Request) string {
+	scheme := "http"
+	if req.TLS != nil || req.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+
+	return scheme + "://" + req.Host
+}
+
+// LinkURL is the address used in mailed links. The request is only used when publicURL isn't set,
+// since anyone can send a request with another Host header to get links to their own server mailed
+func LinkURL(publicURL string, req *http.Request) string {
+	if publicURL != "" {
+		return strings.TrimSuffix(publicURL, "/")
+	}
+
+	return BaseURL(req)
+}
+
 type Proxy struct {
 	VaultURL string
 }
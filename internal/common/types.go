@@ -2,6 +2,8 @@ package common
 
 import (
 	"encoding/json"
+	"fmt"
+	"strconv"
 	"time"
 )
 
@@ -20,21 +22,31 @@ type Account struct {
 	KeyPair            KeyPair `json:"keys"`
 	RefreshToken       string  `json:"-"`
 	TwoFactorSecret    string  `json:"-"`
+	SecurityStamp      string  `json:"-"` // Changes when the keys or password change
+	EmailVerified      bool    `json:"-"`
 	Kdf                int     `json:"kdf"`
 	KdfIterations      int     `json:"kdfIterations"`
+	KdfMemory          *int    `json:"kdfMemory"`      // Only used by Argon2id, in MB
+	KdfParallelism     *int    `json:"kdfParallelism"` // Only used by Argon2id
 }
 
+// Key derivation functions
+const (
+	KdfPBKDF2   = 0
+	KdfArgon2id = 1
+)
+
 func (acc Account) GetProfile() Profile {
 	p := Profile{
 		Id:                 acc.Id,
 		Name:               nil,
 		Email:              acc.Email,
-		EmailVerified:      false,
+		EmailVerified:      acc.EmailVerified,
 		Premium:            false,
 		Culture:            "en-US",
 		Key:                acc.Key,
 		SecurityStamp:      nil,
-		Organizations:      make([]string, 0),
+		Organizations:      make([]ProfileOrganization, 0),
 		MasterPasswordHint: nil,
 		PrivateKey:         acc.KeyPair.EncryptedPrivateKey,
 		Object:             "profile",
@@ -44,6 +56,10 @@ func (acc Account) GetProfile() Profile {
 		p.TwoFactorEnabled = true
 	}
 
+	if acc.SecurityStamp != "" {
+		p.SecurityStamp = &acc.SecurityStamp
+	}
+
 	return p
 }
 
@@ -56,19 +72,22 @@ type Cipher struct {
 	Edit                bool
 	Id                  string
 	Data                CipherData // deprecated TODO: Stop depending on this
-	Attachments         []string
+	Attachments         []Attachment
 	OrganizationUseTotp bool
 	RevisionDate        time.Time
+	DeletedDate         *time.Time // Set when the cipher is in the trash
 	Object              string
 	CollectionIds       []string
 
-	Card       *string
-	Fields     []string
-	Identity   *string
+	Card       *Card
+	Fields     []Field
+	Identity   *Identity
 	Login      Login
 	Name       *string
 	Notes      *string // Must be pointer to output null in json. Android app will crash if not null
 	SecureNote SecureNote
+
+	PasswordHistory []PasswordHistory
 }
 
 type CipherData struct {
@@ -78,8 +97,12 @@ type CipherData struct {
 	Totp     *string // Must be pointer to output null in json. Android app will crash if not null
 	Name     *string
 	Notes    *string // Must be pointer to output null in json. Android app will crash if not null
-	Fields   []string
+	Fields   []Field
 	Uris     []Uri
+	Card     *Card
+	Identity *Identity
+
+	PasswordHistory []PasswordHistory
 }
 
 type Uri struct {
@@ -95,6 +118,89 @@ type Login struct {
 	Username *string
 }
 
+type Attachment struct {
+	Id       string
+	Url      string
+	FileName string
+	Key      *string
+	Size     string // The clients expect the size as a string
+	SizeName string
+	Object   string
+
+	CipherId string `json:"-"`
+}
+
+// SetSize sets the size and a human readable version of it
+func (a *Attachment) SetSize(size int64) {
+	a.Size = strconv.FormatInt(size, 10)
+	a.SizeName = sizeName(size)
+}
+
+func (a *Attachment) ParseSize() (int64, error) {
+	return strconv.ParseInt(a.Size, 10, 64)
+}
+
+// Human readable size like the official server shows
+func sizeName(size int64) string {
+	units := []string{"Bytes", "KB", "MB", "GB"}
+	s := float64(size)
+	i := 0
+	for s >= 1024 && i < len(units)-1 {
+		s /= 1024
+		i++
+	}
+
+	if i == 0 {
+		return fmt.Sprintf("%d %s", size, units[i])
+	}
+
+	return fmt.Sprintf("%.2f %s", s, units[i])
+}
+
+// A previously used login password
+type PasswordHistory struct {
+	Password     *string
+	LastUsedDate time.Time
+}
+
+// Custom field. Type is 0 for text, 1 for hidden, 2 for boolean and 3 for linked
+type Field struct {
+	Type     int
+	Name     *string
+	Value    *string
+	LinkedId *int
+}
+
+type Card struct {
+	CardholderName *string
+	Brand          *string
+	Number         *string
+	ExpMonth       *string
+	ExpYear        *string
+	Code           *string
+}
+
+type Identity struct {
+	Title          *string
+	FirstName      *string
+	MiddleName     *string
+	LastName       *string
+	Address1       *string
+	Address2       *string
+	Address3       *string
+	City           *string
+	State          *string
+	PostalCode     *string
+	Country        *string
+	Company        *string
+	Email          *string
+	Phone          *string
+	SSN            *string
+	Username       *string
+	PassportNumber *string
+	LicenseNumber  *string
+}
+
 type SecureNote struct {
 	Type int
 }
@@ -116,16 +222,17 @@ type Profile struct {
 	Key                string
 	PrivateKey         string
 	SecurityStamp      *string
-	Organizations      []string
+	Organizations      []ProfileOrganization
 	Object             string
 }
 
 // Copy from data to new fields
 func FakeNewAPI(ciph *Cipher) {
 	// TODO: Rewrite this when the data field is removed
-	ciph.Card = nil // TODO: Implement
-	ciph.Fields = nil
-	ciph.Identity = nil // TODO: Implement
+	ciph.Card = ciph.Data.Card
+	ciph.Fields = ciph.Data.Fields
+	ciph.Identity = ciph.Data.Identity
+	ciph.PasswordHistory = ciph.Data.PasswordHistory
 	ciph.Name = ciph.Data.Name
 
 	// Set ciph.Data.Uris if it's not in the DB
@@ -154,12 +261,131 @@ func FakeNewAPI(ciph *Cipher) {
 	}
 }
 
+// Organization user types
+const (
+	OrgUserOwner   = 0
+	OrgUserAdmin   = 1
+	OrgUserUser    = 2
+	OrgUserManager = 3
+)
+
+// Organization user status
+const (
+	OrgUserInvited   = 0
+	OrgUserAccepted  = 1
+	OrgUserConfirmed = 2
+)
+
+// Organization is a vault shared by its members
+type Organization struct {
+	Id              string
+	Name            string
+	BusinessName    *string
+	BillingEmail    string
+	Plan            string
+	PlanType        int
+	Seats           *int // No limits for self hosted organizations
+	MaxCollections  *int
+	MaxStorageGb    *int
+	UseGroups       bool
+	UseDirectory    bool
+	UseEvents       bool
+	UseTotp         bool
+	Use2fa          bool
+	UseApi          bool
+	UsersGetPremium bool
+	SelfHost        bool
+	Enabled         bool
+	Object          string
+
+	Keys KeyPair `json:"-"`
+}
+
+// OrganizationUser is an account's membership in an organization
+type OrganizationUser struct {
+	Id        string
+	UserId    *string // Not set until the invite is accepted
+	Name      *string
+	Email     string
+	Status    int
+	Type      int
+	AccessAll bool
+	Object    string
+
+	OrganizationId string `json:"-"`
+	Key            string `json:"-"` // The organization key encrypted for the user
+}
+
+// The organizations in the profile
+type ProfileOrganization struct {
+	Id              string
+	Name            string
+	UseGroups       bool
+	UseDirectory    bool
+	UseEvents       bool
+	UseTotp         bool
+	Use2fa          bool
+	UseApi          bool
+	UsersGetPremium bool
+	SelfHost        bool
+	Seats           *int
+	MaxCollections  *int
+	MaxStorageGb    *int
+	Key             string
+	Status          int
+	Type            int
+	Enabled         bool
+	Object          string
+}
+
+// GetProfileOrganization combines the organization and the membership of a user
+func (org Organization) GetProfileOrganization(ou OrganizationUser) ProfileOrganization {
+	return ProfileOrganization{
+		Id:              org.Id,
+		Name:            org.Name,
+		UseGroups:       org.UseGroups,
+		UseDirectory:    org.UseDirectory,
+		UseEvents:       org.UseEvents,
+		UseTotp:         org.UseTotp,
+		Use2fa:          org.Use2fa,
+		UseApi:          org.UseApi,
+		UsersGetPremium: org.UsersGetPremium,
+		SelfHost:        org.SelfHost,
+		Seats:           org.Seats,
+		MaxCollections:  org.MaxCollections,
+		MaxStorageGb:    org.MaxStorageGb,
+		Key:             ou.Key,
+		Status:          ou.Status,
+		Type:            ou.Type,
+		Enabled:         org.Enabled,
+		Object:          "profileOrganization",
+	}
+}
+
+// Collection groups the ciphers of an organization
+type Collection struct {
+	Id             string
+	OrganizationId string
+	Name           string
+	ExternalId     *string
+	ReadOnly       bool `json:",omitempty"` // Only sent with the collection details
+	Object         string
+}
+
+// SelectionReadOnly is used to assign users to collections
+type SelectionReadOnly struct {
+	Id       string
+	ReadOnly bool
+}
+
 type SyncData struct {
-	Profile Profile
-	Folders []Folder
-	Ciphers []Cipher
-	Domains Domains
-	Object  string
+	Profile     Profile
+	Folders     []Folder
+	Collections []Collection
+	Ciphers     []Cipher
+	Sends       []Send
+	Domains     Domains
+	Object      string
 }
 
 type Domains struct {
@@ -185,3 +411,179 @@ type Data struct {
 	Object string
 	Data   interface{}
 }
+
+// Send types
+const (
+	SendTypeText = 0
+	SendTypeFile = 1
+)
+
+// Send shares encrypted text or a file with anyone who has the link. The key is only in the link
+type Send struct {
+	Id             string
+	AccessId       string // Used in the link instead of the id
+	Type           int
+	Name           string
+	Notes          *string
+	Text           *SendText
+	File           *SendFile
+	Key            string
+	MaxAccessCount *int
+	AccessCount    int
+	Password       *string // Hashed again by the server. The clients only check if it's set
+	Disabled       bool
+	HideEmail      bool
+	RevisionDate   time.Time
+	ExpirationDate *time.Time // Can't be accessed after this date
+	DeletionDate   time.Time  // Deleted by the server after this date
+	Object         string
+
+	UserId string `json:"-"`
+}
+
+type SendText struct {
+	Text   *string
+	Hidden bool
+}
+
+type SendFile struct {
+	Id       string
+	FileName string
+	Size     string // The clients expect the size as a string
+	SizeName string
+}
+
+// SetSize sets the size and a human readable version of it
+func (f *SendFile) SetSize(size int64) {
+	f.Size = strconv.FormatInt(size, 10)
+	f.SizeName = sizeName(size)
+}
+
+func (f *SendFile) ParseSize() (int64, error) {
+	return strconv.ParseInt(f.Size, 10, 64)
+}
+
+// SendAccess is what the anonymous users get
+type SendAccess struct {
+	Id                string
+	Type              int
+	Name              string
+	Text              *SendText
+	File              *SendFile
+	ExpirationDate    *time.Time
+	CreatorIdentifier *string // The email of the creator unless it's hidden
+	Object            string
+}
+
+// GetSendAccess returns the send without the fields only the creator should see
+func (s Send) GetSendAccess(creator string) SendAccess {
+	access := SendAccess{
+		Id:             s.AccessId,
+		Type:           s.Type,
+		Name:           s.Name,
+		Text:           s.Text,
+		File:           s.File,
+		ExpirationDate: s.ExpirationDate,
+		Object:         "send-access",
+	}
+
+	if !s.HideEmail {
+		access.CreatorIdentifier = &creator
+	}
+
+	return access
+}
+
+// Emergency access types
+const (
+	EmergencyAccessView     = 0
+	EmergencyAccessTakeover = 1
+)
+
+// Emergency access status
+const (
+	EmergencyAccessInvited           = 0
+	EmergencyAccessAccepted          = 1
+	EmergencyAccessConfirmed         = 2
+	EmergencyAccessRecoveryInitiated = 3
+	EmergencyAccessRecoveryApproved  = 4
+)
+
+// EmergencyAccess lets the grantee view or take over the vault of the grantor
+// when the grantor doesn't reject the request within the wait time
+type EmergencyAccess struct {
+	Id                    string
+	GrantorId             string
+	GranteeId             *string // Not set until the invite is accepted
+	Email                 string  // The email the invite was sent to
+	KeyEncrypted          *string // The key of the grantor encrypted with the public key of the grantee
+	Type                  int
+	Status                int
+	WaitTimeDays          int
+	RecoveryInitiatedDate *time.Time
+	CreationDate          time.Time
+
+	GrantorName  *string
+	GrantorEmail string
+	GranteeName  *string
+	GranteeEmail *string
+}
+
+// The emergency access as the grantor sees it
+type EmergencyAccessGranteeDetails struct {
+	Id           string
+	GranteeId    *string
+	Name         *string
+	Email        string
+	Type         int
+	Status       int
+	WaitTimeDays int
+	CreationDate time.Time
+	Object       string
+}
+
+// The emergency access as the grantee sees it
+type EmergencyAccessGrantorDetails struct {
+	Id           string
+	GrantorId    string
+	Name         *string
+	Email        string
+	Type         int
+	Status       int
+	WaitTimeDays int
+	CreationDate time.Time
+	Object       string
+}
+
+func (ea EmergencyAccess) GetGranteeDetails() EmergencyAccessGranteeDetails {
+	email := ea.Email
+	if ea.GranteeEmail != nil {
+		email = *ea.GranteeEmail
+	}
+
+	return EmergencyAccessGranteeDetails{
+		Id:           ea.Id,
+		GranteeId:    ea.GranteeId,
+		Name:         ea.GranteeName,
+		Email:        email,
+		Type:         ea.Type,
+		Status:       ea.Status,
+		WaitTimeDays: ea.WaitTimeDays,
+		CreationDate: ea.CreationDate,
+		Object:       "emergencyAccessGranteeDetails",
+	}
+}
+
+func (ea EmergencyAccess) GetGrantorDetails() EmergencyAccessGrantorDetails {
+	return EmergencyAccessGrantorDetails{
+		Id:           ea.Id,
+		GrantorId:    ea.GrantorId,
+		Name:         ea.GrantorName,
+		Email:        ea.GrantorEmail,
+		Type:         ea.Type,
+		Status:       ea.Status,
+		WaitTimeDays: ea.WaitTimeDays,
+		CreationDate: ea.CreationDate,
+		Object:       "emergencyAccessGrantorDetails",
+	}
+}
@@ -1,6 +1,9 @@
 package mock
 
 import (
+	"errors"
+	"time"
+
 	bw "github.com/VictorNine/bitwarden-go/internal/common"
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -12,6 +15,10 @@ type MockDB struct {
 	RefreshToken    string
 	TwoFactorSecret string
 	KdfIterations   int
+	Token           string
+	TokenData       string
+	SecurityStamp   string
+	EmailVerified   bool
 }
 
 func (db *MockDB) Init() error {
@@ -32,6 +39,50 @@ func (db *MockDB) UpdateAccountInfo(acc bw.Account) error {
 	return nil
 }
 
+func (db *MockDB) UpdateMasterPassword(acc bw.Account) error {
+	db.Password = acc.MasterPasswordHash
+	db.RefreshToken = acc.RefreshToken
+	db.SecurityStamp = acc.SecurityStamp
+	return nil
+}
+
+func (db *MockDB) UpdateSecurityStamp(userID string, stamp string) error {
+	db.SecurityStamp = stamp
+	db.RefreshToken = ""
+	return nil
+}
+
+func (db *MockDB) UpdateEmail(acc bw.Account) error {
+	db.Username = acc.Email
+	db.Password = acc.MasterPasswordHash
+	db.RefreshToken = acc.RefreshToken
+	db.SecurityStamp = acc.SecurityStamp
+	db.EmailVerified = true
+	return nil
+}
+
+func (db *MockDB) VerifyEmail(userID string, email string) error {
+	if email != db.Username {
+		return errors.New("Email has changed")
+	}
+	db.EmailVerified = true
+	return nil
+}
+
+func (db *MockDB) SetToken(userID string, purpose string, token string, data string, expires time.Time) error {
+	db.Token = token
+	db.TokenData = data
+	return nil
+}
+
+func (db *MockDB) UseToken(userID string, purpose string, token string) (string, error) {
+	if token == "" || token != db.Token {
+		return "", errors.New("Invalid token")
+	}
+	db.Token = ""
+	return db.TokenData, nil
+}
+
 func (db *MockDB) GetCipher(owner string, ciphID string) (bw.Cipher, error) {
 	return bw.Cipher{}, nil
 }
@@ -58,7 +109,10 @@ func (db *MockDB) AddAccount(acc bw.Account) error {
 }
 
 func (db *MockDB) GetAccount(username string, refreshtoken string) (bw.Account, error) {
-	return bw.Account{Email: db.Username, MasterPasswordHash: db.Password, RefreshToken: db.RefreshToken, TwoFactorSecret: db.TwoFactorSecret, KdfIterations: db.KdfIterations}, nil
+	if username != "" && username != db.Username {
+		return bw.Account{}, errors.New("Account not found")
+	}
+	return bw.Account{Email: db.Username, MasterPasswordHash: db.Password, RefreshToken: db.RefreshToken, TwoFactorSecret: db.TwoFactorSecret, KdfIterations: db.KdfIterations, SecurityStamp: db.SecurityStamp, EmailVerified: db.EmailVerified}, nil
 }
 
 func (db *MockDB) AddFolder(name string, owner string) (bw.Folder, error) {
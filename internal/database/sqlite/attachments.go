@@ -0,0 +1,210 @@
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"time"
+
+	bw "github.com/VictorNine/bitwarden-go/internal/common"
+	uuid "github.com/satori/go.uuid"
+)
+
+const attachmentsTbl = `
+CREATE TABLE IF NOT EXISTS "attachments" (
+  id           TEXT,
+  cipherid     INTEGER,
+  owner        INTEGER,
+  filename     TEXT,
+  key          TEXT,
+  size         INTEGER,
+  pendingsince INT,
+PRIMARY KEY(id)
+)
+`
+
+// Get attachments grouped by cipher id. q can be a *sql.DB or *sql.Tx
+func getAttachments(q interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}, query string, args ...interface{}) (map[string][]bw.Attachment, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attachments := make(map[string][]bw.Attachment)
+	for rows.Next() {
+		att, err := sqlRowToAttachment(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		attachments[att.CipherId] = append(attachments[att.CipherId], att)
+	}
+
+	return attachments, rows.Err()
+}
+
+func sqlRowToAttachment(row interface {
+	Scan(dest ...interface{}) error
+}) (bw.Attachment, error) {
+	att := bw.Attachment{
+		Object: "attachment",
+	}
+
+	var cipherID, size int64
+	var key sql.NullString
+	err := row.Scan(&att.Id, &cipherID, &att.FileName, &key, &size)
+	if err != nil {
+		return att, err
+	}
+
+	if key.Valid {
+		att.Key = &key.String
+	}
+
+	att.CipherId = strconv.FormatInt(cipherID, 10)
+	att.SetSize(size)
+
+	return att, nil
+}
+
+// NewAttachment adds an attachment to the cipher. The data has to be stored separately
+// and the attachment is pending until UpdateAttachment is called
+func (db *DB) NewAttachment(att bw.Attachment, owner string, size int64) (bw.Attachment, error) {
+	iowner, err := strconv.ParseInt(owner, 10, 64)
+	if err != nil {
+		return bw.Attachment{}, err
+	}
+
+	iciphID, err := strconv.ParseInt(att.CipherId, 10, 64)
+	if err != nil {
+		return bw.Attachment{}, err
+	}
+
+	newID, err := uuid.NewV4()
+	if err != nil {
+		return bw.Attachment{}, err
+	}
+
+	att.Id = newID.String()
+	att.Object = "attachment"
+	att.SetSize(size)
+
+	stmt, err := db.db.Prepare("INSERT INTO attachments(id, cipherid, owner, filename, key, size, pendingsince) values(?,?,?,?,?,?,?)")
+	if err != nil {
+		return bw.Attachment{}, err
+	}
+
+	_, err = stmt.Exec(att.Id, iciphID, iowner, att.FileName, att.Key, size, time.Now().Unix())
+	if err != nil {
+		return bw.Attachment{}, err
+	}
+
+	return att, nil
+}
+
+// UpdateAttachment stores the key and size when the data of the attachment has been uploaded.
+// Important to check that the owner is correct before an update!
+func (db *DB) UpdateAttachment(owner string, attID string, key *string, size int64) error {
+	iowner, err := strconv.ParseInt(owner, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	stmt, err := db.db.Prepare("UPDATE attachments SET key=$1, size=$2, pendingsince=NULL WHERE id=$3 AND owner=$4")
+	if err != nil {
+		return err
+	}
+
+	res, err := stmt.Exec(key, size, attID, iowner)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil || n != 1 {
+		return errors.New("Attachment " + attID + " not found")
+	}
+
+	return nil
+}
+
+// GetAttachment doesn't check the owner. The id is random and the data is encrypted
+func (db *DB) GetAttachment(ciphID string, attID string) (bw.Attachment, error) {
+	iciphID, err := strconv.ParseInt(ciphID, 10, 64)
+	if err != nil {
+		return bw.Attachment{}, err
+	}
+
+	query := "SELECT id, cipherid, filename, key, size FROM attachments WHERE cipherid = $1 AND id = $2"
+	row := db.db.QueryRow(query, iciphID, attID)
+
+	return sqlRowToAttachment(row)
+}
+
+// Important to check that the owner is correct before an update!
+func (db *DB) DeleteAttachment(owner string, attID string) error {
+	iowner, err := strconv.ParseInt(owner, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	stmt, err := db.db.Prepare("DELETE from attachments WHERE id=? AND cipherid IN (SELECT c.id FROM ciphers c WHERE " + cipherAccess(true) + ")")
+	if err != nil {
+		return err
+	}
+
+	_, err = stmt.Exec(append([]interface{}{attID}, accessArgs(iowner)...)...)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetAttachmentsSize returns the total size of all attachments the owner has
+func (db *DB) GetAttachmentsSize(owner string) (int64, error) {
+	iowner, err := strconv.ParseInt(owner, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	var size sql.NullInt64
+	query := "SELECT SUM(size) FROM attachments WHERE owner = $1"
+	err = db.db.QueryRow(query, iowner).Scan(&size)
+	if err != nil {
+		return 0, err
+	}
+
+	return size.Int64, nil
+}
+
+// PurgePendingAttachments deletes the attachments created before the given time that never got their data.
+// Returns the deleted attachments so any data left from a failed upload can be removed
+func (db *DB) PurgePendingAttachments(before time.Time) ([]bw.Attachment, error) {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	attachments, err := getAttachments(tx, "SELECT id, cipherid, filename, key, size FROM attachments WHERE pendingsince < $1", before.Unix())
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	_, err = tx.Exec("DELETE FROM attachments WHERE pendingsince < $1", before.Unix())
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	var atts []bw.Attachment
+	for _, a := range attachments {
+		atts = append(atts, a...)
+	}
+
+	return atts, tx.Commit()
+}
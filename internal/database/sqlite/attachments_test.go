@@ -0,0 +1,91 @@
+package sqlite
+
+import (
+	"testing"
+	"time"
+
+	bw "github.com/VictorNine/bitwarden-go/internal/common"
+)
+
+func TestPurgePendingAttachments(t *testing.T) {
+	db, done := newTestDB(t)
+	defer done()
+
+	uploaded, err := db.NewAttachment(bw.Attachment{CipherId: "1", FileName: "2.uploaded"}, "1", 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending, err := db.NewAttachment(bw.Attachment{CipherId: "1", FileName: "2.pending"}, "1", 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := "2.key"
+	err = db.UpdateAttachment("1", uploaded.Id, &key, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing is purged before the upload time has passed
+	atts, err := db.PurgePendingAttachments(time.Now().Add(-time.Hour))
+	if err != nil || len(atts) != 0 {
+		t.Fatalf("Expected nothing purged got %v %v", atts, err)
+	}
+
+	atts, err = db.PurgePendingAttachments(time.Now().Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(atts) != 1 || atts[0].Id != pending.Id {
+		t.Fatalf("Expected only %s purged got %v", pending.Id, atts)
+	}
+
+	if _, err = db.GetAttachment("1", pending.Id); err == nil {
+		t.Error("Pending attachment not deleted")
+	}
+	att, err := db.GetAttachment("1", uploaded.Id)
+	if err != nil || att.Key == nil || *att.Key != key {
+		t.Errorf("Uploaded attachment changed: %v", err)
+	}
+}
+
+func TestPendingAttachmentsNotSynced(t *testing.T) {
+	db, done := newTestDB(t)
+	defer done()
+
+	acc := newTestAccount(t, db, "nobody@example.com")
+	name := "2.name"
+	ciph, err := db.NewCipher(bw.Cipher{Type: 1, Data: bw.CipherData{Name: &name}}, acc.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	uploaded, err := db.NewAttachment(bw.Attachment{CipherId: ciph.Id, FileName: "2.uploaded"}, acc.Id, 4)
+	if err == nil {
+		_, err = db.NewAttachment(bw.Attachment{CipherId: ciph.Id, FileName: "2.pending"}, acc.Id, 4)
+	}
+	if err == nil {
+		err = db.UpdateAttachment(acc.Id, uploaded.Id, nil, 4)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ciph, err = db.GetCipher(acc.Id, ciph.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphs, err := db.GetCiphers(acc.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ciphs) != 1 {
+		t.Fatalf("Expected 1 cipher got %d", len(ciphs))
+	}
+
+	for _, atts := range [][]bw.Attachment{ciph.Attachments, ciphs[0].Attachments} {
+		if len(atts) != 1 || atts[0].Id != uploaded.Id {
+			t.Errorf("Expected only the uploaded attachment got %v", atts)
+		}
+	}
+}
@@ -0,0 +1,389 @@
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	bw "github.com/VictorNine/bitwarden-go/internal/common"
+	uuid "github.com/satori/go.uuid"
+)
+
+const collectionsTbl = `
+CREATE TABLE IF NOT EXISTS "collections" (
+  id           TEXT,
+  orgid        TEXT,
+  name         TEXT,
+  revisiondate INT,
+PRIMARY KEY(id)
+)
+`
+
+const collectionCiphersTbl = `
+CREATE TABLE IF NOT EXISTS "collection_ciphers" (
+  collectionid TEXT,
+  cipherid     INTEGER,
+PRIMARY KEY(collectionid, cipherid)
+)
+`
+
+const collectionUsersTbl = `
+CREATE TABLE IF NOT EXISTS "collection_users" (
+  collectionid TEXT,
+  orguserid    TEXT,
+  readonly     INT NOT NULL,
+PRIMARY KEY(collectionid, orguserid)
+)
+`
+
+// Get collection ids grouped by cipher id. q can be a *sql.DB or *sql.Tx
+func getCollectionIDs(q interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}, query string, args ...interface{}) (map[string][]string, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	collectionIDs := make(map[string][]string)
+	for rows.Next() {
+		var colID string
+		var ciphID int64
+		err := rows.Scan(&colID, &ciphID)
+		if err != nil {
+			return nil, err
+		}
+
+		id := strconv.FormatInt(ciphID, 10)
+		collectionIDs[id] = append(collectionIDs[id], colID)
+	}
+
+	return collectionIDs, rows.Err()
+}
+
+func sqlRowToCollection(row interface {
+	Scan(dest ...interface{}) error
+}) (bw.Collection, error) {
+	col := bw.Collection{
+		Object: "collection",
+	}
+
+	err := row.Scan(&col.Id, &col.OrganizationId, &col.Name)
+	return col, err
+}
+
+func (db *DB) NewCollection(col bw.Collection) (bw.Collection, error) {
+	newID, err := uuid.NewV4()
+	if err != nil {
+		return bw.Collection{}, err
+	}
+
+	col.Id = newID.String()
+	col.Object = "collection"
+
+	stmt, err := db.db.Prepare("INSERT INTO collections(id, orgid, name, revisiondate) values(?,?,?,?)")
+	if err != nil {
+		return bw.Collection{}, err
+	}
+
+	_, err = stmt.Exec(col.Id, col.OrganizationId, col.Name, time.Now().Unix())
+	if err != nil {
+		return bw.Collection{}, err
+	}
+
+	return col, nil
+}
+
+func (db *DB) GetCollection(orgID string, colID string) (bw.Collection, error) {
+	query := "SELECT id, orgid, name FROM collections WHERE orgid = $1 AND id = $2"
+	row := db.db.QueryRow(query, orgID, colID)
+
+	return sqlRowToCollection(row)
+}
+
+// GetCollections returns all the collections in the organization
+func (db *DB) GetCollections(orgID string) ([]bw.Collection, error) {
+	query := "SELECT id, orgid, name FROM collections WHERE orgid = $1"
+	rows, err := db.db.Query(query, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	collections := make([]bw.Collection, 0) // Make an empty slice if there are none or android app will crash
+	for rows.Next() {
+		col, err := sqlRowToCollection(rows)
+		if err != nil {
+			return nil, err
+		}
+		collections = append(collections, col)
+	}
+
+	return collections, rows.Err()
+}
+
+// GetUserCollections returns the collections the user has access to in all organizations.
+// Owners, admins and members with access to all collections get all of them, like in cipherAccess
+func (db *DB) GetUserCollections(userID string) ([]bw.Collection, error) {
+	iuserID, err := strconv.ParseInt(userID, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `SELECT c.id, c.orgid, c.name, 0 FROM collections c
+  JOIN organization_users ou ON ou.orgid = c.orgid
+  WHERE ou.userid = $1 AND ou.status = $2 AND (ou.accessall = 1 OR ou.type IN ($3, $4))
+UNION
+SELECT c.id, c.orgid, c.name, cu.readonly FROM collections c
+  JOIN collection_users cu ON cu.collectionid = c.id
+  JOIN organization_users ou ON ou.id = cu.orguserid
+  WHERE ou.userid = $1 AND ou.status = $2 AND ou.accessall = 0 AND ou.type NOT IN ($3, $4)`
+	rows, err := db.db.Query(query, iuserID, bw.OrgUserConfirmed, bw.OrgUserOwner, bw.OrgUserAdmin)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	collections := make([]bw.Collection, 0) // Make an empty slice if there are none or android app will crash
+	for rows.Next() {
+		col := bw.Collection{Object: "collectionDetails"}
+		var readOnly int
+		err := rows.Scan(&col.Id, &col.OrganizationId, &col.Name, &readOnly)
+		if err != nil {
+			return nil, err
+		}
+		col.ReadOnly = readOnly == 1
+
+		collections = append(collections, col)
+	}
+
+	return collections, rows.Err()
+}
+
+// Important to check that the user is allowed to make changes!
+func (db *DB) UpdateCollection(col bw.Collection) error {
+	stmt, err := db.db.Prepare("UPDATE collections SET name=$1, revisiondate=$2 WHERE id=$3 AND orgid=$4")
+	if err != nil {
+		return err
+	}
+
+	_, err = stmt.Exec(col.Name, time.Now().Unix(), col.Id, col.OrganizationId)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Important to check that the user is allowed to make changes!
+func (db *DB) DeleteCollection(orgID string, colID string) error {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	res, err := tx.Exec("DELETE FROM collections WHERE orgid = $1 AND id = $2", orgID, colID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil || n != 1 {
+		tx.Rollback()
+		return errors.New("Collection " + colID + " not found")
+	}
+
+	for _, query := range []string{
+		"DELETE FROM collection_ciphers WHERE collectionid = $1",
+		"DELETE FROM collection_users WHERE collectionid = $1",
+	} {
+		_, err = tx.Exec(query, colID)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetCollectionUsers returns the members assigned to the collection
+func (db *DB) GetCollectionUsers(colID string) ([]bw.SelectionReadOnly, error) {
+	rows, err := db.db.Query("SELECT orguserid, readonly FROM collection_users WHERE collectionid = $1", colID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := make([]bw.SelectionReadOnly, 0)
+	for rows.Next() {
+		var u bw.SelectionReadOnly
+		var readOnly int
+		err := rows.Scan(&u.Id, &readOnly)
+		if err != nil {
+			return nil, err
+		}
+		u.ReadOnly = readOnly == 1
+
+		users = append(users, u)
+	}
+
+	return users, rows.Err()
+}
+
+// UpdateCollectionUsers replaces the members assigned to the collection.
+// Important to check that the user is allowed to make changes!
+func (db *DB) UpdateCollectionUsers(orgID string, colID string, users []bw.SelectionReadOnly) error {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM collection_users WHERE collectionid = $1", colID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for _, u := range users {
+		readOnly := 0
+		if u.ReadOnly {
+			readOnly = 1
+		}
+
+		// Only members of the organization can be added
+		res, err := tx.Exec("INSERT INTO collection_users(collectionid, orguserid, readonly) SELECT $1, id, $2 FROM organization_users WHERE id = $3 AND orgid = $4", colID, readOnly, u.Id, orgID)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil || n != 1 {
+			tx.Rollback()
+			return errors.New("Organization user " + u.Id + " not found")
+		}
+	}
+
+	return tx.Commit()
+}
+
+// The collections in the organization that the user can add ciphers to and remove them from.
+// The arguments from editableArgs has to be passed where this is used
+var editableCollections = fmt.Sprintf(`SELECT id FROM collections WHERE orgid = ? AND (
+  orgid IN (SELECT orgid FROM organization_users WHERE userid = ? AND status = %d AND (accessall = 1 OR type IN (%d, %d))) OR
+  id IN (SELECT cu.collectionid FROM collection_users cu JOIN organization_users ou ON ou.id = cu.orguserid
+    WHERE ou.userid = ? AND ou.status = %d AND cu.readonly = 0))`, bw.OrgUserConfirmed, bw.OrgUserOwner, bw.OrgUserAdmin, bw.OrgUserConfirmed)
+
+func editableArgs(iuser int64, orgID string) []interface{} {
+	return []interface{}{orgID, iuser, iuser}
+}
+
+// NewOrganizationCipher stores a cipher of the organization in the collections. The cipher has to be in at least
+// one collection and the user has to be able to edit all of them
+func (db *DB) NewOrganizationCipher(ciph bw.Cipher, owner string, collectionIDs []string) (bw.Cipher, error) {
+	iowner, err := strconv.ParseInt(owner, 10, 64)
+	if err != nil {
+		return bw.Cipher{}, err
+	}
+
+	if ciph.OrganizationId == nil || len(collectionIDs) == 0 {
+		return ciph, errors.New("An organization cipher has to be in at least one collection")
+	}
+
+	tx, err := db.db.Begin()
+	if err != nil {
+		return ciph, err
+	}
+
+	iciphID, err := insertCipher(tx, &ciph, iowner)
+	if err != nil {
+		tx.Rollback()
+		return ciph, err
+	}
+
+	for _, colID := range collectionIDs {
+		args := append([]interface{}{iciphID, colID}, editableArgs(iowner, *ciph.OrganizationId)...)
+		res, err := tx.Exec("INSERT INTO collection_ciphers(collectionid, cipherid) SELECT id, ? FROM collections WHERE id = ? AND id IN ("+editableCollections+")", args...)
+		if err != nil {
+			tx.Rollback()
+			return ciph, err
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil || n != 1 {
+			tx.Rollback()
+			return ciph, errors.New("Not allowed to add ciphers to collection " + colID)
+		}
+	}
+	ciph.CollectionIds = collectionIDs
+
+	return ciph, tx.Commit()
+}
+
+// UpdateCipherCollections sets the collections the cipher is in. Only the collections the user
+// can edit are changed, the cipher stays in the others. Important to check that the user can edit the cipher!
+func (db *DB) UpdateCipherCollections(userID string, orgID string, ciphID string, collectionIDs []string) error {
+	iuser, err := strconv.ParseInt(userID, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	iciphID, err := strconv.ParseInt(ciphID, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM collection_ciphers WHERE cipherid = ? AND collectionid IN ("+editableCollections+")", append([]interface{}{iciphID}, editableArgs(iuser, orgID)...)...)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for _, colID := range collectionIDs {
+		args := append([]interface{}{iciphID, colID}, editableArgs(iuser, orgID)...)
+		res, err := tx.Exec("INSERT OR IGNORE INTO collection_ciphers(collectionid, cipherid) SELECT id, ? FROM collections WHERE id = ? AND id IN ("+editableCollections+")", args...)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if n == 1 {
+			continue
+		}
+
+		// Collections the user can't edit can only be sent if the cipher is already in them
+		var existing int
+		err = tx.QueryRow("SELECT COUNT(*) FROM collection_ciphers WHERE collectionid = $1 AND cipherid = $2", colID, iciphID).Scan(&existing)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if existing != 1 {
+			tx.Rollback()
+			return errors.New("Not allowed to add ciphers to collection " + colID)
+		}
+	}
+
+	_, err = tx.Exec("UPDATE ciphers SET revisiondate=$1 WHERE id=$2", time.Now().Unix(), iciphID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,171 @@
+package sqlite
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	bw "github.com/VictorNine/bitwarden-go/internal/common"
+)
+
+func TestUpdateCipherCollections(t *testing.T) {
+	db, done := newTestDB(t)
+	defer done()
+
+	acc := newTestAccount(t, db, "nobody@example.com")
+	member := newTestAccount(t, db, "member@example.com")
+
+	org, err := db.NewOrganization(bw.Organization{Name: "org"}, bw.OrganizationUser{UserId: &acc.Id, Email: acc.Email})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cols []string
+	for _, name := range []string{"2.editable", "2.readonly", "2.hidden"} {
+		col, err := db.NewCollection(bw.Collection{OrganizationId: org.Id, Name: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		cols = append(cols, col.Id)
+	}
+	editable, readOnly, hidden := cols[0], cols[1], cols[2]
+	addTestOrgUser(t, db, org.Id, member, bw.OrgUserUser, bw.SelectionReadOnly{Id: editable}, bw.SelectionReadOnly{Id: readOnly, ReadOnly: true})
+
+	name := "2.name"
+	ciph, err := db.NewCipher(bw.Cipher{Type: 1, Data: bw.CipherData{Name: &name}, OrganizationId: &org.Id}, acc.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(expected ...string) {
+		t.Helper()
+		ciph, err := db.GetCipher(acc.Id, ciph.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(ciph.CollectionIds)
+		sort.Strings(expected)
+		if !reflect.DeepEqual(ciph.CollectionIds, expected) {
+			t.Errorf("Expected collections %v got %v", expected, ciph.CollectionIds)
+		}
+	}
+
+	// Owners can use all collections
+	if err = db.UpdateCipherCollections(acc.Id, org.Id, ciph.Id, []string{editable, readOnly}); err != nil {
+		t.Fatal(err)
+	}
+	check(editable, readOnly)
+
+	// The cipher stays in the collections the member can't edit
+	if err = db.UpdateCipherCollections(member.Id, org.Id, ciph.Id, []string{}); err != nil {
+		t.Fatal(err)
+	}
+	check(readOnly)
+
+	// and they can be sent back unchanged
+	if err = db.UpdateCipherCollections(member.Id, org.Id, ciph.Id, []string{editable, readOnly}); err != nil {
+		t.Fatal(err)
+	}
+	check(editable, readOnly)
+
+	for _, ids := range [][]string{{hidden}, {editable, hidden}} {
+		if err = db.UpdateCipherCollections(member.Id, org.Id, ciph.Id, ids); err == nil {
+			t.Errorf("Member added the cipher to %v", ids)
+		}
+	}
+	check(editable, readOnly)
+
+	if err = db.UpdateCipherCollections(acc.Id, org.Id, ciph.Id, []string{hidden}); err != nil {
+		t.Fatal(err)
+	}
+	check(hidden)
+}
+
+func TestGetUserCollectionsAdmin(t *testing.T) {
+	db, done := newTestDB(t)
+	defer done()
+
+	acc := newTestAccount(t, db, "nobody@example.com")
+	admin := newTestAccount(t, db, "admin@example.com")
+
+	org, err := db.NewOrganization(bw.Organization{Name: "org"}, bw.OrganizationUser{UserId: &acc.Id, Email: acc.Email})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cols []string
+	for _, name := range []string{"2.first", "2.second"} {
+		col, err := db.NewCollection(bw.Collection{OrganizationId: org.Id, Name: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		cols = append(cols, col.Id)
+	}
+
+	// Admins see every collection even if they were only given some of them, like the ciphers in them
+	addTestOrgUser(t, db, org.Id, admin, bw.OrgUserAdmin, bw.SelectionReadOnly{Id: cols[0], ReadOnly: true})
+
+	userCols, err := db.GetUserCollections(admin.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, col := range userCols {
+		if col.ReadOnly {
+			t.Errorf("Collection %s is read only for an admin", col.Id)
+		}
+		ids = append(ids, col.Id)
+	}
+	sort.Strings(ids)
+	sort.Strings(cols)
+	if !reflect.DeepEqual(ids, cols) {
+		t.Errorf("Expected collections %v got %v", cols, ids)
+	}
+}
+
+func TestNewOrganizationCipher(t *testing.T) {
+	db, done := newTestDB(t)
+	defer done()
+
+	acc := newTestAccount(t, db, "nobody@example.com")
+	member := newTestAccount(t, db, "member@example.com")
+
+	org, err := db.NewOrganization(bw.Organization{Name: "org"}, bw.OrganizationUser{UserId: &acc.Id, Email: acc.Email})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cols []string
+	for _, name := range []string{"2.editable", "2.readonly"} {
+		col, err := db.NewCollection(bw.Collection{OrganizationId: org.Id, Name: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		cols = append(cols, col.Id)
+	}
+	editable, readOnly := cols[0], cols[1]
+	addTestOrgUser(t, db, org.Id, member, bw.OrgUserUser, bw.SelectionReadOnly{Id: editable}, bw.SelectionReadOnly{Id: readOnly, ReadOnly: true})
+
+	name := "2.name"
+	ciph := bw.Cipher{Type: 1, Data: bw.CipherData{Name: &name}, OrganizationId: &org.Id}
+	for _, ids := range [][]string{nil, {readOnly}, {editable, readOnly}, {editable, "missing"}} {
+		if _, err = db.NewOrganizationCipher(ciph, member.Id, ids); err == nil {
+			t.Errorf("Member created a cipher in %v", ids)
+		}
+	}
+	if ciphs, err := db.GetCiphers(acc.Id); err != nil || len(ciphs) != 0 {
+		t.Fatalf("Ciphers left by failed creates: %v %v", ciphs, err)
+	}
+
+	created, err := db.NewOrganizationCipher(ciph, member.Id, []string{editable})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := db.GetCipher(acc.Id, created.Id)
+	if err != nil || !reflect.DeepEqual(stored.CollectionIds, []string{editable}) {
+		t.Fatalf("Expected the cipher in %s got %v %v", editable, stored.CollectionIds, err)
+	}
+
+	// Owners can use every collection
+	if _, err = db.NewOrganizationCipher(ciph, acc.Id, []string{editable, readOnly}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -33,9 +33,16 @@ CREATE TABLE IF NOT EXISTS "accounts" (
   tfasecret           TEXT NOT NULL,
   kdf          		 NUMERIC,
   kdfIterations       NUMERIC,
+  kdfMemory           INT,
+  kdfParallelism      INT,
+  securitystamp       TEXT,
+  emailverified       INT,
 PRIMARY KEY(id)
 )`
 
+// The columns GetAccount expects
+const accountCols = "id, name, email, masterPasswordHash, masterPasswordHint, key, refreshtoken, privatekey, pubkey, tfasecret, kdf, kdfIterations, kdfMemory, kdfParallelism, securitystamp, emailverified"
+
 const ciphersTbl = `
 CREATE TABLE IF NOT EXISTS "ciphers" (
   id           INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -44,9 +51,62 @@ CREATE TABLE IF NOT EXISTS "ciphers" (
   data         REAL,
   owner        INT,
   folderid     TEXT,
-  favorite     INT NOT NULL
+  favorite     INT NOT NULL,
+  deleteddate  INT,
+  organizationid TEXT
+)
+`
+
+// Every member has their own folder and favorite for organization ciphers.
+// Personal ciphers keep them in the ciphers table
+const userCiphersTbl = `
+CREATE TABLE IF NOT EXISTS "user_ciphers" (
+  userid       INT NOT NULL,
+  cipherid     INT NOT NULL,
+  folderid     TEXT,
+  favorite     INT NOT NULL,
+PRIMARY KEY(userid, cipherid)
 )
 `
+
+// The columns sqlRowToCipher expects. The folder and favorite of organization ciphers come from user_ciphers (as uc)
+const cipherCols = `c.id, c.type, c.revisiondate, c.data,
+  CASE WHEN c.organizationid IS NULL THEN c.folderid ELSE uc.folderid END,
+  CASE WHEN c.organizationid IS NULL THEN c.favorite ELSE IFNULL(uc.favorite, 0) END,
+  c.deleteddate, c.organizationid`
+
+// cipherAccess limits a query on ciphers (as c) to the ones the user can see, or edit if edit is set.
+// Personal ciphers are accessed through the owner and organization ciphers through the membership.
+// The arguments from accessArgs has to be passed where this is used
+func cipherAccess(edit bool) string {
+	readOnly := ""
+	if edit {
+		readOnly = " AND cu.readonly = 0"
+	}
+
+	return fmt.Sprintf(`((c.organizationid IS NULL AND c.owner = ?) OR
+  c.organizationid IN (SELECT orgid FROM organization_users WHERE userid = ? AND status = %d AND (accessall = 1 OR type IN (%d, %d))) OR
+  c.id IN (SELECT cc.cipherid FROM collection_ciphers cc
+    JOIN collection_users cu ON cu.collectionid = cc.collectionid
+    JOIN organization_users ou ON ou.id = cu.orguserid
+    WHERE ou.userid = ? AND ou.status = %d%s))`, bw.OrgUserConfirmed, bw.OrgUserOwner, bw.OrgUserAdmin, bw.OrgUserConfirmed, readOnly)
+}
+
+func accessArgs(iuser int64) []interface{} {
+	return []interface{}{iuser, iuser, iuser}
+}
+
+// Selects cipherCols and if the user can edit the cipher for all the ciphers the user can see.
+// Needs selectArgs before the arguments used in where
+func selectCiphers(where string) string {
+	return "SELECT " + cipherCols + ", CASE WHEN " + cipherAccess(true) + " THEN 1 ELSE 0 END FROM ciphers c" +
+		" LEFT JOIN user_ciphers uc ON uc.cipherid = c.id AND uc.userid = ? WHERE " + cipherAccess(false) + where
+}
+
+func selectArgs(iuser int64) []interface{} {
+	return append(append(accessArgs(iuser), iuser), accessArgs(iuser)...)
+}
+
 const foldersTbl = `
 CREATE TABLE IF NOT EXISTS "folders" (
   id           TEXT,
@@ -58,7 +118,7 @@ PRIMARY KEY(id)
 `
 
 func (db *DB) Init() error {
-	for _, sql := range []string{acctTbl, ciphersTbl, foldersTbl} {
+	for _, sql := range []string{acctTbl, ciphersTbl, userCiphersTbl, foldersTbl, attachmentsTbl, organizationsTbl, organizationUsersTbl, collectionsTbl, collectionCiphersTbl, collectionUsersTbl, tokensTbl, sendsTbl, emergencyAccessTbl} {
 		if _, err := db.db.Exec(sql); err != nil {
 			return errors.New(fmt.Sprintf("SQL error with %s\n%s", sql, err.Error()))
 		}
@@ -89,18 +149,19 @@ func sqlRowToCipher(row interface {
 }) (bw.Cipher, error) {
 	ciph := bw.Cipher{
 		Favorite:            false,
-		Edit:                true,
+		Edit:                false,
 		OrganizationUseTotp: false,
 		Object:              "cipher",
 		Attachments:         nil,
 		FolderId:            nil,
 	}
 
-	var iid, favorite int
+	var iid, favorite, edit int
 	var revDate int64
 	var blob []byte
-	var folderid sql.NullString
-	err := row.Scan(&iid, &ciph.Type, &revDate, &blob, &folderid, &favorite)
+	var folderid, orgid sql.NullString
+	var delDate sql.NullInt64
+	err := row.Scan(&iid, &ciph.Type, &revDate, &blob, &folderid, &favorite, &delDate, &orgid, &edit)
 	if err != nil {
 		return ciph, err
 	}
@@ -114,11 +175,22 @@ func sqlRowToCipher(row interface {
 		ciph.Favorite = true
 	}
 
+	if edit == 1 {
+		ciph.Edit = true
+	}
+
 	ciph.Id = strconv.Itoa(iid)
 	ciph.RevisionDate = time.Unix(revDate, 0)
 	if folderid.Valid {
 		ciph.FolderId = &folderid.String
 	}
+	if orgid.Valid {
+		ciph.OrganizationId = &orgid.String
+	}
+	if delDate.Valid {
+		d := time.Unix(delDate.Int64, 0)
+		ciph.DeletedDate = &d
+	}
 
 	bw.FakeNewAPI(&ciph)
 
@@ -135,10 +207,29 @@ func (db *DB) GetCipher(owner string, ciphID string) (bw.Cipher, error) {
 		return bw.Cipher{}, err
 	}
 
-	query := "SELECT id, type, revisiondate, data, folderid, favorite FROM ciphers WHERE owner = $1 AND id = $2"
-	row := db.db.QueryRow(query, iowner, iciphID)
+	row := db.db.QueryRow(selectCiphers(" AND c.id = ?"), append(selectArgs(iowner), iciphID)...)
+
+	ciph, err := sqlRowToCipher(row)
+	if err != nil {
+		return ciph, err
+	}
+
+	attachments, err := getAttachments(db.db, "SELECT id, cipherid, filename, key, size FROM attachments WHERE cipherid = $1 AND pendingsince IS NULL", iciphID)
+	if err != nil {
+		return ciph, err
+	}
+	ciph.Attachments = attachments[ciph.Id]
+
+	collectionIDs, err := getCollectionIDs(db.db, "SELECT collectionid, cipherid FROM collection_ciphers WHERE cipherid = $1", iciphID)
+	if err != nil {
+		return ciph, err
+	}
+	ciph.CollectionIds = collectionIDs[ciph.Id]
+	if ciph.CollectionIds == nil {
+		ciph.CollectionIds = make([]string, 0)
+	}
 
-	return sqlRowToCipher(row)
+	return ciph, nil
 }
 
 func (db *DB) GetCiphers(owner string) ([]bw.Cipher, error) {
@@ -148,8 +239,10 @@ func (db *DB) GetCiphers(owner string) ([]bw.Cipher, error) {
 	}
 
 	var ciphers []bw.Cipher
-	query := "SELECT id, type, revisiondate, data, folderid, favorite FROM ciphers WHERE owner = $1"
-	rows, err := db.db.Query(query, iowner)
+	rows, err := db.db.Query(selectCiphers(""), selectArgs(iowner)...)
+	if err != nil {
+		return nil, err
+	}
 
 	for rows.Next() {
 		ciph, err := sqlRowToCipher(rows)
@@ -160,6 +253,26 @@ func (db *DB) GetCiphers(owner string) ([]bw.Cipher, error) {
 		ciphers = append(ciphers, ciph)
 	}
 
+	rows.Close()
+
+	visible := "SELECT c.id FROM ciphers c WHERE " + cipherAccess(false)
+	attachments, err := getAttachments(db.db, "SELECT id, cipherid, filename, key, size FROM attachments WHERE cipherid IN ("+visible+") AND pendingsince IS NULL", accessArgs(iowner)...)
+	if err != nil {
+		return nil, err
+	}
+	collectionIDs, err := getCollectionIDs(db.db, "SELECT collectionid, cipherid FROM collection_ciphers WHERE cipherid IN ("+visible+")", accessArgs(iowner)...)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range ciphers {
+		ciphers[i].Attachments = attachments[ciphers[i].Id]
+		ciphers[i].CollectionIds = collectionIDs[ciphers[i].Id]
+		if ciphers[i].CollectionIds == nil {
+			ciphers[i].CollectionIds = make([]string, 0)
+		}
+	}
+
 	if len(ciphers) < 1 {
 		ciphers = make([]bw.Cipher, 0) // Make an empty slice if there are none or android app will crash
 	}
@@ -172,69 +285,96 @@ func (db *DB) NewCipher(ciph bw.Cipher, owner string) (bw.Cipher, error) {
 		return bw.Cipher{}, err
 	}
 
-	ciph.RevisionDate = time.Now()
+	tx, err := db.db.Begin()
+	if err != nil {
+		return ciph, err
+	}
 
-	stmt, err := db.db.Prepare("INSERT INTO ciphers(type, revisiondate, data, owner,folderid, favorite) values(?,?,?, ?, ?, ?)")
+	_, err = insertCipher(tx, &ciph, iowner)
 	if err != nil {
+		tx.Rollback()
 		return ciph, err
 	}
 
+	return ciph, tx.Commit()
+}
+
+// Stores the new cipher and sets its id and revision date
+func insertCipher(tx *sql.Tx, ciph *bw.Cipher, iowner int64) (int64, error) {
+	ciph.RevisionDate = time.Now()
+
 	data, err := ciph.Data.Bytes()
 	if err != nil {
-		return ciph, err
+		return 0, err
+	}
+
+	// The folder of an organization cipher is only for the user that made it
+	folderID := ciph.FolderId
+	if ciph.OrganizationId != nil {
+		folderID = nil
 	}
 
-	res, err := stmt.Exec(ciph.Type, ciph.RevisionDate.Unix(), data, iowner, ciph.FolderId, 0)
+	res, err := tx.Exec("INSERT INTO ciphers(type, revisiondate, data, owner, folderid, favorite, organizationid) values(?,?,?,?,?,?,?)", ciph.Type, ciph.RevisionDate.Unix(), data, iowner, folderID, 0, ciph.OrganizationId)
 	if err != nil {
-		return ciph, err
+		return 0, err
 	}
 
 	lID, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
 	ciph.Id = fmt.Sprintf("%v", lID)
 
-	bw.FakeNewAPI(&ciph)
+	if ciph.OrganizationId != nil && ciph.FolderId != nil {
+		_, err = tx.Exec("INSERT INTO user_ciphers(userid, cipherid, folderid, favorite) values(?,?,?,?)", iowner, lID, ciph.FolderId, 0)
+		if err != nil {
+			return 0, err
+		}
+	}
 
-	return ciph, nil
+	bw.FakeNewAPI(ciph)
 
+	return lID, nil
 }
 
-// Important to check that the owner is correct before an update!
-func (db *DB) UpdateCipher(newData bw.Cipher, owner string, ciphID string) error {
-	iowner, err := strconv.ParseInt(owner, 10, 64)
+// Sets the folder or favorite of the cipher for the user. Personal ciphers have them in the ciphers table,
+// organization ciphers in user_ciphers. Only view access is needed since nobody else sees the change
+func setUserCipher(tx *sql.Tx, iuser int64, iciphID int64, set string, args ...interface{}) error {
+	res, err := tx.Exec("UPDATE ciphers SET "+set+", revisiondate=? WHERE id=? AND owner=? AND organizationid IS NULL",
+		append(args, time.Now().Unix(), iciphID, iuser)...)
 	if err != nil {
 		return err
 	}
 
-	iciphID, err := strconv.ParseInt(ciphID, 10, 64)
+	n, err := res.RowsAffected()
 	if err != nil {
 		return err
 	}
-
-	favorite := 0
-	if newData.Favorite {
-		favorite = 1
+	if n == 1 {
+		return nil
 	}
 
-	stmt, err := db.db.Prepare("UPDATE ciphers SET type=$1, revisiondate=$2, data=$3, folderid=$4, favorite=$5 WHERE id=$6 AND owner=$7")
+	var visible int
+	err = tx.QueryRow("SELECT COUNT(*) FROM ciphers c WHERE c.id = ? AND c.organizationid IS NOT NULL AND "+cipherAccess(false),
+		append([]interface{}{iciphID}, accessArgs(iuser)...)...).Scan(&visible)
 	if err != nil {
 		return err
 	}
-
-	bdata, err := newData.Data.Bytes()
-	if err != nil {
-		return err
+	if visible != 1 {
+		return fmt.Errorf("Cipher %d not found", iciphID)
 	}
 
-	_, err = stmt.Exec(newData.Type, time.Now().Unix(), bdata, newData.FolderId, favorite, iciphID, iowner)
+	_, err = tx.Exec("INSERT OR IGNORE INTO user_ciphers(userid, cipherid, favorite) values(?,?,?)", iuser, iciphID, 0)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	_, err = tx.Exec("UPDATE user_ciphers SET "+set+" WHERE userid=? AND cipherid=?", append(args, iuser, iciphID)...)
+	return err
 }
 
 // Important to check that the owner is correct before an update!
-func (db *DB) DeleteCipher(owner string, ciphID string) error {
+func (db *DB) UpdateCipher(newData bw.Cipher, owner string, ciphID string) error {
 	iowner, err := strconv.ParseInt(owner, 10, 64)
 	if err != nil {
 		return err
@@ -245,155 +385,1005 @@ func (db *DB) DeleteCipher(owner string, ciphID string) error {
 		return err
 	}
 
-	stmt, err := db.db.Prepare("DELETE from ciphers WHERE id=$1 AND owner=$2")
+	favorite := 0
+	if newData.Favorite {
+		favorite = 1
+	}
+
+	bdata, err := newData.Data.Bytes()
 	if err != nil {
 		return err
 	}
 
-	_, err = stmt.Exec(iciphID, iowner)
+	tx, err := db.db.Begin()
 	if err != nil {
 		return err
 	}
-	return nil
-}
 
-func (db *DB) AddAccount(acc bw.Account) error {
-	stmt, err := db.db.Prepare("INSERT INTO accounts(name, email, masterPasswordHash, masterPasswordHint, key, refreshtoken, privatekey, pubkey, tfasecret, kdf, kdfIterations) values(?,?,?,?,?,?,?,?,?,?,?)")
+	args := []interface{}{newData.Type, time.Now().Unix(), bdata, iciphID}
+	res, err := tx.Exec("UPDATE ciphers SET type=?, revisiondate=?, data=? WHERE id=? AND id IN (SELECT c.id FROM ciphers c WHERE "+cipherAccess(true)+")", append(args, accessArgs(iowner)...)...)
 	if err != nil {
+		tx.Rollback()
 		return err
 	}
 
-	_, err = stmt.Exec(acc.Name, acc.Email, acc.MasterPasswordHash, acc.MasterPasswordHint, acc.Key, "", "", "", "", acc.Kdf, acc.KdfIterations)
+	n, err := res.RowsAffected()
+	if err != nil || n != 1 {
+		tx.Rollback()
+		return errors.New("Cipher " + ciphID + " not found")
+	}
+
+	err = setUserCipher(tx, iowner, iciphID, "folderid=?, favorite=?", newData.FolderId, favorite)
 	if err != nil {
+		tx.Rollback()
 		return err
 	}
 
-	return nil
+	return tx.Commit()
 }
 
-func (db *DB) UpdateAccountInfo(acc bw.Account) error {
-	id, err := strconv.ParseInt(acc.Id, 10, 64)
+// Important to check that the owner is correct before an update!
+func (db *DB) DeleteCipher(owner string, ciphID string) error {
+	_, err := db.DeleteCiphers(owner, []string{ciphID})
+	return err
+}
+
+// DeleteCiphers permanently deletes the ciphers in a single transaction.
+// Returns the deleted attachments so the data can be removed
+func (db *DB) DeleteCiphers(owner string, ciphIDs []string) ([]bw.Attachment, error) {
+	iowner, err := strconv.ParseInt(owner, 10, 64)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	stmt, err := db.db.Prepare("UPDATE accounts SET refreshtoken=$1, privatekey=$2, pubkey=$3 WHERE id=$4")
+	iciphIDs, err := parseIDs(ciphIDs)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	_, err = stmt.Exec(acc.RefreshToken, acc.KeyPair.EncryptedPrivateKey, acc.KeyPair.PublicKey, id)
+	tx, err := db.db.Begin()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
-}
+	var atts []bw.Attachment
+	for _, id := range iciphIDs {
+		res, err := tx.Exec("DELETE from ciphers WHERE id=? AND id IN (SELECT c.id FROM ciphers c WHERE "+cipherAccess(true)+")", append([]interface{}{id}, accessArgs(iowner)...)...)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
 
-func (db *DB) GetAccount(username string, refreshtoken string) (bw.Account, error) {
-	var row *sql.Row
-	acc := bw.Account{}
-	acc.KeyPair = bw.KeyPair{}
-	if username != "" {
-		query := "SELECT * FROM accounts WHERE email = $1"
-		row = db.db.QueryRow(query, username)
+		// All or nothing. Don't skip ciphers that belong to someone else
+		n, err := res.RowsAffected()
+		if err != nil || n != 1 {
+			tx.Rollback()
+			return nil, fmt.Errorf("Cipher %d not found", id)
+		}
+
+		attachments, err := getAttachments(tx, "SELECT id, cipherid, filename, key, size FROM attachments WHERE cipherid = $1", id)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		atts = append(atts, attachments[strconv.FormatInt(id, 10)]...)
+
+		_, err = tx.Exec("DELETE from attachments WHERE cipherid=$1", id)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+
+		for _, query := range []string{
+			"DELETE from collection_ciphers WHERE cipherid=$1",
+			"DELETE from user_ciphers WHERE cipherid=$1",
+		} {
+			_, err = tx.Exec(query, id)
+			if err != nil {
+				tx.Rollback()
+				return nil, err
+			}
+		}
 	}
 
-	if refreshtoken != "" {
-		query := "SELECT * FROM accounts WHERE refreshtoken = $1"
-		row = db.db.QueryRow(query, refreshtoken)
+	return atts, tx.Commit()
+}
+
+// Convert ids from the client to the ids used in the database
+func parseIDs(ids []string) ([]int64, error) {
+	iids := make([]int64, len(ids))
+	for i, id := range ids {
+		iid, err := strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		iids[i] = iid
 	}
 
-	var iid int
-	err := row.Scan(&iid, &acc.Name, &acc.Email, &acc.MasterPasswordHash, &acc.MasterPasswordHint, &acc.Key, &acc.RefreshToken, &acc.KeyPair.EncryptedPrivateKey, &acc.KeyPair.PublicKey, &acc.TwoFactorSecret, &acc.Kdf, &acc.KdfIterations)
-	if err != nil {
-		return acc, err
+	return iids, nil
+}
+
+// Moves the ciphers to the trash
+func (db *DB) SoftDeleteCiphers(owner string, ciphIDs []string) error {
+	now := time.Now().Unix()
+	return db.setDeletedDate(owner, ciphIDs, &now)
+}
+
+// Moves the ciphers out of the trash
+func (db *DB) RestoreCiphers(owner string, ciphIDs []string) error {
+	return db.setDeletedDate(owner, ciphIDs, nil)
+}
+
+func (db *DB) setDeletedDate(owner string, ciphIDs []string, date *int64) error {
+	return db.updateCiphers(owner, ciphIDs, nil, "deleteddate=?", date)
+}
+
+// Sets the folder of the ciphers. A nil folder removes them from their folder
+func (db *DB) MoveCiphers(owner string, ciphIDs []string, folderID *string) error {
+	check := func(tx *sql.Tx, iowner int64) error {
+		if folderID == nil {
+			return nil
+		}
+
+		var n int
+		err := tx.QueryRow("SELECT COUNT(*) FROM folders WHERE id = $1 AND owner = $2", *folderID, iowner).Scan(&n)
+		if err != nil {
+			return err
+		}
+		if n != 1 {
+			return errors.New("Folder " + *folderID + " not found")
+		}
+		return nil
 	}
 
-	acc.Id = strconv.Itoa(iid)
+	return db.updateUserCiphers(owner, ciphIDs, check, "folderid=?", folderID)
+}
 
-	return acc, nil
+func (db *DB) FavoriteCiphers(owner string, ciphIDs []string, favorite bool) error {
+	ifavorite := 0
+	if favorite {
+		ifavorite = 1
+	}
+
+	return db.updateUserCiphers(owner, ciphIDs, nil, "favorite=?", ifavorite)
 }
 
-func (db *DB) AddFolder(name string, owner string) (bw.Folder, error) {
+// Updates the ciphers in a single transaction. check is run inside the transaction before the update if set.
+// Important to check that the owner is correct before an update!
+func (db *DB) updateCiphers(owner string, ciphIDs []string, check func(tx *sql.Tx, iowner int64) error, set string, value interface{}) error {
 	iowner, err := strconv.ParseInt(owner, 10, 64)
 	if err != nil {
-		return bw.Folder{}, err
+		return err
 	}
 
-	newFolderID, err := uuid.NewV4()
+	iciphIDs, err := parseIDs(ciphIDs)
 	if err != nil {
-		return bw.Folder{}, err
+		return err
 	}
 
-	folder := bw.Folder{
-		Id:           newFolderID.String(),
-		Name:         name,
-		Object:       "folder",
-		RevisionDate: time.Now(),
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
 	}
 
-	stmt, err := db.db.Prepare("INSERT INTO folders(id, name, revisiondate, owner) values(?,?,?, ?)")
-	if err != nil {
-		return bw.Folder{}, err
+	if check != nil {
+		err = check(tx, iowner)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
 	}
 
-	_, err = stmt.Exec(folder.Id, folder.Name, folder.RevisionDate.Unix(), iowner)
+	stmt, err := tx.Prepare("UPDATE ciphers SET " + set + ", revisiondate=? WHERE id=? AND id IN (SELECT c.id FROM ciphers c WHERE " + cipherAccess(true) + ")")
 	if err != nil {
-		return bw.Folder{}, err
+		tx.Rollback()
+		return err
 	}
+	defer stmt.Close()
 
-	return folder, nil
+	for _, id := range iciphIDs {
+		args := []interface{}{value, time.Now().Unix(), id}
+		res, err := stmt.Exec(append(args, accessArgs(iowner)...)...)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		// All or nothing. Don't skip ciphers that belong to someone else
+		n, err := res.RowsAffected()
+		if err != nil || n != 1 {
+			tx.Rollback()
+			return fmt.Errorf("Cipher %d not found", id)
+		}
+	}
+
+	return tx.Commit()
 }
 
-func (db *DB) UpdateFolder(newFolder bw.Folder, owner string) error {
+// Like updateCiphers, but for the user's own folder or favorite of the ciphers
+func (db *DB) updateUserCiphers(owner string, ciphIDs []string, check func(tx *sql.Tx, iowner int64) error, set string, value interface{}) error {
 	iowner, err := strconv.ParseInt(owner, 10, 64)
 	if err != nil {
 		return err
 	}
 
-	stmt, err := db.db.Prepare("UPDATE folders SET name=$1, revisiondate=$2 WHERE id=$3 AND owner=$4")
+	iciphIDs, err := parseIDs(ciphIDs)
 	if err != nil {
 		return err
 	}
 
-	_, err = stmt.Exec(newFolder.Name, newFolder.RevisionDate.Unix(), newFolder.Id, iowner)
+	tx, err := db.db.Begin()
 	if err != nil {
 		return err
 	}
 
-	return nil
+	if check != nil {
+		err = check(tx, iowner)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	for _, id := range iciphIDs {
+		err = setUserCipher(tx, iowner, id, set, value)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
 }
 
-func (db *DB) GetFolders(owner string) ([]bw.Folder, error) {
-	iowner, err := strconv.ParseInt(owner, 10, 64)
+// PurgeDeletedCiphers permanently deletes all ciphers moved to the trash before the given time.
+// Returns the deleted attachments so the data can be removed
+func (db *DB) PurgeDeletedCiphers(before time.Time) ([]bw.Attachment, error) {
+	tx, err := db.db.Begin()
 	if err != nil {
 		return nil, err
 	}
 
-	var folders []bw.Folder
-	query := "SELECT id, name, revisiondate FROM folders WHERE owner = $1"
-	rows, err := db.db.Query(query, iowner)
+	purged := "SELECT id FROM ciphers WHERE deleteddate IS NOT NULL AND deleteddate < $1"
+	attachments, err := getAttachments(tx, "SELECT id, cipherid, filename, key, size FROM attachments WHERE cipherid IN ("+purged+")", before.Unix())
 	if err != nil {
+		tx.Rollback()
 		return nil, err
 	}
 
-	var revDate int64
-	for rows.Next() {
-		f := bw.Folder{}
-		err := rows.Scan(&f.Id, &f.Name, &revDate)
+	for _, query := range []string{
+		"DELETE FROM attachments WHERE cipherid IN (" + purged + ")",
+		"DELETE FROM collection_ciphers WHERE cipherid IN (" + purged + ")",
+		"DELETE FROM user_ciphers WHERE cipherid IN (" + purged + ")",
+	} {
+		_, err = tx.Exec(query, before.Unix())
 		if err != nil {
+			tx.Rollback()
 			return nil, err
 		}
-		f.RevisionDate = time.Unix(revDate, 0)
+	}
 
-		folders = append(folders, f)
+	_, err = tx.Exec("DELETE FROM ciphers WHERE deleteddate IS NOT NULL AND deleteddate < $1", before.Unix())
+	if err != nil {
+		tx.Rollback()
+		return nil, err
 	}
 
-	if len(folders) < 1 {
-		folders = make([]bw.Folder, 0) // Make an empty slice if there are none or android app will crash
+	var atts []bw.Attachment
+	for _, a := range attachments {
+		atts = append(atts, a...)
 	}
-	return folders, err
+
+	return atts, tx.Commit()
+}
+
+// ShareCiphers moves personal ciphers into the organization in a single transaction.
+// The ciphers must be re-encrypted with the organization key by the client.
+// Attachments on the ciphers get their new file name and key
+func (db *DB) ShareCiphers(owner string, orgID string, ciphers []bw.Cipher, collectionIDs []string) error {
+	iowner, err := strconv.ParseInt(owner, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, ciph := range ciphers {
+		iciphID, err := strconv.ParseInt(ciph.Id, 10, 64)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		bdata, err := ciph.Data.Bytes()
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		// Only the owner can share a cipher and it can only be shared once
+		res, err := tx.Exec("UPDATE ciphers SET type=$1, data=$2, organizationid=$3, revisiondate=$4 WHERE id=$5 AND owner=$6 AND organizationid IS NULL",
+			ciph.Type, bdata, orgID, time.Now().Unix(), iciphID, iowner)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil || n != 1 {
+			tx.Rollback()
+			return errors.New("Cipher " + ciph.Id + " not found")
+		}
+
+		// The owner keeps the folder and favorite as a member
+		_, err = tx.Exec("INSERT INTO user_ciphers(userid, cipherid, folderid, favorite) SELECT owner, id, folderid, favorite FROM ciphers WHERE id = $1", iciphID)
+		if err == nil {
+			_, err = tx.Exec("UPDATE ciphers SET folderid=NULL, favorite=0 WHERE id = $1", iciphID)
+		}
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		for _, att := range ciph.Attachments {
+			_, err = tx.Exec("UPDATE attachments SET filename=$1, key=$2 WHERE id=$3 AND cipherid=$4", att.FileName, att.Key, att.Id, iciphID)
+			if err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+
+		for _, colID := range collectionIDs {
+			res, err := tx.Exec("INSERT INTO collection_ciphers(collectionid, cipherid) SELECT id, $1 FROM collections WHERE id = $2 AND orgid = $3", iciphID, colID, orgID)
+			if err != nil {
+				tx.Rollback()
+				return err
+			}
+
+			n, err := res.RowsAffected()
+			if err != nil || n != 1 {
+				tx.Rollback()
+				return errors.New("Collection " + colID + " not found")
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (db *DB) AddAccount(acc bw.Account) error {
+	stmt, err := db.db.Prepare("INSERT INTO accounts(name, email, masterPasswordHash, masterPasswordHint, key, refreshtoken, privatekey, pubkey, tfasecret, kdf, kdfIterations, kdfMemory, kdfParallelism, securitystamp) values(?,?,?,?,?,?,?,?,?,?,?,?,?,?)")
+	if err != nil {
+		return err
+	}
+
+	_, err = stmt.Exec(acc.Name, acc.Email, acc.MasterPasswordHash, acc.MasterPasswordHint, acc.Key, "", "", "", "", acc.Kdf, acc.KdfIterations, acc.KdfMemory, acc.KdfParallelism, acc.SecurityStamp)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (db *DB) UpdateAccountInfo(acc bw.Account) error {
+	id, err := strconv.ParseInt(acc.Id, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	stmt, err := db.db.Prepare("UPDATE accounts SET refreshtoken=$1, privatekey=$2, pubkey=$3 WHERE id=$4")
+	if err != nil {
+		return err
+	}
+
+	_, err = stmt.Exec(acc.RefreshToken, acc.KeyPair.EncryptedPrivateKey, acc.KeyPair.PublicKey, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// UpdateMasterPassword stores the password hash, the key encrypted with the new master key and the kdf settings
+func (db *DB) UpdateMasterPassword(acc bw.Account) error {
+	id, err := strconv.ParseInt(acc.Id, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	stmt, err := db.db.Prepare("UPDATE accounts SET masterPasswordHash=$1, key=$2, refreshtoken=$3, kdf=$4, kdfIterations=$5, kdfMemory=$6, kdfParallelism=$7, securitystamp=$8 WHERE id=$9")
+	if err != nil {
+		return err
+	}
+
+	_, err = stmt.Exec(acc.MasterPasswordHash, acc.Key, acc.RefreshToken, acc.Kdf, acc.KdfIterations, acc.KdfMemory, acc.KdfParallelism, acc.SecurityStamp, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// UpdateEmail changes the email of the account. The password hash and key have to be
+// updated at the same time because the email is used as the salt
+func (db *DB) UpdateEmail(acc bw.Account) error {
+	id, err := strconv.ParseInt(acc.Id, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE accounts SET email=$1, masterPasswordHash=$2, key=$3, refreshtoken=$4, securitystamp=$5, emailverified=1 WHERE id=$6", acc.Email, acc.MasterPasswordHash, acc.Key, acc.RefreshToken, acc.SecurityStamp, id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE organization_users SET email=$1 WHERE userid=$2", acc.Email, id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (db *DB) GetAccount(username string, refreshtoken string) (bw.Account, error) {
+	var row *sql.Row
+	acc := bw.Account{}
+	acc.KeyPair = bw.KeyPair{}
+	if username != "" {
+		query := "SELECT " + accountCols + " FROM accounts WHERE email = $1"
+		row = db.db.QueryRow(query, username)
+	}
+
+	if refreshtoken != "" {
+		query := "SELECT " + accountCols + " FROM accounts WHERE refreshtoken = $1"
+		row = db.db.QueryRow(query, refreshtoken)
+	}
+
+	var iid int
+	var kdfMemory, kdfParallelism sql.NullInt64
+	var securityStamp sql.NullString
+	var emailVerified sql.NullInt64
+	err := row.Scan(&iid, &acc.Name, &acc.Email, &acc.MasterPasswordHash, &acc.MasterPasswordHint, &acc.Key, &acc.RefreshToken, &acc.KeyPair.EncryptedPrivateKey, &acc.KeyPair.PublicKey, &acc.TwoFactorSecret, &acc.Kdf, &acc.KdfIterations, &kdfMemory, &kdfParallelism, &securityStamp, &emailVerified)
+	if err != nil {
+		return acc, err
+	}
+
+	acc.Id = strconv.Itoa(iid)
+	acc.SecurityStamp = securityStamp.String
+	acc.EmailVerified = emailVerified.Int64 == 1
+	if kdfMemory.Valid {
+		m := int(kdfMemory.Int64)
+		acc.KdfMemory = &m
+	}
+	if kdfParallelism.Valid {
+		p := int(kdfParallelism.Int64)
+		acc.KdfParallelism = &p
+	}
+
+	return acc, nil
+}
+
+// GetPublicKey returns the public key of the account. Used to encrypt organization keys for the user
+func (db *DB) GetPublicKey(userID string) (string, error) {
+	iuserID, err := strconv.ParseInt(userID, 10, 64)
+	if err != nil {
+		return "", err
+	}
+
+	var pubKey string
+	err = db.db.QueryRow("SELECT pubkey FROM accounts WHERE id = $1", iuserID).Scan(&pubKey)
+	return pubKey, err
+}
+
+func (db *DB) AddFolder(name string, owner string) (bw.Folder, error) {
+	iowner, err := strconv.ParseInt(owner, 10, 64)
+	if err != nil {
+		return bw.Folder{}, err
+	}
+
+	newFolderID, err := uuid.NewV4()
+	if err != nil {
+		return bw.Folder{}, err
+	}
+
+	folder := bw.Folder{
+		Id:           newFolderID.String(),
+		Name:         name,
+		Object:       "folder",
+		RevisionDate: time.Now(),
+	}
+
+	stmt, err := db.db.Prepare("INSERT INTO folders(id, name, revisiondate, owner) values(?,?,?, ?)")
+	if err != nil {
+		return bw.Folder{}, err
+	}
+
+	_, err = stmt.Exec(folder.Id, folder.Name, folder.RevisionDate.Unix(), iowner)
+	if err != nil {
+		return bw.Folder{}, err
+	}
+
+	return folder, nil
+}
+
+func (db *DB) UpdateFolder(newFolder bw.Folder, owner string) error {
+	iowner, err := strconv.ParseInt(owner, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	stmt, err := db.db.Prepare("UPDATE folders SET name=$1, revisiondate=$2 WHERE id=$3 AND owner=$4")
+	if err != nil {
+		return err
+	}
+
+	_, err = stmt.Exec(newFolder.Name, newFolder.RevisionDate.Unix(), newFolder.Id, iowner)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// RotateKeys replaces the user key, the private key, all personal ciphers and folders and the keys of the sends
+// in a single transaction. All of them has to be re-encrypted so nothing is left with the old key.
+// All clients are logged out by storing the new security stamp of acc and removing the refresh token
+func (db *DB) RotateKeys(acc bw.Account, ciphers []bw.Cipher, folders []bw.Folder, sends []bw.Send) error {
+	iowner, err := strconv.ParseInt(acc.Id, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	// Organization ciphers are encrypted with the organization key and are not rotated
+	ciphIDs := make([]string, len(ciphers))
+	for i, ciph := range ciphers {
+		ciphIDs[i] = ciph.Id
+	}
+	err = checkAllIDs(tx, "SELECT id FROM ciphers WHERE owner = $1 AND organizationid IS NULL", iowner, ciphIDs)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	folderIDs := make([]string, len(folders))
+	for i, folder := range folders {
+		folderIDs[i] = folder.Id
+	}
+	err = checkAllIDs(tx, "SELECT id FROM folders WHERE owner = $1", iowner, folderIDs)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	sendIDs := make([]string, len(sends))
+	for i, send := range sends {
+		sendIDs[i] = send.Id
+	}
+	err = checkAllIDs(tx, "SELECT id FROM sends WHERE userid = $1 AND pendingsince IS NULL", iowner, sendIDs)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// The client doesn't know about the pending file sends, so their keys can't be rotated
+	_, err = tx.Exec("DELETE FROM sends WHERE userid = $1 AND pendingsince IS NOT NULL", iowner)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// The attachments with a key are decrypted with it, so all those keys have to be re-encrypted as well
+	var attIDs []string
+	for _, ciph := range ciphers {
+		for _, att := range ciph.Attachments {
+			if att.Key != nil {
+				attIDs = append(attIDs, att.Id)
+			}
+		}
+	}
+	err = checkAllIDs(tx, `SELECT a.id FROM attachments a JOIN ciphers c ON c.id = a.cipherid
+  WHERE c.owner = $1 AND c.organizationid IS NULL AND a.key IS NOT NULL AND a.pendingsince IS NULL`, iowner, attIDs)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	now := time.Now().Unix()
+	for _, ciph := range ciphers {
+		bdata, err := ciph.Data.Bytes()
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		_, err = tx.Exec("UPDATE ciphers SET data=$1, revisiondate=$2 WHERE id=$3 AND owner=$4", bdata, now, ciph.Id, iowner)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		for _, att := range ciph.Attachments {
+			_, err = tx.Exec("UPDATE attachments SET filename=$1, key=$2 WHERE id=$3 AND cipherid=$4", att.FileName, att.Key, att.Id, ciph.Id)
+			if err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+	}
+
+	for _, folder := range folders {
+		_, err = tx.Exec("UPDATE folders SET name=$1, revisiondate=$2 WHERE id=$3 AND owner=$4", folder.Name, now, folder.Id, iowner)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	for _, send := range sends {
+		_, err = tx.Exec("UPDATE sends SET key=$1, revisiondate=$2 WHERE id=$3 AND userid=$4", send.Key, now, send.Id, iowner)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	// The emergency contacts only have the old key. The grantor has to confirm them again
+	_, err = tx.Exec("UPDATE emergency_access SET status=$1, keyencrypted=NULL, recoveryinitiated=NULL, revisiondate=$2 WHERE grantorid=$3 AND status IN ($4, $5, $6)",
+		bw.EmergencyAccessAccepted, now, iowner, bw.EmergencyAccessConfirmed, bw.EmergencyAccessRecoveryInitiated, bw.EmergencyAccessRecoveryApproved)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE accounts SET key=$1, privatekey=$2, securitystamp=$3, refreshtoken=$4 WHERE id=$5", acc.Key, acc.KeyPair.EncryptedPrivateKey, acc.SecurityStamp, "", iowner)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Deletes the ciphers, attachments and folders of the user. Organization ciphers are kept
+func deletePersonalVault(tx *sql.Tx, iuser int64) ([]bw.Attachment, error) {
+	personal := "SELECT id FROM ciphers WHERE owner = $1 AND organizationid IS NULL"
+	attachments, err := getAttachments(tx, "SELECT id, cipherid, filename, key, size FROM attachments WHERE cipherid IN ("+personal+")", iuser)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, query := range []string{
+		"DELETE FROM attachments WHERE cipherid IN (" + personal + ")",
+		"DELETE FROM collection_ciphers WHERE cipherid IN (" + personal + ")",
+		"DELETE FROM ciphers WHERE owner = $1 AND organizationid IS NULL",
+		"DELETE FROM folders WHERE owner = $1",
+		"UPDATE user_ciphers SET folderid = NULL WHERE userid = $1",
+	} {
+		_, err = tx.Exec(query, iuser)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var atts []bw.Attachment
+	for _, a := range attachments {
+		atts = append(atts, a...)
+	}
+
+	return atts, nil
+}
+
+// PurgeVault deletes all personal ciphers and folders but keeps the account.
+// Returns the deleted attachments so the data can be removed
+func (db *DB) PurgeVault(userID string) ([]bw.Attachment, error) {
+	iuser, err := strconv.ParseInt(userID, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	atts, err := deletePersonalVault(tx, iuser)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	return atts, tx.Commit()
+}
+
+// DeleteAccount deletes the account with its vault and memberships in a single transaction.
+// Returns the deleted attachments so the data can be removed
+func (db *DB) DeleteAccount(userID string) ([]bw.Attachment, error) {
+	iuser, err := strconv.ParseInt(userID, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	// Never leave an organization without an owner
+	var soleOwner int
+	err = tx.QueryRow(`SELECT COUNT(*) FROM organization_users ou WHERE ou.userid = $1 AND ou.type = $2 AND ou.status = $3
+  AND NOT EXISTS (SELECT 1 FROM organization_users o WHERE o.orgid = ou.orgid AND o.id != ou.id AND o.type = $2 AND o.status = $3)`,
+		iuser, bw.OrgUserOwner, bw.OrgUserConfirmed).Scan(&soleOwner)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if soleOwner > 0 {
+		tx.Rollback()
+		return nil, errors.New("The account is the only owner of an organization")
+	}
+
+	atts, err := deletePersonalVault(tx, iuser)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	for _, query := range []string{
+		"DELETE FROM collection_users WHERE orguserid IN (SELECT id FROM organization_users WHERE userid = $1)",
+		"DELETE FROM organization_users WHERE userid = $1",
+		"DELETE FROM user_ciphers WHERE userid = $1",
+		"DELETE FROM tokens WHERE userid = $1",
+		"DELETE FROM sends WHERE userid = $1",
+		"DELETE FROM emergency_access WHERE grantorid = $1 OR granteeid = $1",
+		"DELETE FROM accounts WHERE id = $1",
+	} {
+		_, err = tx.Exec(query, iuser)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	return atts, tx.Commit()
+}
+
+// Makes sure ids contains exactly the ids the query returns
+func checkAllIDs(tx *sql.Tx, query string, owner int64, ids []string) error {
+	rows, err := tx.Query(query, owner)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	sent := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		sent[id] = true
+	}
+
+	n := 0
+	for rows.Next() {
+		var id string
+		err = rows.Scan(&id)
+		if err != nil {
+			return err
+		}
+		if !sent[id] {
+			return errors.New("Missing " + id + ", everything has to be re-encrypted")
+		}
+		n++
+	}
+
+	if n != len(sent) || len(sent) != len(ids) {
+		return errors.New("Unknown or duplicate ids, everything has to be re-encrypted")
+	}
+
+	return rows.Err()
+}
+
+// Import creates the folders and ciphers in a single transaction.
+// cipherFolders maps the index of a cipher to the index of the folder it should be in
+func (db *DB) Import(owner string, folders []string, ciphers []bw.Cipher, cipherFolders map[int]int) error {
+	iowner, err := strconv.ParseInt(owner, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+	folderIDs := make([]string, len(folders))
+	for i, name := range folders {
+		newFolderID, err := uuid.NewV4()
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		folderIDs[i] = newFolderID.String()
+
+		_, err = tx.Exec("INSERT INTO folders(id, name, revisiondate, owner) values(?,?,?,?)", folderIDs[i], name, now, iowner)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	for i, ciph := range ciphers {
+		// Only the imported folders can be used
+		ciph.FolderId = nil
+		if f, ok := cipherFolders[i]; ok {
+			ciph.FolderId = &folderIDs[f]
+		}
+
+		favorite := 0
+		if ciph.Favorite {
+			favorite = 1
+		}
+
+		data, err := ciph.Data.Bytes()
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		_, err = tx.Exec("INSERT INTO ciphers(type, revisiondate, data, owner, folderid, favorite) values(?,?,?,?,?,?)", ciph.Type, now, data, iowner, ciph.FolderId, favorite)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (db *DB) GetFolder(owner string, folderID string) (bw.Folder, error) {
+	iowner, err := strconv.ParseInt(owner, 10, 64)
+	if err != nil {
+		return bw.Folder{}, err
+	}
+
+	f := bw.Folder{Object: "folder"}
+	var revDate int64
+	query := "SELECT id, name, revisiondate FROM folders WHERE owner = $1 AND id = $2"
+	err = db.db.QueryRow(query, iowner, folderID).Scan(&f.Id, &f.Name, &revDate)
+	if err != nil {
+		return bw.Folder{}, err
+	}
+	f.RevisionDate = time.Unix(revDate, 0)
+
+	return f, nil
+}
+
+// DeleteFolder deletes the folder and removes all the owner's ciphers from it
+func (db *DB) DeleteFolder(owner string, folderID string) error {
+	iowner, err := strconv.ParseInt(owner, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	res, err := tx.Exec("DELETE FROM folders WHERE id=$1 AND owner=$2", folderID, iowner)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil || n != 1 {
+		tx.Rollback()
+		return errors.New("Folder " + folderID + " not found")
+	}
+
+	_, err = tx.Exec("UPDATE ciphers SET folderid=NULL, revisiondate=$1 WHERE folderid=$2 AND owner=$3", time.Now().Unix(), folderID, iowner)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE user_ciphers SET folderid=NULL WHERE folderid=$1 AND userid=$2", folderID, iowner)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (db *DB) GetFolders(owner string) ([]bw.Folder, error) {
+	iowner, err := strconv.ParseInt(owner, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	var folders []bw.Folder
+	query := "SELECT id, name, revisiondate FROM folders WHERE owner = $1"
+	rows, err := db.db.Query(query, iowner)
+	if err != nil {
+		return nil, err
+	}
+
+	var revDate int64
+	for rows.Next() {
+		f := bw.Folder{}
+		err := rows.Scan(&f.Id, &f.Name, &revDate)
+		if err != nil {
+			return nil, err
+		}
+		f.RevisionDate = time.Unix(revDate, 0)
+
+		folders = append(folders, f)
+	}
+
+	if len(folders) < 1 {
+		folders = make([]bw.Folder, 0) // Make an empty slice if there are none or android app will crash
+	}
+	return folders, err
+}
+
+// VerifyEmail marks the email as verified. Fails if the account has changed email since the token was sent
+func (db *DB) VerifyEmail(userID string, email string) error {
+	id, err := strconv.ParseInt(userID, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	stmt, err := db.db.Prepare("UPDATE accounts SET emailverified=1 WHERE id=$1 AND email=$2")
+	if err != nil {
+		return err
+	}
+
+	res, err := stmt.Exec(id, email)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil || n != 1 {
+		return errors.New("The email of account " + userID + " has changed")
+	}
+
+	return nil
+}
+
+// UpdateSecurityStamp changes the security stamp and removes the refresh token to log out all clients
+func (db *DB) UpdateSecurityStamp(userID string, stamp string) error {
+	id, err := strconv.ParseInt(userID, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	stmt, err := db.db.Prepare("UPDATE accounts SET securitystamp=$1, refreshtoken=$2 WHERE id=$3")
+	if err != nil {
+		return err
+	}
+
+	_, err = stmt.Exec(stamp, "", id)
+	if err != nil {
+		return err
+	}
+
+	return nil
 }
 
 func (db *DB) Update2FAsecret(secret string, email string) error {
@@ -0,0 +1,363 @@
+package sqlite
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	bw "github.com/VictorNine/bitwarden-go/internal/common"
+)
+
+// Opens an empty database in a temporary directory
+func newTestDB(t *testing.T) (*DB, func()) {
+	dir, err := ioutil.TempDir("", "bitwarden")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := &DB{}
+	db.SetDir(dir)
+	err = db.Open()
+	if err == nil {
+		err = db.Init()
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	return db, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// Adds an account and returns it with its id
+func newTestAccount(t *testing.T, db *DB, email string) bw.Account {
+	err := db.AddAccount(bw.Account{Email: email, Key: "2.key"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	acc, err := db.GetAccount(email, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return acc
+}
+
+func TestRotateKeys(t *testing.T) {
+	db, done := newTestDB(t)
+	defer done()
+
+	acc := newTestAccount(t, db, "nobody@example.com")
+	other := newTestAccount(t, db, "other@example.com")
+
+	name, orgID := "2.name", "1"
+	personal, err := db.NewCipher(bw.Cipher{Type: 1, Data: bw.CipherData{Name: &name}}, acc.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shared, err := db.NewCipher(bw.Cipher{Type: 1, Data: bw.CipherData{Name: &name}, OrganizationId: &orgID}, acc.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	others, err := db.NewCipher(bw.Cipher{Type: 1, Data: bw.CipherData{Name: &name}}, other.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	folder, err := db.AddFolder("2.folder", acc.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	send, err := db.NewSend(bw.Send{UserId: acc.Id, Key: "2.sendkey", Name: "2.send", DeletionDate: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	othersSend, err := db.NewSend(bw.Send{UserId: other.Id, Key: "2.sendkey", Name: "2.send", DeletionDate: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The client doesn't know about the file send that is waiting for its file
+	pendingSend, err := db.NewSend(bw.Send{UserId: acc.Id, Type: bw.SendTypeFile, Key: "2.sendkey", Name: "2.send",
+		File: &bw.SendFile{FileName: "2.file"}, DeletionDate: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ea, err := db.NewEmergencyAccess(bw.EmergencyAccess{GrantorId: acc.Id, Email: other.Email, Type: bw.EmergencyAccessView, WaitTimeDays: 7}, "token")
+	if err == nil {
+		err = db.AcceptEmergencyAccess(ea.Id, other.Id, "token")
+	}
+	if err == nil {
+		err = db.ConfirmEmergencyAccess(ea.Id, "4.oldkey")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	attKey := "2.attkey"
+	att, err := db.NewAttachment(bw.Attachment{CipherId: personal.Id, FileName: "2.file"}, acc.Id, 4)
+	if err == nil {
+		err = db.UpdateAttachment(acc.Id, att.Id, &attKey, 4)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The attachment key is left out
+	withoutAttachment := personal
+	newName := "2.new"
+	personal.Data.Name = &newName
+	newAttKey := "2.newattkey"
+	att.Key = &newAttKey
+	personal.Attachments = []bw.Attachment{att}
+	folder.Name = "2.newfolder"
+	send.Key = "2.newsendkey"
+	acc.Key = "2.newkey"
+
+	for _, tc := range []struct {
+		ciphers []bw.Cipher
+		folders []bw.Folder
+		sends   []bw.Send
+	}{
+		{nil, []bw.Folder{folder}, []bw.Send{send}},
+		{[]bw.Cipher{personal}, nil, []bw.Send{send}},
+		{[]bw.Cipher{personal}, []bw.Folder{folder}, nil},
+		{[]bw.Cipher{personal, personal}, []bw.Folder{folder}, []bw.Send{send}},
+		{[]bw.Cipher{personal, shared}, []bw.Folder{folder}, []bw.Send{send}},
+		{[]bw.Cipher{personal, others}, []bw.Folder{folder}, []bw.Send{send}},
+		{[]bw.Cipher{personal}, []bw.Folder{folder}, []bw.Send{send, othersSend}},
+		{[]bw.Cipher{withoutAttachment}, []bw.Folder{folder}, []bw.Send{send}},
+	} {
+		if err := db.RotateKeys(acc, tc.ciphers, tc.folders, tc.sends); err == nil {
+			t.Errorf("Rotation with %v ciphers, %v folders and %v sends not rejected", len(tc.ciphers), len(tc.folders), len(tc.sends))
+		}
+	}
+	if stored, _ := db.GetAccount(acc.Email, ""); stored.Key != "2.key" {
+		t.Fatal("Key changed by a failed rotation")
+	}
+
+	err = db.RotateKeys(acc, []bw.Cipher{personal}, []bw.Folder{folder}, []bw.Send{send})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stored, _ := db.GetAccount(acc.Email, ""); stored.Key != "2.newkey" {
+		t.Error("Key not updated")
+	}
+	if ciph, err := db.GetCipher(acc.Id, personal.Id); err != nil || *ciph.Data.Name != newName {
+		t.Errorf("Cipher not updated: %v", err)
+	}
+	if f, err := db.GetFolder(acc.Id, folder.Id); err != nil || f.Name != "2.newfolder" {
+		t.Errorf("Folder not updated: %v", err)
+	}
+	if a, err := db.GetAttachment(personal.Id, att.Id); err != nil || a.Key == nil || *a.Key != newAttKey {
+		t.Errorf("Attachment key not updated: %v", err)
+	}
+	if s, err := db.GetSend(acc.Id, send.Id); err != nil || s.Key != "2.newsendkey" {
+		t.Errorf("Send key not updated: %v", err)
+	}
+	if ea, err := db.GetEmergencyAccess(ea.Id); err != nil || ea.Status != bw.EmergencyAccessAccepted || ea.KeyEncrypted != nil {
+		t.Errorf("Emergency access still confirmed with the old key: %v", err)
+	}
+	if _, err := db.GetSend(acc.Id, pendingSend.Id); err == nil {
+		t.Error("Pending send with the old key not deleted")
+	}
+}
+
+// Adds the account to the organization as a confirmed member of the given type
+func addTestOrgUser(t *testing.T, db *DB, orgID string, acc bw.Account, userType int, collections ...bw.SelectionReadOnly) bw.OrganizationUser {
+	ou, err := db.InviteOrganizationUser(bw.OrganizationUser{OrganizationId: orgID, Email: acc.Email, Type: userType}, "token", collections)
+	if err == nil {
+		err = db.AcceptOrganizationUser(orgID, ou.Id, acc.Id, "token")
+	}
+	if err == nil {
+		err = db.ConfirmOrganizationUser(orgID, ou.Id, "4.orgkey")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ou, err = db.GetOrganizationUser(orgID, acc.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ou
+}
+
+func TestDeleteAccountSoleOwner(t *testing.T) {
+	db, done := newTestDB(t)
+	defer done()
+
+	acc := newTestAccount(t, db, "nobody@example.com")
+	other := newTestAccount(t, db, "other@example.com")
+
+	org, err := db.NewOrganization(bw.Organization{Name: "org"}, bw.OrganizationUser{UserId: &acc.Id, Email: acc.Email})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addTestOrgUser(t, db, org.Id, other, bw.OrgUserAdmin)
+
+	// An admin is not enough
+	if _, err = db.DeleteAccount(acc.Id); err == nil {
+		t.Fatal("Sole owner deleted")
+	}
+	if _, err = db.GetAccount(acc.Email, ""); err != nil {
+		t.Fatal("Account deleted by a failed request")
+	}
+
+	// Others can leave
+	if _, err = db.DeleteAccount(other.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	addTestOrgUser(t, db, org.Id, newTestAccount(t, db, "owner@example.com"), bw.OrgUserOwner)
+	if _, err = db.DeleteAccount(acc.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.GetAccount(acc.Email, ""); err == nil {
+		t.Error("Account not deleted")
+	}
+	if _, err = db.GetOrganizationUser(org.Id, acc.Id); err == nil {
+		t.Error("Organization membership not deleted")
+	}
+}
+
+func TestImportFolders(t *testing.T) {
+	db, done := newTestDB(t)
+	defer done()
+
+	acc := newTestAccount(t, db, "nobody@example.com")
+	other := newTestAccount(t, db, "other@example.com")
+	othersFolder, err := db.AddFolder("2.folder", other.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The folder sent with a cipher is ignored, only the folder relationships are used
+	name := "2.name"
+	ciphers := []bw.Cipher{
+		{Type: 1, Data: bw.CipherData{Name: &name}, FolderId: &othersFolder.Id},
+		{Type: 1, Data: bw.CipherData{Name: &name}, FolderId: &othersFolder.Id},
+	}
+	err = db.Import(acc.Id, []string{"2.imported"}, ciphers, map[int]int{1: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ciphs, err := db.GetCiphers(acc.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	folders, err := db.GetFolders(acc.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ciphs) != 2 || len(folders) != 1 {
+		t.Fatalf("Expected 2 ciphers and 1 folder got %d and %d", len(ciphs), len(folders))
+	}
+
+	inFolder := 0
+	for _, ciph := range ciphs {
+		if ciph.FolderId != nil {
+			if *ciph.FolderId != folders[0].Id {
+				t.Errorf("Cipher %s imported to folder %s", ciph.Id, *ciph.FolderId)
+			}
+			inFolder++
+		}
+	}
+	if inFolder != 1 {
+		t.Errorf("Expected 1 cipher in the imported folder got %d", inFolder)
+	}
+}
+
+func TestOrganizationFolderAndFavorite(t *testing.T) {
+	db, done := newTestDB(t)
+	defer done()
+
+	acc := newTestAccount(t, db, "nobody@example.com")
+	member := newTestAccount(t, db, "member@example.com")
+	outsider := newTestAccount(t, db, "outsider@example.com")
+
+	org, err := db.NewOrganization(bw.Organization{Name: "org"}, bw.OrganizationUser{UserId: &acc.Id, Email: acc.Email})
+	if err != nil {
+		t.Fatal(err)
+	}
+	col, err := db.NewCollection(bw.Collection{OrganizationId: org.Id, Name: "2.col"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addTestOrgUser(t, db, org.Id, member, bw.OrgUserUser, bw.SelectionReadOnly{Id: col.Id, ReadOnly: true})
+
+	accFolder, err := db.AddFolder("2.folder", acc.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	memberFolder, err := db.AddFolder("2.folder", member.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The folder and favorite are kept when the cipher is shared
+	name := "2.name"
+	ciph, err := db.NewCipher(bw.Cipher{Type: 1, Data: bw.CipherData{Name: &name}, FolderId: &accFolder.Id}, acc.Id)
+	if err == nil {
+		err = db.FavoriteCiphers(acc.Id, []string{ciph.Id}, true)
+	}
+	if err == nil {
+		err = db.ShareCiphers(acc.Id, org.Id, []bw.Cipher{ciph}, []string{col.Id})
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(userID string, folderID *string, favorite bool) {
+		t.Helper()
+		ciph, err := db.GetCipher(userID, ciph.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (ciph.FolderId == nil) != (folderID == nil) || (folderID != nil && *ciph.FolderId != *folderID) || ciph.Favorite != favorite {
+			t.Errorf("User %s: expected folder %v and favorite %v got %v and %v", userID, folderID, favorite, ciph.FolderId, ciph.Favorite)
+		}
+	}
+	check(acc.Id, &accFolder.Id, true)
+	check(member.Id, nil, false)
+
+	// Members with read only access have their own folder and favorite
+	err = db.MoveCiphers(member.Id, []string{ciph.Id}, &memberFolder.Id)
+	if err == nil {
+		err = db.FavoriteCiphers(member.Id, []string{ciph.Id}, true)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = db.FavoriteCiphers(acc.Id, []string{ciph.Id}, false); err != nil {
+		t.Fatal(err)
+	}
+	check(acc.Id, &accFolder.Id, false)
+	check(member.Id, &memberFolder.Id, true)
+
+	if err = db.FavoriteCiphers(outsider.Id, []string{ciph.Id}, true); err == nil {
+		t.Error("Favorite set by a user that can't see the cipher")
+	}
+	if err = db.UpdateCipher(ciph, member.Id, ciph.Id); err == nil {
+		t.Error("Cipher updated with read only access")
+	}
+
+	// Updating the cipher doesn't change the folder of the other members
+	ciph.FolderId = nil
+	if err = db.UpdateCipher(ciph, acc.Id, ciph.Id); err != nil {
+		t.Fatal(err)
+	}
+	check(acc.Id, nil, false)
+	check(member.Id, &memberFolder.Id, true)
+
+	if err = db.DeleteFolder(member.Id, memberFolder.Id); err != nil {
+		t.Fatal(err)
+	}
+	check(member.Id, nil, true)
+}
@@ -0,0 +1,352 @@
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	bw "github.com/VictorNine/bitwarden-go/internal/common"
+	uuid "github.com/satori/go.uuid"
+)
+
+const emergencyAccessTbl = `
+CREATE TABLE IF NOT EXISTS "emergency_access" (
+  id                TEXT,
+  grantorid         INTEGER,
+  granteeid         INTEGER,
+  email             TEXT,
+  keyencrypted      TEXT,
+  type              INT,
+  status            INT,
+  waittimedays      INT,
+  recoveryinitiated INT,
+  token             TEXT,
+  creationdate      INT,
+  revisiondate      INT,
+PRIMARY KEY(id)
+)
+`
+
+// The columns sqlRowToEmergencyAccess expects
+const emergencyAccessCols = `ea.id, ea.grantorid, ea.granteeid, ea.email, ea.keyencrypted, ea.type, ea.status, ea.waittimedays, ea.recoveryinitiated, ea.creationdate,
+  gr.name, gr.email, ge.name, ge.email FROM emergency_access ea JOIN accounts gr ON gr.id = ea.grantorid LEFT JOIN accounts ge ON ge.id = ea.granteeid`
+
+func sqlRowToEmergencyAccess(row interface {
+	Scan(dest ...interface{}) error
+}) (bw.EmergencyAccess, error) {
+	ea := bw.EmergencyAccess{}
+
+	var grantorID int64
+	var granteeID, initiated sql.NullInt64
+	var key, grantorName, granteeName, granteeEmail sql.NullString
+	var created int64
+	err := row.Scan(&ea.Id, &grantorID, &granteeID, &ea.Email, &key, &ea.Type, &ea.Status, &ea.WaitTimeDays, &initiated, &created,
+		&grantorName, &ea.GrantorEmail, &granteeName, &granteeEmail)
+	if err != nil {
+		return ea, err
+	}
+
+	ea.GrantorId = strconv.FormatInt(grantorID, 10)
+	ea.CreationDate = time.Unix(created, 0)
+	if granteeID.Valid {
+		id := strconv.FormatInt(granteeID.Int64, 10)
+		ea.GranteeId = &id
+	}
+	if key.Valid {
+		ea.KeyEncrypted = &key.String
+	}
+	if initiated.Valid {
+		d := time.Unix(initiated.Int64, 0)
+		ea.RecoveryInitiatedDate = &d
+	}
+	if grantorName.Valid {
+		ea.GrantorName = &grantorName.String
+	}
+	if granteeName.Valid {
+		ea.GranteeName = &granteeName.String
+	}
+	if granteeEmail.Valid {
+		ea.GranteeEmail = &granteeEmail.String
+	}
+
+	return ea, nil
+}
+
+func getEmergencyAccesses(q interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}, query string, args ...interface{}) ([]bw.EmergencyAccess, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	accesses := make([]bw.EmergencyAccess, 0) // Make an empty slice if there are none or android app will crash
+	for rows.Next() {
+		ea, err := sqlRowToEmergencyAccess(rows)
+		if err != nil {
+			return nil, err
+		}
+		accesses = append(accesses, ea)
+	}
+
+	return accesses, rows.Err()
+}
+
+// NewEmergencyAccess creates the invitation. The grantee accepts it with the token
+func (db *DB) NewEmergencyAccess(ea bw.EmergencyAccess, token string) (bw.EmergencyAccess, error) {
+	igrantor, err := strconv.ParseInt(ea.GrantorId, 10, 64)
+	if err != nil {
+		return bw.EmergencyAccess{}, err
+	}
+
+	newID, err := uuid.NewV4()
+	if err != nil {
+		return bw.EmergencyAccess{}, err
+	}
+
+	tx, err := db.db.Begin()
+	if err != nil {
+		return bw.EmergencyAccess{}, err
+	}
+
+	var existing int
+	err = tx.QueryRow(`SELECT COUNT(*) FROM emergency_access ea LEFT JOIN accounts ge ON ge.id = ea.granteeid
+  WHERE ea.grantorid = $1 AND (lower(ea.email) = lower($2) OR lower(ge.email) = lower($2))`, igrantor, ea.Email).Scan(&existing)
+	if err != nil {
+		tx.Rollback()
+		return bw.EmergencyAccess{}, err
+	}
+	if existing > 0 {
+		tx.Rollback()
+		return bw.EmergencyAccess{}, errors.New(ea.Email + " already has emergency access")
+	}
+
+	now := time.Now()
+	_, err = tx.Exec("INSERT INTO emergency_access(id, grantorid, email, type, status, waittimedays, token, creationdate, revisiondate) values(?,?,?,?,?,?,?,?,?)",
+		newID.String(), igrantor, ea.Email, ea.Type, bw.EmergencyAccessInvited, ea.WaitTimeDays, token, now.Unix(), now.Unix())
+	if err != nil {
+		tx.Rollback()
+		return bw.EmergencyAccess{}, err
+	}
+
+	ea.Id = newID.String()
+	ea.Status = bw.EmergencyAccessInvited
+	ea.CreationDate = time.Unix(now.Unix(), 0)
+
+	return ea, tx.Commit()
+}
+
+func (db *DB) GetEmergencyAccess(id string) (bw.EmergencyAccess, error) {
+	row := db.db.QueryRow("SELECT "+emergencyAccessCols+" WHERE ea.id = $1", id)
+	return sqlRowToEmergencyAccess(row)
+}
+
+// GetEmergencyAccessGrantees returns the emergency accesses the user has given to others
+func (db *DB) GetEmergencyAccessGrantees(grantorID string) ([]bw.EmergencyAccess, error) {
+	igrantor, err := strconv.ParseInt(grantorID, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	return getEmergencyAccesses(db.db, "SELECT "+emergencyAccessCols+" WHERE ea.grantorid = $1", igrantor)
+}
+
+// GetEmergencyAccessGrantors returns the emergency accesses the user has been given
+func (db *DB) GetEmergencyAccessGrantors(granteeID string) ([]bw.EmergencyAccess, error) {
+	igrantee, err := strconv.ParseInt(granteeID, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	return getEmergencyAccesses(db.db, "SELECT "+emergencyAccessCols+" WHERE ea.granteeid = $1", igrantee)
+}
+
+// ReinviteEmergencyAccess replaces the token of an invitation that hasn't been accepted
+func (db *DB) ReinviteEmergencyAccess(id string, token string) error {
+	res, err := db.db.Exec("UPDATE emergency_access SET token=$1, revisiondate=$2 WHERE id=$3 AND status=$4", token, time.Now().Unix(), id, bw.EmergencyAccessInvited)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil || n != 1 {
+		return errors.New("Emergency access " + id + " has already been accepted")
+	}
+
+	return nil
+}
+
+func (db *DB) AcceptEmergencyAccess(id string, granteeID string, token string) error {
+	igrantee, err := strconv.ParseInt(granteeID, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	stmt, err := db.db.Prepare("UPDATE emergency_access SET granteeid=$1, status=$2, token=NULL, revisiondate=$3 WHERE id=$4 AND status=$5 AND token=$6")
+	if err != nil {
+		return err
+	}
+
+	res, err := stmt.Exec(igrantee, bw.EmergencyAccessAccepted, time.Now().Unix(), id, bw.EmergencyAccessInvited, token)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil || n != 1 {
+		return errors.New("Invalid emergency access invitation " + id)
+	}
+
+	return nil
+}
+
+// ConfirmEmergencyAccess stores the key of the grantor encrypted with the public key of the grantee.
+// Important to check that the user is allowed to make changes!
+func (db *DB) ConfirmEmergencyAccess(id string, key string) error {
+	stmt, err := db.db.Prepare("UPDATE emergency_access SET keyencrypted=$1, status=$2, revisiondate=$3 WHERE id=$4 AND status=$5")
+	if err != nil {
+		return err
+	}
+
+	res, err := stmt.Exec(key, bw.EmergencyAccessConfirmed, time.Now().Unix(), id, bw.EmergencyAccessAccepted)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil || n != 1 {
+		return errors.New("Emergency access " + id + " has not been accepted")
+	}
+
+	return nil
+}
+
+// Important to check that the user is allowed to make changes!
+func (db *DB) UpdateEmergencyAccess(id string, accessType int, waitTimeDays int) error {
+	stmt, err := db.db.Prepare("UPDATE emergency_access SET type=$1, waittimedays=$2, revisiondate=$3 WHERE id=$4")
+	if err != nil {
+		return err
+	}
+
+	res, err := stmt.Exec(accessType, waitTimeDays, time.Now().Unix(), id)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil || n != 1 {
+		return errors.New("Emergency access " + id + " not found")
+	}
+
+	return nil
+}
+
+// UpdateEmergencyAccessStatus changes the status if it's one of from. The wait time starts
+// when the recovery is initiated. Important to check that the user is allowed to make changes!
+func (db *DB) UpdateEmergencyAccessStatus(id string, to int, from ...int) error {
+	now := time.Now().Unix()
+	query := "UPDATE emergency_access SET status=?, revisiondate=?"
+	args := []interface{}{to, now}
+	if to == bw.EmergencyAccessRecoveryInitiated {
+		query += ", recoveryinitiated=?"
+		args = append(args, now)
+	}
+
+	query += " WHERE id=? AND status IN (?" + strings.Repeat(",?", len(from)-1) + ")"
+	args = append(args, id)
+	for _, status := range from {
+		args = append(args, status)
+	}
+
+	res, err := db.db.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil || n != 1 {
+		return errors.New("Emergency access " + id + " can't change status to " + strconv.Itoa(to))
+	}
+
+	return nil
+}
+
+// Important to check that the user is allowed to make changes!
+func (db *DB) DeleteEmergencyAccess(id string) error {
+	res, err := db.db.Exec("DELETE FROM emergency_access WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil || n != 1 {
+		return errors.New("Emergency access " + id + " not found")
+	}
+
+	return nil
+}
+
+// ApproveEmergencyAccesses approves the recoveries that the grantors haven't rejected within the wait time.
+// Returns the approved emergency accesses so the users can be told
+func (db *DB) ApproveEmergencyAccesses(now time.Time) ([]bw.EmergencyAccess, error) {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	accesses, err := getEmergencyAccesses(tx, "SELECT "+emergencyAccessCols+" WHERE ea.status = $1 AND ea.recoveryinitiated + ea.waittimedays * 86400 <= $2",
+		bw.EmergencyAccessRecoveryInitiated, now.Unix())
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	for i := range accesses {
+		_, err = tx.Exec("UPDATE emergency_access SET status=$1, revisiondate=$2 WHERE id=$3", bw.EmergencyAccessRecoveryApproved, now.Unix(), accesses[i].Id)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		accesses[i].Status = bw.EmergencyAccessRecoveryApproved
+	}
+
+	return accesses, tx.Commit()
+}
+
+// TakeoverAccount sets the new master password of the grantor. The two-step login and the
+// memberships of organizations the grantor doesn't own are removed at the same time
+func (db *DB) TakeoverAccount(acc bw.Account) error {
+	iuser, err := strconv.ParseInt(acc.Id, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE accounts SET masterPasswordHash=$1, key=$2, refreshtoken=$3, kdf=$4, kdfIterations=$5, kdfMemory=$6, kdfParallelism=$7, securitystamp=$8, tfasecret=$9 WHERE id=$10",
+		acc.MasterPasswordHash, acc.Key, acc.RefreshToken, acc.Kdf, acc.KdfIterations, acc.KdfMemory, acc.KdfParallelism, acc.SecurityStamp, "", iuser)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for _, query := range []string{
+		"DELETE FROM user_ciphers WHERE userid = $1 AND cipherid IN (SELECT id FROM ciphers WHERE organizationid IN (SELECT orgid FROM organization_users WHERE userid = $1 AND type != $2))",
+		"DELETE FROM collection_users WHERE orguserid IN (SELECT id FROM organization_users WHERE userid = $1 AND type != $2)",
+		"DELETE FROM organization_users WHERE userid = $1 AND type != $2",
+	} {
+		_, err = tx.Exec(query, iuser, bw.OrgUserOwner)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
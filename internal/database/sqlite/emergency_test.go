@@ -0,0 +1,150 @@
+package sqlite
+
+import (
+	"testing"
+	"time"
+
+	bw "github.com/VictorNine/bitwarden-go/internal/common"
+)
+
+// Adds a confirmed emergency access from the grantor to the grantee
+func addTestEmergencyAccess(t *testing.T, db *DB, grantor bw.Account, grantee bw.Account, waitTimeDays int) bw.EmergencyAccess {
+	ea, err := db.NewEmergencyAccess(bw.EmergencyAccess{GrantorId: grantor.Id, Email: grantee.Email, Type: bw.EmergencyAccessView, WaitTimeDays: waitTimeDays}, "token")
+	if err == nil {
+		err = db.AcceptEmergencyAccess(ea.Id, grantee.Id, "token")
+	}
+	if err == nil {
+		err = db.ConfirmEmergencyAccess(ea.Id, "4.key")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ea
+}
+
+func TestApproveEmergencyAccesses(t *testing.T) {
+	db, done := newTestDB(t)
+	defer done()
+
+	acc := newTestAccount(t, db, "nobody@example.com")
+	short := addTestEmergencyAccess(t, db, acc, newTestAccount(t, db, "short@example.com"), 1)
+	long := addTestEmergencyAccess(t, db, acc, newTestAccount(t, db, "long@example.com"), 7)
+	idle := addTestEmergencyAccess(t, db, acc, newTestAccount(t, db, "idle@example.com"), 1)
+	for _, ea := range []bw.EmergencyAccess{short, long} {
+		err := db.UpdateEmergencyAccessStatus(ea.Id, bw.EmergencyAccessRecoveryInitiated, bw.EmergencyAccessConfirmed)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	approve := func(after time.Duration, expected ...bw.EmergencyAccess) {
+		t.Helper()
+		accesses, err := db.ApproveEmergencyAccesses(time.Now().Add(after))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(accesses) != len(expected) {
+			t.Fatalf("After %v: expected %d approved got %d", after, len(expected), len(accesses))
+		}
+		for i, ea := range expected {
+			if accesses[i].Id != ea.Id || accesses[i].Status != bw.EmergencyAccessRecoveryApproved {
+				t.Errorf("After %v: expected %s approved got %v", after, ea.Id, accesses[i])
+			}
+		}
+	}
+
+	// The wait time starts when the recovery is initiated
+	approve(0)
+	approve(23 * time.Hour)
+	approve(25*time.Hour, short)
+	approve(6*24*time.Hour + 23*time.Hour)
+	approve(7*24*time.Hour+time.Hour, long)
+
+	for _, ea := range []bw.EmergencyAccess{short, long, idle} {
+		stored, err := db.GetEmergencyAccess(ea.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (ea.Id == idle.Id) != (stored.Status == bw.EmergencyAccessConfirmed) {
+			t.Errorf("Emergency access %s has status %d", ea.Id, stored.Status)
+		}
+	}
+}
+
+func TestTakeoverAccount(t *testing.T) {
+	db, done := newTestDB(t)
+	defer done()
+
+	acc := newTestAccount(t, db, "nobody@example.com")
+	other := newTestAccount(t, db, "other@example.com")
+
+	owned, err := db.NewOrganization(bw.Organization{Name: "owned"}, bw.OrganizationUser{UserId: &acc.Id, Email: acc.Email})
+	if err != nil {
+		t.Fatal(err)
+	}
+	org, err := db.NewOrganization(bw.Organization{Name: "org"}, bw.OrganizationUser{UserId: &other.Id, Email: other.Email})
+	if err != nil {
+		t.Fatal(err)
+	}
+	col, err := db.NewCollection(bw.Collection{OrganizationId: org.Id, Name: "2.col"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ou := addTestOrgUser(t, db, org.Id, acc, bw.OrgUserAdmin, bw.SelectionReadOnly{Id: col.Id})
+
+	name := "2.name"
+	ciph, err := db.NewCipher(bw.Cipher{Type: 1, Data: bw.CipherData{Name: &name}, OrganizationId: &org.Id}, other.Id)
+	if err == nil {
+		err = db.UpdateCipherCollections(other.Id, org.Id, ciph.Id, []string{col.Id})
+	}
+	if err == nil {
+		err = db.FavoriteCiphers(acc.Id, []string{ciph.Id}, true)
+	}
+	if err == nil {
+		err = db.Update2FAsecret("secret", acc.Email)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	acc.MasterPasswordHash = "newhash"
+	acc.Key = "2.newkey"
+	err = db.TakeoverAccount(acc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := db.GetAccount(acc.Email, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.MasterPasswordHash != "newhash" || stored.Key != "2.newkey" {
+		t.Error("Master password not updated")
+	}
+	if stored.TwoFactorSecret != "" {
+		t.Error("Two-step login not removed")
+	}
+
+	// Only the organizations the user owns are kept
+	if _, err = db.GetOrganizationUser(owned.Id, acc.Id); err != nil {
+		t.Errorf("Owner removed from organization: %v", err)
+	}
+	if _, err = db.GetOrganizationUser(org.Id, acc.Id); err == nil {
+		t.Error("Admin not removed from organization")
+	}
+	users, err := db.GetCollectionUsers(col.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, u := range users {
+		if u.Id == ou.Id {
+			t.Error("Collection access not removed")
+		}
+	}
+	var userCiphers int
+	err = db.db.QueryRow("SELECT COUNT(*) FROM user_ciphers WHERE cipherid = $1", ciph.Id).Scan(&userCiphers)
+	if err != nil || userCiphers != 0 {
+		t.Errorf("Favorite of the organization cipher not removed: %v", err)
+	}
+}
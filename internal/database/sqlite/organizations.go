@@ -0,0 +1,453 @@
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"time"
+
+	bw "github.com/VictorNine/bitwarden-go/internal/common"
+	uuid "github.com/satori/go.uuid"
+)
+
+const organizationsTbl = `
+CREATE TABLE IF NOT EXISTS "organizations" (
+  id           TEXT,
+  name         TEXT,
+  businessname TEXT,
+  billingemail TEXT,
+  plantype     INT,
+  pubkey       TEXT NOT NULL,
+  privatekey   TEXT NOT NULL,
+  revisiondate INT,
+PRIMARY KEY(id)
+)
+`
+
+const organizationUsersTbl = `
+CREATE TABLE IF NOT EXISTS "organization_users" (
+  id           TEXT,
+  orgid        TEXT,
+  userid       INTEGER,
+  email        TEXT,
+  key          TEXT,
+  status       INT,
+  type         INT,
+  accessall    INT NOT NULL,
+  token        TEXT,
+PRIMARY KEY(id)
+)
+`
+
+// The columns sqlRowToOrgUser expects
+const orgUserCols = "ou.id, ou.orgid, ou.userid, a.name, ou.email, ou.key, ou.status, ou.type, ou.accessall FROM organization_users ou LEFT JOIN accounts a ON a.id = ou.userid"
+
+func sqlRowToOrganization(row interface {
+	Scan(dest ...interface{}) error
+}) (bw.Organization, error) {
+	// Self hosted organizations have everything enabled and no limits
+	org := bw.Organization{
+		Plan:     "Free",
+		UseTotp:  true,
+		Use2fa:   true,
+		SelfHost: true,
+		Enabled:  true,
+		Object:   "organization",
+	}
+
+	var businessName sql.NullString
+	err := row.Scan(&org.Id, &org.Name, &businessName, &org.BillingEmail, &org.PlanType, &org.Keys.PublicKey, &org.Keys.EncryptedPrivateKey)
+	if err != nil {
+		return org, err
+	}
+
+	if businessName.Valid {
+		org.BusinessName = &businessName.String
+	}
+
+	return org, nil
+}
+
+func sqlRowToOrgUser(row interface {
+	Scan(dest ...interface{}) error
+}) (bw.OrganizationUser, error) {
+	ou := bw.OrganizationUser{
+		Object: "organizationUserUserDetails",
+	}
+
+	var userID sql.NullInt64
+	var name, key sql.NullString
+	var accessAll int
+	err := row.Scan(&ou.Id, &ou.OrganizationId, &userID, &name, &ou.Email, &key, &ou.Status, &ou.Type, &accessAll)
+	if err != nil {
+		return ou, err
+	}
+
+	if userID.Valid {
+		id := strconv.FormatInt(userID.Int64, 10)
+		ou.UserId = &id
+	}
+	if name.Valid {
+		ou.Name = &name.String
+	}
+	ou.Key = key.String
+	ou.AccessAll = accessAll == 1
+
+	return ou, nil
+}
+
+// NewOrganization creates the organization with owner as a confirmed member
+func (db *DB) NewOrganization(org bw.Organization, owner bw.OrganizationUser) (bw.Organization, error) {
+	iowner, err := strconv.ParseInt(*owner.UserId, 10, 64)
+	if err != nil {
+		return bw.Organization{}, err
+	}
+
+	orgID, err := uuid.NewV4()
+	if err != nil {
+		return bw.Organization{}, err
+	}
+
+	orgUserID, err := uuid.NewV4()
+	if err != nil {
+		return bw.Organization{}, err
+	}
+
+	tx, err := db.db.Begin()
+	if err != nil {
+		return bw.Organization{}, err
+	}
+
+	_, err = tx.Exec("INSERT INTO organizations(id, name, businessname, billingemail, plantype, pubkey, privatekey, revisiondate) values(?,?,?,?,?,?,?,?)",
+		orgID.String(), org.Name, org.BusinessName, org.BillingEmail, org.PlanType, org.Keys.PublicKey, org.Keys.EncryptedPrivateKey, time.Now().Unix())
+	if err != nil {
+		tx.Rollback()
+		return bw.Organization{}, err
+	}
+
+	_, err = tx.Exec("INSERT INTO organization_users(id, orgid, userid, email, key, status, type, accessall) values(?,?,?,?,?,?,?,?)",
+		orgUserID.String(), orgID.String(), iowner, owner.Email, owner.Key, bw.OrgUserConfirmed, bw.OrgUserOwner, 1)
+	if err != nil {
+		tx.Rollback()
+		return bw.Organization{}, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return bw.Organization{}, err
+	}
+
+	return db.GetOrganization(orgID.String())
+}
+
+func (db *DB) GetOrganization(orgID string) (bw.Organization, error) {
+	query := "SELECT id, name, businessname, billingemail, plantype, pubkey, privatekey FROM organizations WHERE id = $1"
+	row := db.db.QueryRow(query, orgID)
+
+	return sqlRowToOrganization(row)
+}
+
+// Important to check that the user is allowed to make changes!
+func (db *DB) UpdateOrganization(org bw.Organization) error {
+	stmt, err := db.db.Prepare("UPDATE organizations SET name=$1, businessname=$2, billingemail=$3, revisiondate=$4 WHERE id=$5")
+	if err != nil {
+		return err
+	}
+
+	_, err = stmt.Exec(org.Name, org.BusinessName, org.BillingEmail, time.Now().Unix(), org.Id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Important to check that the user is allowed to make changes!
+func (db *DB) UpdateOrganizationKeys(orgID string, keys bw.KeyPair) error {
+	stmt, err := db.db.Prepare("UPDATE organizations SET pubkey=$1, privatekey=$2, revisiondate=$3 WHERE id=$4")
+	if err != nil {
+		return err
+	}
+
+	_, err = stmt.Exec(keys.PublicKey, keys.EncryptedPrivateKey, time.Now().Unix(), orgID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DeleteOrganization deletes the organization, its members and all its ciphers.
+// Returns the deleted attachments so the data can be removed
+func (db *DB) DeleteOrganization(orgID string) ([]bw.Attachment, error) {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	orgCiphers := "SELECT id FROM ciphers WHERE organizationid = $1"
+	attachments, err := getAttachments(tx, "SELECT id, cipherid, filename, key, size FROM attachments WHERE cipherid IN ("+orgCiphers+")", orgID)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	for _, query := range []string{
+		"DELETE FROM attachments WHERE cipherid IN (" + orgCiphers + ")",
+		"DELETE FROM collection_ciphers WHERE cipherid IN (" + orgCiphers + ")",
+		"DELETE FROM user_ciphers WHERE cipherid IN (" + orgCiphers + ")",
+		"DELETE FROM ciphers WHERE organizationid = $1",
+		"DELETE FROM collection_users WHERE collectionid IN (SELECT id FROM collections WHERE orgid = $1)",
+		"DELETE FROM collections WHERE orgid = $1",
+		"DELETE FROM organization_users WHERE orgid = $1",
+		"DELETE FROM organizations WHERE id = $1",
+	} {
+		_, err = tx.Exec(query, orgID)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	var atts []bw.Attachment
+	for _, a := range attachments {
+		atts = append(atts, a...)
+	}
+
+	return atts, tx.Commit()
+}
+
+// GetOrganizationUser looks up the membership of an account in the organization
+func (db *DB) GetOrganizationUser(orgID string, userID string) (bw.OrganizationUser, error) {
+	iuserID, err := strconv.ParseInt(userID, 10, 64)
+	if err != nil {
+		return bw.OrganizationUser{}, err
+	}
+
+	query := "SELECT " + orgUserCols + " WHERE ou.orgid = $1 AND ou.userid = $2"
+	row := db.db.QueryRow(query, orgID, iuserID)
+
+	return sqlRowToOrgUser(row)
+}
+
+// Important to check that the user is allowed to make changes!
+func (db *DB) DeleteOrganizationUser(orgID string, orgUserID string) error {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	// The member's own folders and favorites of the organization ciphers
+	_, err = tx.Exec("DELETE FROM user_ciphers WHERE userid = (SELECT userid FROM organization_users WHERE orgid = $1 AND id = $2) AND cipherid IN (SELECT id FROM ciphers WHERE organizationid = $1)", orgID, orgUserID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	res, err := tx.Exec("DELETE FROM organization_users WHERE orgid = $1 AND id = $2", orgID, orgUserID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil || n != 1 {
+		tx.Rollback()
+		return errors.New("Organization user " + orgUserID + " not found")
+	}
+
+	_, err = tx.Exec("DELETE FROM collection_users WHERE orguserid = $1", orgUserID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// Never leave an organization without an owner
+	var owners int
+	err = tx.QueryRow("SELECT COUNT(*) FROM organization_users WHERE orgid = $1 AND type = $2 AND status = $3", orgID, bw.OrgUserOwner, bw.OrgUserConfirmed).Scan(&owners)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if owners < 1 {
+		tx.Rollback()
+		return errors.New("Can't remove the last owner of organization " + orgID)
+	}
+
+	return tx.Commit()
+}
+
+// GetOrganizationUsers returns all the members and invited users of the organization
+func (db *DB) GetOrganizationUsers(orgID string) ([]bw.OrganizationUser, error) {
+	query := "SELECT " + orgUserCols + " WHERE ou.orgid = $1"
+	rows, err := db.db.Query(query, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orgUsers := make([]bw.OrganizationUser, 0) // Make an empty slice if there are none or android app will crash
+	for rows.Next() {
+		ou, err := sqlRowToOrgUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		orgUsers = append(orgUsers, ou)
+	}
+
+	return orgUsers, nil
+}
+
+// GetOrganizationUserByID looks up a membership by its own id
+func (db *DB) GetOrganizationUserByID(orgID string, orgUserID string) (bw.OrganizationUser, error) {
+	query := "SELECT " + orgUserCols + " WHERE ou.orgid = $1 AND ou.id = $2"
+	row := db.db.QueryRow(query, orgID, orgUserID)
+
+	return sqlRowToOrgUser(row)
+}
+
+// InviteOrganizationUser adds an invited user to the organization with access to the collections.
+// The token has to be sent back when the invitation is accepted
+func (db *DB) InviteOrganizationUser(ou bw.OrganizationUser, token string, collections []bw.SelectionReadOnly) (bw.OrganizationUser, error) {
+	orgUserID, err := uuid.NewV4()
+	if err != nil {
+		return bw.OrganizationUser{}, err
+	}
+
+	tx, err := db.db.Begin()
+	if err != nil {
+		return bw.OrganizationUser{}, err
+	}
+
+	var existing int
+	err = tx.QueryRow("SELECT COUNT(*) FROM organization_users WHERE orgid = $1 AND lower(email) = lower($2)", ou.OrganizationId, ou.Email).Scan(&existing)
+	if err != nil {
+		tx.Rollback()
+		return bw.OrganizationUser{}, err
+	}
+	if existing > 0 {
+		tx.Rollback()
+		return bw.OrganizationUser{}, errors.New(ou.Email + " is already in organization " + ou.OrganizationId)
+	}
+
+	accessAll := 0
+	if ou.AccessAll {
+		accessAll = 1
+	}
+
+	_, err = tx.Exec("INSERT INTO organization_users(id, orgid, email, status, type, accessall, token) values(?,?,?,?,?,?,?)",
+		orgUserID.String(), ou.OrganizationId, ou.Email, bw.OrgUserInvited, ou.Type, accessAll, token)
+	if err != nil {
+		tx.Rollback()
+		return bw.OrganizationUser{}, err
+	}
+
+	for _, col := range collections {
+		readOnly := 0
+		if col.ReadOnly {
+			readOnly = 1
+		}
+
+		// Only collections in the organization can be used
+		res, err := tx.Exec("INSERT INTO collection_users(collectionid, orguserid, readonly) SELECT id, $1, $2 FROM collections WHERE id = $3 AND orgid = $4", orgUserID.String(), readOnly, col.Id, ou.OrganizationId)
+		if err != nil {
+			tx.Rollback()
+			return bw.OrganizationUser{}, err
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil || n != 1 {
+			tx.Rollback()
+			return bw.OrganizationUser{}, errors.New("Collection " + col.Id + " not found")
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return bw.OrganizationUser{}, err
+	}
+
+	return db.GetOrganizationUserByID(ou.OrganizationId, orgUserID.String())
+}
+
+// AcceptOrganizationUser links the invitation to the account if the token is correct
+func (db *DB) AcceptOrganizationUser(orgID string, orgUserID string, userID string, token string) error {
+	iuserID, err := strconv.ParseInt(userID, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	stmt, err := db.db.Prepare("UPDATE organization_users SET userid=$1, status=$2, token=NULL WHERE orgid=$3 AND id=$4 AND status=$5 AND token=$6")
+	if err != nil {
+		return err
+	}
+
+	res, err := stmt.Exec(iuserID, bw.OrgUserAccepted, orgID, orgUserID, bw.OrgUserInvited, token)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil || n != 1 {
+		return errors.New("Invalid invitation to organization " + orgID)
+	}
+
+	return nil
+}
+
+// ConfirmOrganizationUser gives an accepted user the organization key encrypted with the user's public key.
+// Important to check that the user is allowed to make changes!
+func (db *DB) ConfirmOrganizationUser(orgID string, orgUserID string, key string) error {
+	stmt, err := db.db.Prepare("UPDATE organization_users SET key=$1, status=$2 WHERE orgid=$3 AND id=$4 AND status=$5")
+	if err != nil {
+		return err
+	}
+
+	res, err := stmt.Exec(key, bw.OrgUserConfirmed, orgID, orgUserID, bw.OrgUserAccepted)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil || n != 1 {
+		return errors.New("Organization user " + orgUserID + " has not accepted the invitation")
+	}
+
+	return nil
+}
+
+// GetProfileOrganizations returns all the organizations the account is a member of
+func (db *DB) GetProfileOrganizations(userID string) ([]bw.ProfileOrganization, error) {
+	iuserID, err := strconv.ParseInt(userID, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	query := "SELECT " + orgUserCols + " WHERE ou.userid = $1"
+	rows, err := db.db.Query(query, iuserID)
+	if err != nil {
+		return nil, err
+	}
+
+	var orgUsers []bw.OrganizationUser
+	for rows.Next() {
+		ou, err := sqlRowToOrgUser(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		orgUsers = append(orgUsers, ou)
+	}
+	rows.Close()
+
+	orgs := make([]bw.ProfileOrganization, 0) // Make an empty slice if there are none or android app will crash
+	for _, ou := range orgUsers {
+		org, err := db.GetOrganization(ou.OrganizationId)
+		if err != nil {
+			return nil, err
+		}
+		orgs = append(orgs, org.GetProfileOrganization(ou))
+	}
+
+	return orgs, nil
+}
@@ -0,0 +1,364 @@
+package sqlite
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	bw "github.com/VictorNine/bitwarden-go/internal/common"
+	uuid "github.com/satori/go.uuid"
+)
+
+const sendsTbl = `
+CREATE TABLE IF NOT EXISTS "sends" (
+  id             TEXT,
+  accessid       TEXT,
+  userid         INTEGER,
+  type           INT,
+  name           TEXT,
+  notes          TEXT,
+  data           TEXT,
+  key            TEXT,
+  password       TEXT,
+  maxaccesscount INT,
+  accesscount    INT NOT NULL,
+  disabled       INT NOT NULL,
+  hideemail      INT NOT NULL,
+  revisiondate   INT,
+  expirationdate INT,
+  deletiondate   INT,
+  pendingsince   INT,
+PRIMARY KEY(id)
+)
+`
+
+// The columns sqlRowToSend expects
+const sendCols = "s.id, s.accessid, s.userid, s.type, s.name, s.notes, s.data, s.key, s.password, s.maxaccesscount, s.accesscount, s.disabled, s.hideemail, s.revisiondate, s.expirationdate, s.deletiondate"
+
+// The text or file of the send is stored as json
+type sendData struct {
+	Text *bw.SendText
+	File *bw.SendFile
+}
+
+func sqlRowToSend(row interface {
+	Scan(dest ...interface{}) error
+}, extra ...interface{}) (bw.Send, error) {
+	send := bw.Send{
+		Object: "send",
+	}
+
+	var iuser int64
+	var notes, password sql.NullString
+	var maxAccessCount, expirationDate sql.NullInt64
+	var disabled, hideEmail int
+	var revDate, delDate int64
+	var blob []byte
+	dest := []interface{}{&send.Id, &send.AccessId, &iuser, &send.Type, &send.Name, &notes, &blob, &send.Key, &password,
+		&maxAccessCount, &send.AccessCount, &disabled, &hideEmail, &revDate, &expirationDate, &delDate}
+	err := row.Scan(append(dest, extra...)...)
+	if err != nil {
+		return send, err
+	}
+
+	var data sendData
+	err = json.Unmarshal(blob, &data)
+	if err != nil {
+		return send, err
+	}
+	send.Text, send.File = data.Text, data.File
+
+	send.UserId = strconv.FormatInt(iuser, 10)
+	send.Disabled = disabled == 1
+	send.HideEmail = hideEmail == 1
+	send.RevisionDate = time.Unix(revDate, 0)
+	send.DeletionDate = time.Unix(delDate, 0)
+	if notes.Valid {
+		send.Notes = &notes.String
+	}
+	if password.Valid {
+		send.Password = &password.String
+	}
+	if maxAccessCount.Valid {
+		n := int(maxAccessCount.Int64)
+		send.MaxAccessCount = &n
+	}
+	if expirationDate.Valid {
+		d := time.Unix(expirationDate.Int64, 0)
+		send.ExpirationDate = &d
+	}
+
+	return send, nil
+}
+
+// The values of the columns that can be changed
+func sendValues(send bw.Send) ([]interface{}, error) {
+	blob, err := json.Marshal(sendData{Text: send.Text, File: send.File})
+	if err != nil {
+		return nil, err
+	}
+
+	var expirationDate *int64
+	if send.ExpirationDate != nil {
+		d := send.ExpirationDate.Unix()
+		expirationDate = &d
+	}
+
+	disabled, hideEmail := 0, 0
+	if send.Disabled {
+		disabled = 1
+	}
+	if send.HideEmail {
+		hideEmail = 1
+	}
+
+	return []interface{}{send.Name, send.Notes, blob, send.Key, send.Password, send.MaxAccessCount, disabled, hideEmail,
+		send.RevisionDate.Unix(), expirationDate, send.DeletionDate.Unix()}, nil
+}
+
+func (db *DB) NewSend(send bw.Send) (bw.Send, error) {
+	iuser, err := strconv.ParseInt(send.UserId, 10, 64)
+	if err != nil {
+		return bw.Send{}, err
+	}
+
+	newID, err := uuid.NewV4()
+	if err != nil {
+		return bw.Send{}, err
+	}
+
+	// The official server uses the id in url safe base64
+	send.Id = newID.String()
+	send.AccessId = base64.RawURLEncoding.EncodeToString(newID.Bytes())
+	send.AccessCount = 0
+	send.RevisionDate = time.Now()
+	send.Object = "send"
+
+	if send.File != nil {
+		fileID, err := uuid.NewV4()
+		if err != nil {
+			return bw.Send{}, err
+		}
+		send.File.Id = fileID.String()
+	}
+
+	values, err := sendValues(send)
+	if err != nil {
+		return bw.Send{}, err
+	}
+
+	// A file send is pending until the file has been uploaded
+	var pendingSince *int64
+	if send.File != nil {
+		now := send.RevisionDate.Unix()
+		pendingSince = &now
+	}
+
+	stmt, err := db.db.Prepare(`INSERT INTO sends(name, notes, data, key, password, maxaccesscount, disabled, hideemail, revisiondate, expirationdate, deletiondate,
+  id, accessid, userid, type, pendingsince, accesscount) values(?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,0)`)
+	if err != nil {
+		return bw.Send{}, err
+	}
+
+	_, err = stmt.Exec(append(values, send.Id, send.AccessId, iuser, send.Type, pendingSince)...)
+	if err != nil {
+		return bw.Send{}, err
+	}
+
+	return send, nil
+}
+
+func (db *DB) GetSend(owner string, sendID string) (bw.Send, error) {
+	iowner, err := strconv.ParseInt(owner, 10, 64)
+	if err != nil {
+		return bw.Send{}, err
+	}
+
+	row := db.db.QueryRow("SELECT "+sendCols+" FROM sends s WHERE s.userid = $1 AND s.id = $2", iowner, sendID)
+	return sqlRowToSend(row)
+}
+
+func getSends(q interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}, query string, args ...interface{}) ([]bw.Send, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sends := make([]bw.Send, 0) // Make an empty slice if there are none or android app will crash
+	for rows.Next() {
+		send, err := sqlRowToSend(rows)
+		if err != nil {
+			return nil, err
+		}
+		sends = append(sends, send)
+	}
+
+	return sends, rows.Err()
+}
+
+// GetSends returns the sends of the owner except the file sends that haven't got their file yet
+func (db *DB) GetSends(owner string) ([]bw.Send, error) {
+	iowner, err := strconv.ParseInt(owner, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	return getSends(db.db, "SELECT "+sendCols+" FROM sends s WHERE s.userid = $1 AND s.pendingsince IS NULL", iowner)
+}
+
+// GetPendingSends returns the file sends of the owner that haven't got their file yet
+func (db *DB) GetPendingSends(owner string) ([]bw.Send, error) {
+	iowner, err := strconv.ParseInt(owner, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	return getSends(db.db, "SELECT "+sendCols+" FROM sends s WHERE s.userid = $1 AND s.pendingsince IS NOT NULL", iowner)
+}
+
+// SendFileUploaded marks the file send as complete so it can be synced and accessed
+func (db *DB) SendFileUploaded(owner string, sendID string) error {
+	iowner, err := strconv.ParseInt(owner, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	res, err := db.db.Exec("UPDATE sends SET pendingsince=NULL WHERE userid = $1 AND id = $2 AND pendingsince IS NOT NULL", iowner, sendID)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil || n != 1 {
+		return errors.New("Send " + sendID + " is not waiting for a file")
+	}
+
+	return nil
+}
+
+// GetSendByAccessID returns the send and the email of its creator
+func (db *DB) GetSendByAccessID(accessID string) (bw.Send, string, error) {
+	var email string
+	row := db.db.QueryRow("SELECT "+sendCols+", a.email FROM sends s JOIN accounts a ON a.id = s.userid WHERE s.accessid = $1 AND s.pendingsince IS NULL", accessID)
+	send, err := sqlRowToSend(row, &email)
+	return send, email, err
+}
+
+// UpdateSend changes everything except the type and the access count
+func (db *DB) UpdateSend(send bw.Send) error {
+	iuser, err := strconv.ParseInt(send.UserId, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	values, err := sendValues(send)
+	if err != nil {
+		return err
+	}
+
+	stmt, err := db.db.Prepare(`UPDATE sends SET name=?, notes=?, data=?, key=?, password=?, maxaccesscount=?, disabled=?, hideemail=?, revisiondate=?, expirationdate=?, deletiondate=?
+  WHERE id=? AND userid=?`)
+	if err != nil {
+		return err
+	}
+
+	res, err := stmt.Exec(append(values, send.Id, iuser)...)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil || n != 1 {
+		return errors.New("Send " + send.Id + " not found")
+	}
+
+	return nil
+}
+
+// AccessSend counts an access and returns the new access count.
+// Fails if the max access count has been reached
+func (db *DB) AccessSend(sendID string) (int, error) {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := tx.Exec("UPDATE sends SET accesscount = accesscount + 1 WHERE id = $1 AND (maxaccesscount IS NULL OR accesscount < maxaccesscount)", sendID)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil || n != 1 {
+		tx.Rollback()
+		return 0, errors.New("Send " + sendID + " has reached the max access count")
+	}
+
+	var count int
+	err = tx.QueryRow("SELECT accesscount FROM sends WHERE id = $1", sendID).Scan(&count)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	return count, tx.Commit()
+}
+
+func (db *DB) DeleteSend(owner string, sendID string) error {
+	iowner, err := strconv.ParseInt(owner, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	res, err := db.db.Exec("DELETE FROM sends WHERE userid = $1 AND id = $2", iowner, sendID)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil || n != 1 {
+		return errors.New("Send " + sendID + " not found")
+	}
+
+	return nil
+}
+
+// PurgeSends deletes the sends with a deletion date before the given time.
+// Returns the deleted sends so the files can be removed
+func (db *DB) PurgeSends(before time.Time) ([]bw.Send, error) {
+	return db.purgeSends("deletiondate < $1", before.Unix())
+}
+
+// PurgePendingSends deletes the file sends created before the given time that never got their file.
+// Returns the deleted sends so any data left from a failed upload can be removed
+func (db *DB) PurgePendingSends(before time.Time) ([]bw.Send, error) {
+	return db.purgeSends("pendingsince < $1", before.Unix())
+}
+
+func (db *DB) purgeSends(where string, args ...interface{}) ([]bw.Send, error) {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	sends, err := getSends(tx, "SELECT "+sendCols+" FROM sends s WHERE s."+where, args...)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	_, err = tx.Exec("DELETE FROM sends WHERE "+where, args...)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	return sends, tx.Commit()
+}
@@ -0,0 +1,66 @@
+package sqlite
+
+import (
+	"testing"
+	"time"
+
+	bw "github.com/VictorNine/bitwarden-go/internal/common"
+)
+
+func TestPendingSends(t *testing.T) {
+	db, done := newTestDB(t)
+	defer done()
+
+	acc := newTestAccount(t, db, "nobody@example.com")
+	newFileSend := func() bw.Send {
+		send, err := db.NewSend(bw.Send{UserId: acc.Id, Type: bw.SendTypeFile, Key: "2.sendkey", Name: "2.send",
+			File: &bw.SendFile{FileName: "2.file"}, DeletionDate: time.Now().Add(time.Hour)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return send
+	}
+	uploaded, pending := newFileSend(), newFileSend()
+
+	// The file send can't be synced or accessed before the file is uploaded
+	sends, err := db.GetSends(acc.Id)
+	if err != nil || len(sends) != 0 {
+		t.Fatalf("Expected no sends got %v %v", sends, err)
+	}
+	if _, _, err = db.GetSendByAccessID(uploaded.AccessId); err == nil {
+		t.Fatal("Pending send can be accessed")
+	}
+
+	if err = db.SendFileUploaded(acc.Id, uploaded.Id); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.SendFileUploaded(acc.Id, uploaded.Id); err == nil {
+		t.Error("Send marked as uploaded twice")
+	}
+
+	sends, err = db.GetSends(acc.Id)
+	if err != nil || len(sends) != 1 || sends[0].Id != uploaded.Id {
+		t.Fatalf("Expected only %s got %v %v", uploaded.Id, sends, err)
+	}
+	sends, err = db.GetPendingSends(acc.Id)
+	if err != nil || len(sends) != 1 || sends[0].Id != pending.Id {
+		t.Fatalf("Expected only %s pending got %v %v", pending.Id, sends, err)
+	}
+
+	// Nothing is purged before the upload time has passed
+	sends, err = db.PurgePendingSends(time.Now().Add(-time.Hour))
+	if err != nil || len(sends) != 0 {
+		t.Fatalf("Expected nothing purged got %v %v", sends, err)
+	}
+
+	sends, err = db.PurgePendingSends(time.Now().Add(time.Second))
+	if err != nil || len(sends) != 1 || sends[0].Id != pending.Id {
+		t.Fatalf("Expected only %s purged got %v %v", pending.Id, sends, err)
+	}
+	if _, err = db.GetSend(acc.Id, pending.Id); err == nil {
+		t.Error("Pending send not deleted")
+	}
+	if _, err = db.GetSend(acc.Id, uploaded.Id); err != nil {
+		t.Error("Uploaded send deleted")
+	}
+}
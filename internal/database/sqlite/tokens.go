@@ -0,0 +1,78 @@
+package sqlite
+
+import (
+	"errors"
+	"strconv"
+	"time"
+)
+
+// One time tokens sent to the user, like the token needed to change the email
+const tokensTbl = `
+CREATE TABLE IF NOT EXISTS "tokens" (
+  userid       INTEGER,
+  purpose      TEXT,
+  token        TEXT,
+  data         TEXT,
+  expires      INT,
+PRIMARY KEY(userid, purpose)
+)
+`
+
+// SetToken stores the token for the purpose. Any older token for the same purpose is replaced
+func (db *DB) SetToken(userID string, purpose string, token string, data string, expires time.Time) error {
+	iuserID, err := strconv.ParseInt(userID, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	stmt, err := db.db.Prepare("INSERT OR REPLACE INTO tokens(userid, purpose, token, data, expires) values(?,?,?,?,?)")
+	if err != nil {
+		return err
+	}
+
+	_, err = stmt.Exec(iuserID, purpose, token, data, expires.Unix())
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// UseToken removes the token and returns the data stored with it.
+// Fails if the token is wrong or has expired
+func (db *DB) UseToken(userID string, purpose string, token string) (string, error) {
+	iuserID, err := strconv.ParseInt(userID, 10, 64)
+	if err != nil {
+		return "", err
+	}
+
+	tx, err := db.db.Begin()
+	if err != nil {
+		return "", err
+	}
+
+	var data string
+	var expires int64
+	err = tx.QueryRow("SELECT data, expires FROM tokens WHERE userid = $1 AND purpose = $2 AND token = $3", iuserID, purpose, token).Scan(&data, &expires)
+	if err != nil {
+		tx.Rollback()
+		return "", errors.New("Invalid token")
+	}
+
+	_, err = tx.Exec("DELETE FROM tokens WHERE userid = $1 AND purpose = $2", iuserID, purpose)
+	if err != nil {
+		tx.Rollback()
+		return "", err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return "", err
+	}
+
+	if time.Now().Unix() > expires {
+		return "", errors.New("The token has expired")
+	}
+
+	return data, nil
+}
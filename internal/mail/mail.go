@@ -0,0 +1,40 @@
+package mail
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+var errHeader = errors.New("Line breaks are not allowed in mail headers")
+
+// Writer writes the mails to w instead of sending them.
+// Useful when there is no mail server or for testing
+type Writer struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+func (m *Writer) Send(to string, subject string, body string) error {
+	if !validHeader(to) || !validHeader(subject) {
+		return errHeader
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, err := fmt.Fprintf(m.w, "Date: %s\nTo: %s\nSubject: %s\n\n%s\n\n", time.Now().Format(time.RFC1123Z), to, subject, body)
+	return err
+}
+
+// Make sure the value can't add headers to the mail
+func validHeader(v string) bool {
+	return !strings.ContainsAny(v, "\r\n")
+}
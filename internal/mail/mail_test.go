@@ -0,0 +1,29 @@
+package mail
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	m := NewWriter(&buf)
+
+	err := m.Send("nobody@example.com", "Hello", "The body")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := buf.String()
+	for _, s := range []string{"To: nobody@example.com\n", "Subject: Hello\n", "\n\nThe body\n"} {
+		if !strings.Contains(out, s) {
+			t.Fatalf("Expected %q in %q", s, out)
+		}
+	}
+
+	err = m.Send("nobody@example.com", "Hello\nBcc: someone@example.com", "The body")
+	if err == nil {
+		t.Fatal("Expected an error for a line break in the subject")
+	}
+}
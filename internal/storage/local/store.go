@@ -0,0 +1,97 @@
+package local
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Store keeps blobs as files in a local directory
+type Store struct {
+	dir string
+}
+
+func (s *Store) SetDir(d string) {
+	s.dir = d
+}
+
+// Open creates the storage directory if it's missing
+func (s *Store) Open() error {
+	return os.MkdirAll(s.dir, 0700)
+}
+
+// Make sure the name can't escape the storage directory
+func (s *Store) path(name string) (string, error) {
+	p := filepath.Join(s.dir, filepath.FromSlash(name))
+	if !strings.HasPrefix(p, filepath.Clean(s.dir)+string(filepath.Separator)) {
+		return "", errors.New("Invalid blob name " + name)
+	}
+
+	return p, nil
+}
+
+// Put writes everything from r to the blob and returns the number of bytes written
+func (s *Store) Put(name string, r io.Reader) (int64, error) {
+	p, err := s.path(name)
+	if err != nil {
+		return 0, err
+	}
+
+	err = os.MkdirAll(filepath.Dir(p), 0700)
+	if err != nil {
+		return 0, err
+	}
+
+	// Write to a temp file first so a failed upload never replaces a blob
+	f, err := ioutil.TempFile(filepath.Dir(p), ".upload")
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := io.Copy(f, r)
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return n, err
+	}
+
+	err = f.Close()
+	if err != nil {
+		os.Remove(f.Name())
+		return n, err
+	}
+
+	return n, os.Rename(f.Name(), p)
+}
+
+func (s *Store) Get(name string) (io.ReadCloser, error) {
+	p, err := s.path(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return os.Open(p)
+}
+
+// Delete removes the blob. Deleting a missing blob is not an error
+func (s *Store) Delete(name string) error {
+	p, err := s.path(name)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(p)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	// Clean up the parent directory. Fails if there are other blobs left
+	if filepath.Dir(p) != filepath.Clean(s.dir) {
+		os.Remove(filepath.Dir(p))
+	}
+
+	return nil
+}
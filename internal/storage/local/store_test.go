@@ -0,0 +1,58 @@
+package local
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := &Store{}
+	s.SetDir(dir)
+	err = s.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := s.Put("1/abc", strings.NewReader("encrypted"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 9 {
+		t.Fatalf("Expected 9 bytes got %v", n)
+	}
+
+	r, err := s.Get("1/abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(r)
+	r.Close()
+	if string(b) != "encrypted" {
+		t.Fatal("Got wrong data")
+	}
+
+	err = s.Delete("1/abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.Get("1/abc")
+	if !os.IsNotExist(err) {
+		t.Fatal("Blob not deleted")
+	}
+
+	for _, name := range []string{"../abc", "1/../../abc", ""} {
+		_, err = s.Put(name, strings.NewReader("data"))
+		if err == nil {
+			t.Errorf("Stored blob with invalid name %s", name)
+		}
+	}
+}